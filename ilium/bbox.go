@@ -0,0 +1,158 @@
+package ilium
+
+// An axis-aligned bounding box. An empty bounding box has PMin > PMax
+// in every dimension.
+type BBox struct {
+	PMin, PMax Point3
+}
+
+func MakeEmptyBBox() BBox {
+	inf := infFloat32(+1)
+	return BBox{Point3{inf, inf, inf}, Point3{-inf, -inf, -inf}}
+}
+
+func MakeBBoxFromPoint(p Point3) BBox {
+	return BBox{p, p}
+}
+
+func MakeBBoxFromPoints(p1, p2 Point3) BBox {
+	var b BBox
+	b.PMin = Point3{
+		minFloat32(p1.X, p2.X),
+		minFloat32(p1.Y, p2.Y),
+		minFloat32(p1.Z, p2.Z),
+	}
+	b.PMax = Point3{
+		maxFloat32(p1.X, p2.X),
+		maxFloat32(p1.Y, p2.Y),
+		maxFloat32(p1.Z, p2.Z),
+	}
+	return b
+}
+
+func (out *BBox) Union(b1, b2 *BBox) {
+	out.PMin = Point3{
+		minFloat32(b1.PMin.X, b2.PMin.X),
+		minFloat32(b1.PMin.Y, b2.PMin.Y),
+		minFloat32(b1.PMin.Z, b2.PMin.Z),
+	}
+	out.PMax = Point3{
+		maxFloat32(b1.PMax.X, b2.PMax.X),
+		maxFloat32(b1.PMax.Y, b2.PMax.Y),
+		maxFloat32(b1.PMax.Z, b2.PMax.Z),
+	}
+}
+
+func (out *BBox) UnionPoint(b *BBox, p *Point3) {
+	pBBox := MakeBBoxFromPoint(*p)
+	out.Union(b, &pBBox)
+}
+
+func (b *BBox) IsEmpty() bool {
+	return b.PMin.X > b.PMax.X || b.PMin.Y > b.PMax.Y ||
+		b.PMin.Z > b.PMax.Z
+}
+
+func (b *BBox) GetCenter() Point3 {
+	var c R3
+	c.Add((*R3)(&b.PMin), (*R3)(&b.PMax))
+	c.Scale(&c, 0.5)
+	return Point3(c)
+}
+
+func (b *BBox) GetDiagonal() Vector3 {
+	var d Vector3
+	d.GetOffset(&b.PMin, &b.PMax)
+	return d
+}
+
+// Returns the center and radius of a sphere that encloses b. An
+// empty box has a sphere of radius 0 at the origin.
+func (b *BBox) BoundingSphere() (center Point3, radius float32) {
+	if b.IsEmpty() {
+		return
+	}
+	center = b.GetCenter()
+	diagonal := b.GetDiagonal()
+	radius = 0.5 * diagonal.Norm()
+	return
+}
+
+func (b *BBox) SurfaceArea() float32 {
+	if b.IsEmpty() {
+		return 0
+	}
+	d := b.GetDiagonal()
+	return 2 * (d.X*d.Y + d.X*d.Z + d.Y*d.Z)
+}
+
+// Returns whether or not p is inside the bounding box, after
+// expanding it by epsilon times the length of its diagonal in every
+// direction.
+func (b *BBox) ContainsPointWithTolerance(p *Point3, epsilon float32) bool {
+	if b.IsEmpty() {
+		return false
+	}
+	d := b.GetDiagonal()
+	e := epsilon * d.Norm()
+	return p.X >= b.PMin.X-e && p.X <= b.PMax.X+e &&
+		p.Y >= b.PMin.Y-e && p.Y <= b.PMax.Y+e &&
+		p.Z >= b.PMin.Z-e && p.Z <= b.PMax.Z+e
+}
+
+// Returns the index of the axis along which the bounding box is
+// widest.
+func (b *BBox) MaximumExtent() int {
+	d := b.GetDiagonal()
+	if d.X > d.Y && d.X > d.Z {
+		return 0
+	} else if d.Y > d.Z {
+		return 1
+	}
+	return 2
+}
+
+// Returns the position of p relative to the bounding box along the
+// given axis, where 0 is at PMin and 1 is at PMax.
+func (b *BBox) GetRelativeOffset(p *Point3, axis int) float32 {
+	pMin := ((*R3)(&b.PMin)).GetComponent(axis)
+	pMax := ((*R3)(&b.PMax)).GetComponent(axis)
+	if pMax <= pMin {
+		return 0
+	}
+	return (((*R3)(p)).GetComponent(axis) - pMin) / (pMax - pMin)
+}
+
+// Returns whether or not the segment of the given ray between
+// ray.MinT and ray.MaxT overlaps the bounding box. invD must be the
+// component-wise inverse of ray.D.
+func (b *BBox) IntersectRay(ray *Ray, invD *R3) bool {
+	t0 := ray.MinT
+	t1 := ray.MaxT
+	o := (*R3)(&ray.O)
+	pMin := (*R3)(&b.PMin)
+	pMax := (*R3)(&b.PMax)
+	for axis := 0; axis < 3; axis++ {
+		invDAxis := invD.GetComponent(axis)
+		oAxis := o.GetComponent(axis)
+		tNear := (pMin.GetComponent(axis) - oAxis) * invDAxis
+		tFar := (pMax.GetComponent(axis) - oAxis) * invDAxis
+		if tNear > tFar {
+			tNear, tFar = tFar, tNear
+		}
+		// Pad tFar slightly to be robust against rounding
+		// errors for rays that graze the box (which also
+		// covers the flat boxes of axis-aligned triangles).
+		tFar *= 1 + 1e-5
+		if tNear > t0 {
+			t0 = tNear
+		}
+		if tFar < t1 {
+			t1 = tFar
+		}
+		if t0 > t1 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,317 @@
+package ilium
+
+// The number of buckets to use when evaluating split positions with
+// the surface area heuristic (SAH).
+const _BVH_SAH_BUCKET_COUNT = 12
+
+// The cost of traversing an interior node relative to the cost of
+// intersecting a primitive.
+const _BVH_TRAVERSAL_COST float32 = 0.125
+
+type bvhPrimitiveInfo struct {
+	bounds   BBox
+	centroid Point3
+}
+
+// A node of a BVH, stored in depth-first order so that the first
+// child of an interior node immediately follows it.
+type bvhLinearNode struct {
+	bounds BBox
+	// For leaf nodes, the index of the first primitive; for
+	// interior nodes, the index of the second child.
+	offset int
+	// Zero for interior nodes.
+	primitiveCount int
+	// The axis along which an interior node was split.
+	axis int
+}
+
+// A BVHAggregate is a bounding volume hierarchy over a list of
+// primitives, which makes intersection tests sublinear in the
+// number of primitives.
+type BVHAggregate struct {
+	primitives []Primitive
+	nodes      []bvhLinearNode
+}
+
+func MakeBVHAggregate(
+	config map[string]interface{},
+	namedPrimitives map[string]Primitive) *BVHAggregate {
+	primitives := makeChildPrimitives(config, namedPrimitives)
+	var maxPrimitivesInNode int
+	if maxPrimitivesInNodeConfig, ok := config["maxPrimitivesInNode"]; ok {
+		maxPrimitivesInNode = int(maxPrimitivesInNodeConfig.(float64))
+	} else {
+		maxPrimitivesInNode = 4
+	}
+	return MakeBVHAggregateFromPrimitives(primitives, maxPrimitivesInNode)
+}
+
+func MakeBVHAggregateFromPrimitives(
+	primitives []Primitive, maxPrimitivesInNode int) *BVHAggregate {
+	if maxPrimitivesInNode < 1 {
+		panic("maxPrimitivesInNode must be positive")
+	}
+	infos := make([]bvhPrimitiveInfo, len(primitives))
+	for i, primitive := range primitives {
+		bounds := primitive.WorldBound()
+		infos[i] = bvhPrimitiveInfo{bounds, bounds.GetCenter()}
+	}
+	// Copy the primitives since they are reordered during the
+	// build.
+	orderedPrimitives := make([]Primitive, len(primitives))
+	copy(orderedPrimitives, primitives)
+	bvh := &BVHAggregate{primitives: orderedPrimitives}
+	if len(primitives) > 0 {
+		bvh.buildRecursive(infos, 0, len(primitives),
+			maxPrimitivesInNode)
+	}
+	return bvh
+}
+
+func (bvh *BVHAggregate) swapPrimitives(
+	infos []bvhPrimitiveInfo, i, j int) {
+	infos[i], infos[j] = infos[j], infos[i]
+	bvh.primitives[i], bvh.primitives[j] =
+		bvh.primitives[j], bvh.primitives[i]
+}
+
+// Reorders the primitives in [start, end) so that the ones for which
+// isBelow returns true come first, and returns the index of the first
+// one for which it returns false.
+func (bvh *BVHAggregate) partition(
+	infos []bvhPrimitiveInfo, start, end int,
+	isBelow func(info *bvhPrimitiveInfo) bool) int {
+	mid := start
+	for i := start; i < end; i++ {
+		if isBelow(&infos[i]) {
+			bvh.swapPrimitives(infos, i, mid)
+			mid++
+		}
+	}
+	return mid
+}
+
+// Reorders the primitives in [start, end) so that the primitive at
+// index mid has the centroid it would have if the primitives were
+// sorted by centroid along the given axis, with no larger centroids
+// before it and no smaller ones after it.
+func (bvh *BVHAggregate) selectByCentroid(
+	infos []bvhPrimitiveInfo, start, end, mid, axis int) {
+	for end-start > 1 {
+		pivot := ((*R3)(&infos[(start+end)/2].centroid)).
+			GetComponent(axis)
+		lessEnd := bvh.partition(infos, start, end,
+			func(info *bvhPrimitiveInfo) bool {
+				return ((*R3)(&info.centroid)).
+					GetComponent(axis) < pivot
+			})
+		equalEnd := bvh.partition(infos, lessEnd, end,
+			func(info *bvhPrimitiveInfo) bool {
+				return ((*R3)(&info.centroid)).
+					GetComponent(axis) == pivot
+			})
+		if mid < lessEnd {
+			end = lessEnd
+		} else if mid < equalEnd {
+			return
+		} else {
+			start = equalEnd
+		}
+	}
+}
+
+// Returns the index to split [start, end) at using the surface area
+// heuristic, or -1 if it is cheaper to make a leaf node.
+func (bvh *BVHAggregate) computeSAHSplit(
+	infos []bvhPrimitiveInfo, start, end, axis int,
+	bounds, centroidBounds *BBox, maxPrimitivesInNode int) int {
+	var bucketCounts [_BVH_SAH_BUCKET_COUNT]int
+	var bucketBounds [_BVH_SAH_BUCKET_COUNT]BBox
+	for i := 0; i < _BVH_SAH_BUCKET_COUNT; i++ {
+		bucketBounds[i] = MakeEmptyBBox()
+	}
+	computeBucket := func(info *bvhPrimitiveInfo) int {
+		b := int(_BVH_SAH_BUCKET_COUNT *
+			centroidBounds.GetRelativeOffset(&info.centroid, axis))
+		return minInt(b, _BVH_SAH_BUCKET_COUNT-1)
+	}
+	for i := start; i < end; i++ {
+		b := computeBucket(&infos[i])
+		bucketCounts[b]++
+		bucketBounds[b].Union(&bucketBounds[b], &infos[i].bounds)
+	}
+
+	totalArea := bounds.SurfaceArea()
+	minCost := infFloat32(+1)
+	minCostSplitBucket := -1
+	for i := 0; i < _BVH_SAH_BUCKET_COUNT-1; i++ {
+		b0 := MakeEmptyBBox()
+		b1 := MakeEmptyBBox()
+		var count0, count1 int
+		for j := 0; j <= i; j++ {
+			b0.Union(&b0, &bucketBounds[j])
+			count0 += bucketCounts[j]
+		}
+		for j := i + 1; j < _BVH_SAH_BUCKET_COUNT; j++ {
+			b1.Union(&b1, &bucketBounds[j])
+			count1 += bucketCounts[j]
+		}
+		if count0 == 0 || count1 == 0 {
+			continue
+		}
+		var cost float32
+		if totalArea > 0 {
+			cost = _BVH_TRAVERSAL_COST +
+				(float32(count0)*b0.SurfaceArea()+
+					float32(count1)*b1.SurfaceArea())/
+					totalArea
+		} else {
+			// All primitives are degenerate (e.g.,
+			// points), so just balance the counts.
+			cost = _BVH_TRAVERSAL_COST + float32(
+				maxInt(count0, count1))
+		}
+		if cost < minCost {
+			minCost = cost
+			minCostSplitBucket = i
+		}
+	}
+
+	primitiveCount := end - start
+	if minCostSplitBucket < 0 {
+		if primitiveCount <= maxPrimitivesInNode {
+			return -1
+		}
+		// All centroids fall into one bucket, so fall back to
+		// splitting into equal halves.
+		mid := (start + end) / 2
+		bvh.selectByCentroid(infos, start, end, mid, axis)
+		return mid
+	}
+
+	if primitiveCount <= maxPrimitivesInNode &&
+		minCost >= float32(primitiveCount) {
+		return -1
+	}
+
+	return bvh.partition(infos, start, end,
+		func(info *bvhPrimitiveInfo) bool {
+			return computeBucket(info) <= minCostSplitBucket
+		})
+}
+
+// Builds the subtree for the primitives in [start, end), appending
+// its nodes to bvh.nodes, and returns the index of its root node.
+func (bvh *BVHAggregate) buildRecursive(
+	infos []bvhPrimitiveInfo, start, end, maxPrimitivesInNode int) int {
+	bounds := MakeEmptyBBox()
+	centroidBounds := MakeEmptyBBox()
+	for i := start; i < end; i++ {
+		bounds.Union(&bounds, &infos[i].bounds)
+		centroidBounds.UnionPoint(&centroidBounds, &infos[i].centroid)
+	}
+
+	nodeIndex := len(bvh.nodes)
+	bvh.nodes = append(bvh.nodes, bvhLinearNode{bounds: bounds})
+
+	primitiveCount := end - start
+	axis := centroidBounds.MaximumExtent()
+	mid := -1
+	// If all the centroids coincide, there is no way to split
+	// the primitives spatially, so make a leaf.
+	if primitiveCount > 1 && ((*R3)(&centroidBounds.PMax)).
+		GetComponent(axis) > ((*R3)(&centroidBounds.PMin)).
+		GetComponent(axis) {
+		if primitiveCount <= 2 {
+			mid = (start + end) / 2
+			bvh.selectByCentroid(infos, start, end, mid, axis)
+		} else {
+			mid = bvh.computeSAHSplit(
+				infos, start, end, axis, &bounds,
+				&centroidBounds, maxPrimitivesInNode)
+		}
+	}
+
+	if mid < 0 {
+		bvh.nodes[nodeIndex].offset = start
+		bvh.nodes[nodeIndex].primitiveCount = primitiveCount
+		return nodeIndex
+	}
+
+	bvh.buildRecursive(infos, start, mid, maxPrimitivesInNode)
+	secondChildIndex := bvh.buildRecursive(
+		infos, mid, end, maxPrimitivesInNode)
+	bvh.nodes[nodeIndex].offset = secondChildIndex
+	bvh.nodes[nodeIndex].axis = axis
+	return nodeIndex
+}
+
+func (bvh *BVHAggregate) Intersect(
+	ray *Ray, intersection *Intersection) bool {
+	if len(bvh.nodes) == 0 {
+		return false
+	}
+
+	invD := R3{1 / ray.D.X, 1 / ray.D.Y, 1 / ray.D.Z}
+	dirIsNeg := [3]bool{invD.X < 0, invD.Y < 0, invD.Z < 0}
+
+	// Only the closest intersection is needed when intersection
+	// is non-nil, so shrink the ray as intersections are found.
+	tempRay := *ray
+	found := false
+	// The indices of nodes still to be visited.
+	todo := make([]int, 0, 64)
+	nodeIndex := 0
+	for {
+		node := &bvh.nodes[nodeIndex]
+		if node.bounds.IntersectRay(&tempRay, &invD) {
+			if node.primitiveCount > 0 {
+				for i := 0; i < node.primitiveCount; i++ {
+					primitive := bvh.primitives[node.offset+i]
+					if primitive.Intersect(
+						&tempRay, intersection) {
+						if intersection == nil {
+							return true
+						}
+						tempRay.MaxT = intersection.T
+						found = true
+					}
+				}
+			} else {
+				// Visit the nearer child first so that
+				// the farther one is more likely to be
+				// culled.
+				if dirIsNeg[node.axis] {
+					todo = append(todo, nodeIndex+1)
+					nodeIndex = node.offset
+				} else {
+					todo = append(todo, node.offset)
+					nodeIndex = nodeIndex + 1
+				}
+				continue
+			}
+		}
+		if len(todo) == 0 {
+			break
+		}
+		nodeIndex = todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+	}
+	return found
+}
+
+func (bvh *BVHAggregate) WorldBound() BBox {
+	if len(bvh.nodes) == 0 {
+		return MakeEmptyBBox()
+	}
+	return bvh.nodes[0].bounds
+}
+
+func (bvh *BVHAggregate) GetSensors() []Sensor {
+	return collectPrimitiveSensors(bvh.primitives)
+}
+
+func (bvh *BVHAggregate) GetLights() []Light {
+	return collectPrimitiveLights(bvh.primitives)
+}
@@ -0,0 +1,37 @@
+package ilium
+
+import "math"
+
+// A CheckerboardTexture alternates between two spectra in a
+// checkerboard pattern with the given number of checks along each
+// of u and v in [0, 1)^2. The check at the origin has the first
+// spectrum.
+type CheckerboardTexture struct {
+	value1, value2 Spectrum
+	uChecks        float32
+	vChecks        float32
+}
+
+func MakeCheckerboardTexture(
+	config map[string]interface{}) *CheckerboardTexture {
+	value1Config := config["value1"].(map[string]interface{})
+	value1 := MakeSpectrumFromConfig(value1Config)
+	value2Config := config["value2"].(map[string]interface{})
+	value2 := MakeSpectrumFromConfig(value2Config)
+	checksConfig := config["checks"].([]interface{})
+	uChecks := float32(checksConfig[0].(float64))
+	vChecks := float32(checksConfig[1].(float64))
+	if uChecks <= 0 || vChecks <= 0 {
+		panic("checks must be positive")
+	}
+	return &CheckerboardTexture{value1, value2, uChecks, vChecks}
+}
+
+func (ct *CheckerboardTexture) Evaluate(u, v float32) Spectrum {
+	i := int64(math.Floor(float64(u * ct.uChecks)))
+	j := int64(math.Floor(float64(v * ct.vChecks)))
+	if (i+j)%2 == 0 {
+		return ct.value1
+	}
+	return ct.value2
+}
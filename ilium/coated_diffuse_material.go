@@ -0,0 +1,319 @@
+package ilium
+
+import "math"
+
+// The number of steps used to integrate the Fresnel reflectance over
+// the hemisphere.
+const _COATED_DIFFUSE_FRESNEL_STEPS = 1024
+
+// A CoatedDiffuseMaterial is a diffuse base under a smooth or rough
+// dielectric coat, e.g. plastic or varnished wood, using a model like
+// the one from Weidlich and Wilkie's "Arbitrarily Layered Micro-Facet
+// Surfaces". The coat reflects light with the Fresnel reflectance of
+// the dielectric, either specularly for a smooth coat (if no
+// microfacet distribution is given) or with a microfacet BRDF for a
+// rough one, and the rest of the light is refracted into the coat,
+// reflected diffusely by the base, and refracted back out. Light
+// reflected back into the coat by its inner side is accounted for by
+// treating it as diffuse, which makes the BRDF of the base (1 -
+// F(th_i)) * (1 - F(th_o)) * rho / (pi * eta^2 * (1 - rho * F_dr)),
+// where F_dr is the average Fresnel reflectance over the inside
+// hemisphere. The coat is assumed to be thin and non-absorbing, and
+// the base BRDF uses the Fresnel reflectance of a smooth coat.
+//
+// The BRDF is symmetric, so it's the same for both transport types.
+// Directions are sampled by first picking either the coat or the
+// base based on the Fresnel reflectance at wo and the albedo of the
+// base. With a smooth coat, the material is a
+// PartiallySpecularMaterial whose specular component is the coat.
+type CoatedDiffuseMaterial struct {
+	rho Spectrum
+	eta float32
+	// If nil, the coat is smooth.
+	distribution MicrofacetDistribution
+	// dP/du at the intersection the material was evaluated at,
+	// if the distribution is anisotropic.
+	dpdu Vector3
+	// How the coat lobe is sampled, which is either
+	// distributionCosine or visibleNormals sampling.
+	samplingMethod MicrofacetSamplingMethod
+	// The average Fresnel reflectance over the inside
+	// hemisphere.
+	fdrInternal float32
+	// If non-nil, rho and distribution are given by these
+	// textures instead.
+	rhoTexture          SpectrumTexture
+	distributionTexture *microfacetDistributionTexture
+	// If non-nil, perturbs the shading normal.
+	normalPerturbation *shadingNormalPerturbation
+}
+
+// Returns the cosine-weighted average of the Fresnel reflectance
+// from inside a dielectric with the given index of refraction over
+// the hemisphere, i.e. the fraction of diffusely reflected light
+// that's reflected back inside.
+func computeInternalDiffuseFresnelReflectance(eta float32) float32 {
+	// F_dr = int_0^1 F(cos(th)) * 2 * cos(th) * d(cos(th)).
+	var fdr float32
+	for i := 0; i < _COATED_DIFFUSE_FRESNEL_STEPS; i++ {
+		cosTh := (float32(i) + 0.5) / _COATED_DIFFUSE_FRESNEL_STEPS
+		fdr += computeFresnelDielectric(cosTh, eta, 1) * 2 * cosTh
+	}
+	return fdr / _COATED_DIFFUSE_FRESNEL_STEPS
+}
+
+func MakeCoatedDiffuseMaterial(
+	config map[string]interface{}) *CoatedDiffuseMaterial {
+	rhoConfig := config["rho"].(map[string]interface{})
+	rho, rhoTexture := makeSpectrumOrTextureFromConfig(rhoConfig)
+	var eta float32 = 1.5
+	if etaConfig, ok := config["eta"]; ok {
+		eta = float32(etaConfig.(float64))
+	}
+	if eta <= 0 {
+		panic("eta must be positive")
+	}
+	var distribution MicrofacetDistribution
+	var distributionTexture *microfacetDistributionTexture
+	samplingMethod := MICROFACET_DISTRIBUTION_COSINE_SAMPLING
+	if _, ok := config["distribution"]; ok {
+		distribution, distributionTexture =
+			makeMicrofacetDistributionFromConfig(config)
+		typeDistribution := distribution
+		if distributionTexture != nil {
+			typeDistribution = distributionTexture.Evaluate(0, 0)
+		}
+		if typeDistribution.CanSampleVisibleWh() {
+			samplingMethod = MICROFACET_VISIBLE_NORMAL_SAMPLING
+		}
+	}
+	fdrInternal := computeInternalDiffuseFresnelReflectance(eta)
+	normalPerturbation := makeShadingNormalPerturbationFromConfig(config)
+	return &CoatedDiffuseMaterial{
+		rho:                 rho,
+		eta:                 eta,
+		distribution:        distribution,
+		samplingMethod:      samplingMethod,
+		fdrInternal:         fdrInternal,
+		rhoTexture:          rhoTexture,
+		distributionTexture: distributionTexture,
+		normalPerturbation:  normalPerturbation,
+	}
+}
+
+func (c *CoatedDiffuseMaterial) EvaluateAt(
+	intersection *Intersection) Material {
+	isAnisotropic := c.distribution != nil &&
+		c.distribution.IsAnisotropic()
+	if c.rhoTexture == nil && c.distributionTexture == nil &&
+		!isAnisotropic {
+		return c
+	}
+	u := intersection.U
+	v := intersection.V
+	evaluated := *c
+	if c.rhoTexture != nil {
+		evaluated.rho = c.rhoTexture.Evaluate(u, v)
+		evaluated.rhoTexture = nil
+	}
+	if c.distributionTexture != nil {
+		evaluated.distribution = c.distributionTexture.Evaluate(u, v)
+		evaluated.distributionTexture = nil
+	}
+	if evaluated.distribution != nil &&
+		evaluated.distribution.IsAnisotropic() {
+		evaluated.dpdu = intersection.DPDU
+	}
+	return &evaluated
+}
+
+func (c *CoatedDiffuseMaterial) ComputeShadingNormal(
+	intersection *Intersection) Normal3 {
+	if c.normalPerturbation == nil {
+		return intersection.ShadingN
+	}
+	return c.normalPerturbation.computeShadingNormal(intersection)
+}
+
+// Returns the probability of sampling the coat lobe given wo. The
+// coat is weighed by its Fresnel reflectance, and the base by the
+// fraction of light that's transmitted through the coat and
+// eventually reflected back out.
+func (c *CoatedDiffuseMaterial) computeCoatProbability(
+	absCosThO float32) float32 {
+	fO := computeFresnelDielectric(absCosThO, 1, c.eta)
+	rhoY := minFloat32(maxFloat32(c.rho.Y(), 0), 1)
+	baseWeight := (1 - fO) * rhoY * (1 - c.fdrInternal) /
+		(1 - rhoY*c.fdrInternal)
+	if fO+baseWeight <= 0 {
+		return 1
+	}
+	return fO / (fO + baseWeight)
+}
+
+func (c *CoatedDiffuseMaterial) SampleWi(
+	transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32) {
+	wi, fDivPdf, pdf, _ = c.SampleWiWithSpecular(
+		transportType, u1, u2, wo, n)
+	return
+}
+
+func (c *CoatedDiffuseMaterial) SampleWiWithSpecular(
+	transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32, isSpecular bool) {
+	cosThO := wo.DotNormal(&n)
+	if cosThO < _MICROFACET_COS_THETA_EPSILON {
+		return
+	}
+	absCosThO := cosThO
+
+	// Pick a lobe, and then reuse u1 to sample wi.
+	pCoat := c.computeCoatProbability(absCosThO)
+	if c.distribution == nil && u1 < pCoat {
+		// f = F(th_o) * delta(wi - R(wo)) with respect to
+		// projected solid angle, which is sampled with
+		// probability pCoat.
+		wi.Reflect(&wo, &n)
+		fO := computeFresnelDielectric(absCosThO, 1, c.eta)
+		fDivPdf = MakeConstantSpectrum(fO / pCoat)
+		return wi, fDivPdf, pCoat, true
+	}
+
+	i, j, k := makeMicrofacetFrame(n, &c.dpdu)
+	vo := convertToMicrofacetFrame(&wo, &i, &j, &k)
+
+	var vi R3
+	if u1 < pCoat {
+		u1 = minFloat32(u1/pCoat, _ONE_MINUS_EPSILON)
+		var vh R3
+		switch c.samplingMethod {
+		case MICROFACET_DISTRIBUTION_COSINE_SAMPLING:
+			vh = c.distribution.SampleWh(u1, u2)
+		case MICROFACET_VISIBLE_NORMAL_SAMPLING:
+			vh = c.distribution.SampleVisibleWh(&vo, u1, u2)
+		}
+		woDotWh := vo.Dot(&vh)
+		if woDotWh < _MICROFACET_COS_THETA_EPSILON {
+			return
+		}
+		vi.Scale(&vh, 2*woDotWh)
+		vi.Sub(&vi, &vo)
+	} else {
+		u1 = minFloat32(
+			(u1-pCoat)/(1-pCoat), _ONE_MINUS_EPSILON)
+		vi = cosineSampleHemisphere(u1, u2)
+	}
+	if vi.Z < _MICROFACET_COS_THETA_EPSILON {
+		return
+	}
+
+	var viW R3
+	viW.ConvertToCoordinateSystemNoAlias(&vi, &i, &j, &k)
+	wi = Vector3(viW)
+	f := c.ComputeF(transportType, wo, wi, n)
+	pdf = c.ComputePdf(transportType, wo, wi, n)
+	if f.IsBlack() || pdf == 0 {
+		return Vector3{}, Spectrum{}, 0, false
+	}
+	fDivPdf.ScaleInv(&f, pdf)
+	return
+}
+
+func (c *CoatedDiffuseMaterial) ComputeF(
+	transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) Spectrum {
+	cosThO := wo.DotNormal(&n)
+	if cosThO < _MICROFACET_COS_THETA_EPSILON {
+		return Spectrum{}
+	}
+	absCosThO := cosThO
+
+	cosThI := wi.DotNormal(&n)
+	if cosThI < _MICROFACET_COS_THETA_EPSILON {
+		return Spectrum{}
+	}
+	absCosThI := cosThI
+
+	// The coat, as in MicrofacetMaterial, but with a dielectric
+	// Fresnel term. A smooth coat is specular, so it's left out.
+	var fCoat Spectrum
+	if c.distribution != nil {
+		var wh Vector3
+		wh.Add(&wo, &wi)
+		wh.Normalize(&wh)
+		absWoDotWh := wo.Dot(&wh)
+
+		i, j, k := makeMicrofacetFrame(n, &c.dpdu)
+		vo := convertToMicrofacetFrame(&wo, &i, &j, &k)
+		vi := convertToMicrofacetFrame(&wi, &i, &j, &k)
+		vh := convertToMicrofacetFrame(&wh, &i, &j, &k)
+		D := c.distribution.ComputeD(&vh)
+		G := c.distribution.ComputeG(&vo, &vi, &vh)
+		fH := computeFresnelDielectric(absWoDotWh, 1, c.eta)
+		fCoat = MakeConstantSpectrum(
+			fH * D * G / (4 * absCosThO * absCosThI))
+	}
+
+	// The base.
+	fO := computeFresnelDielectric(absCosThO, 1, c.eta)
+	fI := computeFresnelDielectric(absCosThI, 1, c.eta)
+	var denominator Spectrum
+	denominator.Scale(&c.rho, -c.fdrInternal)
+	one := MakeConstantSpectrum(1)
+	denominator.Add(&denominator, &one)
+	var fBase Spectrum
+	fBase.Div(&c.rho, &denominator)
+	fBase.Scale(&fBase,
+		(1-fO)*(1-fI)/(math.Pi*c.eta*c.eta))
+
+	var f Spectrum
+	f.Add(&fCoat, &fBase)
+	return f
+}
+
+func (c *CoatedDiffuseMaterial) ComputePdf(
+	transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) float32 {
+	cosThO := wo.DotNormal(&n)
+	if cosThO < _MICROFACET_COS_THETA_EPSILON {
+		return 0
+	}
+	absCosThO := cosThO
+
+	cosThI := wi.DotNormal(&n)
+	if cosThI < _MICROFACET_COS_THETA_EPSILON {
+		return 0
+	}
+	absCosThI := cosThI
+
+	// A smooth coat is specular, so it's left out, except for
+	// the probability of not sampling it.
+	var pdfCoat float32
+	if c.distribution != nil {
+		var wh Vector3
+		wh.Add(&wo, &wi)
+		wh.Normalize(&wh)
+		absWoDotWh := wo.Dot(&wh)
+
+		i, j, k := makeMicrofacetFrame(n, &c.dpdu)
+		vo := convertToMicrofacetFrame(&wo, &i, &j, &k)
+		vh := convertToMicrofacetFrame(&wh, &i, &j, &k)
+		pdfWh := computeMicrofacetWhPdf(
+			c.samplingMethod, c.distribution, &vo, &vh)
+		// See MicrofacetMaterial.SampleWi() for the
+		// conversion from pdf(w_h) to the pdf of wi w.r.t.
+		// projected solid angle.
+		pdfCoat = pdfWh / (4 * absCosThI * absWoDotWh)
+	}
+	pdfBase := cosineHemispherePdfProjectedSolidAngle()
+
+	pCoat := c.computeCoatProbability(absCosThO)
+	return pCoat*pdfCoat + (1-pCoat)*pdfBase
+}
+
+func (c *CoatedDiffuseMaterial) IsSpecular() bool {
+	return false
+}
@@ -0,0 +1,156 @@
+package ilium
+
+import "math"
+import "math/rand"
+import "testing"
+
+// Returns a material with a smooth coat if roughness is 0.
+func makeTestCoatedDiffuseMaterial(
+	rho, roughness float64) *CoatedDiffuseMaterial {
+	config := map[string]interface{}{
+		"rho": map[string]interface{}{
+			"type": "rgb", "r": rho, "g": rho, "b": rho,
+		},
+		"eta": 1.5,
+	}
+	if roughness > 0 {
+		config["distribution"] = "ggx"
+		config["roughness"] = roughness
+	}
+	return MakeCoatedDiffuseMaterial(config)
+}
+
+// With a white base, the only light lost is from the single-scattering
+// microfacet coat, so the albedo should be at most 1, and close to it
+// away from grazing angles.
+func TestCoatedDiffuseWhiteFurnace(t *testing.T) {
+	for _, roughness := range []float64{0.2, 0.5} {
+		material := makeTestCoatedDiffuseMaterial(1, roughness)
+		for _, thO := range []float64{0, 0.5, 1, 1.3} {
+			wo := makeTestDirection(thO, 0.7)
+			albedo := integrateTestAlbedo(
+				material, MATERIAL_LIGHT_TRANSPORT, wo)
+			if albedo > 1+2e-3 || albedo < 0.8 {
+				t.Errorf("roughness=%f, th_o=%f: albedo=%f, "+
+					"expected in [0.8, 1]",
+					roughness, thO, albedo)
+			}
+		}
+	}
+}
+
+// With a smooth coat, the light not reflected specularly by the coat
+// is reflected diffusely by the white base, so the albedo of the
+// non-specular component plus the Fresnel reflectance at wo should be
+// 1.
+func TestCoatedDiffuseSmoothWhiteFurnace(t *testing.T) {
+	material := makeTestCoatedDiffuseMaterial(1, 0)
+	for _, thO := range []float64{0, 0.5, 1, 1.3} {
+		wo := makeTestDirection(thO, 0.7)
+		albedo := integrateTestAlbedo(
+			material, MATERIAL_LIGHT_TRANSPORT, wo)
+		fO := computeFresnelDielectric(
+			float32(math.Cos(thO)), 1, material.eta)
+		total := albedo + float64(fO)
+		if math.Abs(total-1) > 2e-3 {
+			t.Errorf("th_o=%f: albedo + F=%f + %f=%f, "+
+				"expected 1", thO, albedo, fO, total)
+		}
+	}
+}
+
+func TestCoatedDiffuseSymmetry(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := Normal3{0, 0, 1}
+	for _, roughness := range []float64{0, 0.3} {
+		material := makeTestCoatedDiffuseMaterial(0.5, roughness)
+		for i := 0; i < 1000; i++ {
+			wo := makeTestDirection(
+				rng.Float64()*math.Pi/2,
+				rng.Float64()*2*math.Pi)
+			wi := makeTestDirection(
+				rng.Float64()*math.Pi/2,
+				rng.Float64()*2*math.Pi)
+			f := material.ComputeF(
+				MATERIAL_LIGHT_TRANSPORT, wo, wi, n)
+			fSwapped := material.ComputeF(
+				MATERIAL_LIGHT_TRANSPORT, wi, wo, n)
+			fImportance := material.ComputeF(
+				MATERIAL_IMPORTANCE_TRANSPORT, wo, wi, n)
+			if math.Abs(float64(f.r-fSwapped.r)) >
+				1e-4*math.Max(1, float64(f.r)) {
+				t.Errorf("wo=%v, wi=%v: f=%f != f swapped=%f",
+					wo, wi, f.r, fSwapped.r)
+			}
+			if f != fImportance {
+				t.Errorf("wo=%v, wi=%v: f=%v != f "+
+					"importance=%v", wo, wi, f, fImportance)
+			}
+		}
+	}
+}
+
+func TestCoatedDiffuseSampleWi(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := Normal3{0, 0, 1}
+	for _, roughness := range []float64{0, 0.05, 0.3} {
+		material := makeTestCoatedDiffuseMaterial(0.5, roughness)
+		for i := 0; i < 1000; i++ {
+			wo := makeTestDirection(
+				rng.Float64()*math.Pi/2,
+				rng.Float64()*2*math.Pi)
+			wi, fDivPdf, pdf, isSpecular :=
+				material.SampleWiWithSpecular(
+					MATERIAL_LIGHT_TRANSPORT,
+					rng.Float32(), rng.Float32(), wo, n)
+			if pdf == 0 {
+				continue
+			}
+			if isSpecular {
+				checkTestSmoothCoatSample(
+					t, material, wo, wi, fDivPdf, pdf)
+				continue
+			}
+			expectedPdf := material.ComputePdf(
+				MATERIAL_LIGHT_TRANSPORT, wo, wi, n)
+			if math.Abs(float64(pdf-expectedPdf)) >
+				1e-4*float64(expectedPdf) {
+				t.Errorf("wo=%v, wi=%v: pdf=%f, expected %f",
+					wo, wi, pdf, expectedPdf)
+			}
+			f := material.ComputeF(
+				MATERIAL_LIGHT_TRANSPORT, wo, wi, n)
+			var expectedFDivPdf Spectrum
+			expectedFDivPdf.ScaleInv(&f, expectedPdf)
+			if math.Abs(float64(fDivPdf.r-expectedFDivPdf.r)) >
+				1e-4*float64(expectedFDivPdf.r) {
+				t.Errorf("wo=%v, wi=%v: f/pdf=%f, expected %f",
+					wo, wi, fDivPdf.r, expectedFDivPdf.r)
+			}
+		}
+	}
+}
+
+// Checks that a specular sample from a smooth coat is the reflection
+// of wo, picked with the probability of sampling the coat, and
+// weighted by the Fresnel reflectance.
+func checkTestSmoothCoatSample(t *testing.T,
+	material *CoatedDiffuseMaterial,
+	wo, wi Vector3, fDivPdf Spectrum, pdf float32) {
+	if material.distribution != nil {
+		t.Errorf("wo=%v: specular sample from a rough coat", wo)
+		return
+	}
+	expectedWi := Vector3{-wo.X, -wo.Y, wo.Z}
+	if wi != expectedWi {
+		t.Errorf("wo=%v: wi=%v, expected %v", wo, wi, expectedWi)
+	}
+	expectedPdf := material.computeCoatProbability(wo.Z)
+	if pdf != expectedPdf {
+		t.Errorf("wo=%v: pdf=%f, expected %f", wo, pdf, expectedPdf)
+	}
+	fO := computeFresnelDielectric(wo.Z, 1, material.eta)
+	if f := fDivPdf.r * pdf; math.Abs(float64(f-fO)) > 1e-5 {
+		t.Errorf("wo=%v: f=%f, expected %f", wo, f, fO)
+	}
+}
@@ -19,6 +19,20 @@ func rawParseConfig(inputPath string) (
 	return
 }
 
+// The keys of the input file paths of each config type. Like
+// _include paths, these are relative to the directory of the config
+// file they're in, so processIncludes() joins them (unless they're
+// absolute) with that directory before the config is used.
+var configFilePathKeys = map[string][]string{
+	"TriangleMesh":     {"path"},
+	"EnvironmentLight": {"path"},
+	"ImageTexture":     {"path"},
+	"IESLight":         {"path"},
+}
+
+// Replaces every object with an "_include" key by the config parsed
+// from the file at that path, and resolves the file paths listed in
+// configFilePathKeys, both relative to dir.
 func processIncludes(dir string, config interface{}, depth int) (
 	newConfig interface{}, err error) {
 	if depth >= 10 {
@@ -39,6 +53,15 @@ func processIncludes(dir string, config interface{}, depth int) (
 			return
 		}
 
+		if configType, ok := typedConfig["type"].(string); ok {
+			for _, k := range configFilePathKeys[configType] {
+				path, ok := typedConfig[k].(string)
+				if ok && !filepath.IsAbs(path) {
+					typedConfig[k] = filepath.Join(dir, path)
+				}
+			}
+		}
+
 		for k, v := range typedConfig {
 			v, err = processIncludes(dir, v, depth)
 			if err != nil {
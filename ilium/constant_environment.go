@@ -0,0 +1,40 @@
+package ilium
+
+import "math"
+
+// A constantEnvironment is an environmentRadiance that has the same
+// radiance in every direction. Directions are sampled uniformly.
+type constantEnvironment struct {
+	radiance Spectrum
+}
+
+// Makes an environment light with the same radiance in every
+// direction, which is useful for furnace tests.
+func MakeConstantEnvironmentLight(
+	config map[string]interface{}, sceneBound BBox) *EnvironmentLight {
+	radianceConfig := config["radiance"].(map[string]interface{})
+	radiance := MakeSpectrumFromConfig(radianceConfig)
+	return makeEnvironmentLight(
+		&constantEnvironment{radiance}, sceneBound)
+}
+
+func (ce *constantEnvironment) computeRadiance(w *Vector3) Spectrum {
+	return ce.radiance
+}
+
+func (ce *constantEnvironment) sampleDirection(u1, u2 float32) (
+	w Vector3, pdf float32) {
+	w = Vector3(uniformSampleSphere(u1, u2))
+	pdf = uniformSpherePdfSolidAngle()
+	return
+}
+
+func (ce *constantEnvironment) computeDirectionPdf(w *Vector3) float32 {
+	return uniformSpherePdfSolidAngle()
+}
+
+func (ce *constantEnvironment) computeIntegral() Spectrum {
+	var integral Spectrum
+	integral.Scale(&ce.radiance, 4*math.Pi)
+	return integral
+}
@@ -0,0 +1,102 @@
+package ilium
+
+// A DielectricMaterial is a smooth boundary between the outside,
+// assumed to be a vacuum, and a dielectric like glass on the inside
+// (i.e., the side opposite the normal). Light is either reflected or
+// refracted, in proportion to the Fresnel reflectance.
+type DielectricMaterial struct {
+	eta           float32
+	reflectance   Spectrum
+	transmittance Spectrum
+}
+
+func MakeDielectricMaterial(
+	config map[string]interface{}) *DielectricMaterial {
+	eta := float32(config["eta"].(float64))
+	if eta <= 0 {
+		panic("eta must be positive")
+	}
+	var reflectance Spectrum
+	if reflectanceConfig, ok := config["reflectance"]; ok {
+		reflectance = MakeSpectrumFromConfig(
+			reflectanceConfig.(map[string]interface{}))
+	} else {
+		reflectance = MakeConstantSpectrum(1)
+	}
+	var transmittance Spectrum
+	if transmittanceConfig, ok := config["transmittance"]; ok {
+		transmittance = MakeSpectrumFromConfig(
+			transmittanceConfig.(map[string]interface{}))
+	} else {
+		transmittance = MakeConstantSpectrum(1)
+	}
+	return &DielectricMaterial{eta, reflectance, transmittance}
+}
+
+// Returns the normal flipped to be on the same side as wo, the
+// indices of refraction on wo's side and the other side, and the
+// cosine of the angle between wo and the returned normal.
+func getDielectricFrame(wo Vector3, n Normal3, eta float32) (
+	nO Normal3, etaO, etaOther, cosThO float32) {
+	cosThO = wo.DotNormal(&n)
+	// Since normals point outside, wo is on the outside
+	// exactly when the ray that hit the surface is entering
+	// it.
+	if cosThO > 0 {
+		return n, 1, eta, cosThO
+	}
+	nO.Flip(&n)
+	return nO, eta, 1, -cosThO
+}
+
+func (d *DielectricMaterial) SampleWi(transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32) {
+	nO, etaO, etaOther, cosThO := getDielectricFrame(wo, n, d.eta)
+	if cosThO == 0 {
+		return
+	}
+	F := computeFresnelDielectric(cosThO, etaO, etaOther)
+	if u1 < F {
+		wi.Reflect(&wo, &nO)
+		// f = F * reflectance * delta(wi - R(wo)) with
+		// respect to projected solid angle, which is sampled
+		// with probability F.
+		fDivPdf = d.reflectance
+		pdf = F
+		return
+	}
+
+	if !wi.Refract(&wo, &nO, etaO/etaOther) {
+		// Shouldn't happen, since F = 1 for total internal
+		// reflection.
+		return
+	}
+	// f = (1 - F) * transmittance * delta(wi - T(wo)), which is
+	// sampled with probability 1 - F.
+	fDivPdf = d.transmittance
+	if transportType == MATERIAL_LIGHT_TRANSPORT {
+		// Radiance is compressed into a smaller solid angle
+		// when it enters a denser medium, which scales it by
+		// (eta_i / eta_o)^2. Importance is not affected,
+		// which makes the BTDF non-symmetric.
+		etaRatio := etaO / etaOther
+		fDivPdf.Scale(&fDivPdf, etaRatio*etaRatio)
+	}
+	pdf = 1 - F
+	return
+}
+
+func (d *DielectricMaterial) ComputeF(transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) Spectrum {
+	return Spectrum{}
+}
+
+func (d *DielectricMaterial) ComputePdf(transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) float32 {
+	return 0
+}
+
+func (d *DielectricMaterial) IsSpecular() bool {
+	return true
+}
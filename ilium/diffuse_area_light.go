@@ -1,6 +1,7 @@
 package ilium
 
 import "math"
+import "math/rand"
 
 type DiffuseAreaLightSamplingMethod int
 
@@ -12,9 +13,29 @@ const (
 type DiffuseAreaLight struct {
 	samplingMethod DiffuseAreaLightSamplingMethod
 	emission       Spectrum
+	// Whether light is emitted from the back of the surface, too.
+	twoSided bool
+	// If non-nil, the emitted radiance at a point is emission
+	// times this texture evaluated at the point's (u, v)
+	// coordinates.
+	emissionTexture SpectrumTexture
+	// If non-nil, points on the light are sampled from
+	// uvDistribution via this shape, which is the light's only
+	// shape, instead of uniformly by area.
+	uvShape        UVSampleableShape
+	uvDistribution Distribution2D
 	shapeSet       shapeSet
+	power          Spectrum
 }
 
+// The number of points used to estimate the power of a light with an
+// emission texture (see computePower()).
+const _DAL_POWER_SAMPLE_COUNT = 4096
+
+// The resolution at which to tabulate emission textures that aren't
+// images for sampling.
+const _DAL_TEXTURE_SAMPLING_RESOLUTION = 256
+
 func MakeDiffuseAreaLight(
 	config map[string]interface{}, shapes []Shape) *DiffuseAreaLight {
 	var samplingMethod DiffuseAreaLightSamplingMethod
@@ -29,8 +50,202 @@ func MakeDiffuseAreaLight(
 	}
 	emissionConfig := config["emission"].(map[string]interface{})
 	emission := MakeSpectrumFromConfig(emissionConfig)
+	twoSided := false
+	if twoSidedConfig, ok := config["twoSided"]; ok {
+		twoSided = twoSidedConfig.(bool)
+	}
 	shapeSet := MakeShapeSet(shapes)
-	return &DiffuseAreaLight{samplingMethod, emission, shapeSet}
+	// How to pick a shape to sample from a point, which matters
+	// for lights with many shapes (e.g., emissive meshes).
+	if shapeSelectionConfig, ok := config["shapeSelection"]; ok {
+		switch shapeSelectionConfig.(string) {
+		case "area":
+		case "lightBVH":
+			// Light is emitted up to 90 degrees from the
+			// normal.
+			shapeSet.buildLightBVH(0, twoSided)
+		default:
+			panic("unknown shape selection " +
+				shapeSelectionConfig.(string))
+		}
+	}
+	d := &DiffuseAreaLight{
+		samplingMethod: samplingMethod,
+		emission:       emission,
+		twoSided:       twoSided,
+		shapeSet:       shapeSet,
+	}
+	if textureConfig, ok := config["emissionTexture"]; ok {
+		d.emissionTexture = MakeSpectrumTexture(
+			textureConfig.(map[string]interface{}))
+		for _, shape := range shapes {
+			if _, ok := shape.(UVShape); !ok {
+				panic("emission texture needs shapes " +
+					"with (u, v) coordinates")
+			}
+		}
+	}
+	if sampleConfig, ok := config["sampleEmissionTexture"]; ok &&
+		sampleConfig.(bool) {
+		d.initTextureSampling(shapes)
+	}
+	d.power = d.computePower()
+	return d
+}
+
+// Makes the light sample points in proportion to its emission
+// texture, which works only for lights made of a single
+// UVSampleableShape.
+func (d *DiffuseAreaLight) initTextureSampling(shapes []Shape) {
+	if d.emissionTexture == nil {
+		panic("sampling the emission texture needs one")
+	}
+	if len(shapes) != 1 {
+		panic("sampling the emission texture needs a single shape")
+	}
+	uvShape, ok := shapes[0].(UVSampleableShape)
+	if !ok {
+		panic("sampling the emission texture needs a shape " +
+			"that can be sampled by (u, v)")
+	}
+	nu := _DAL_TEXTURE_SAMPLING_RESOLUTION
+	nv := _DAL_TEXTURE_SAMPLING_RESOLUTION
+	if imageTexture, ok := d.emissionTexture.(*ImageTexture); ok {
+		nu = imageTexture.width
+		nv = imageTexture.height
+	}
+	// The emitted power per unit (u, v) area is proportional to
+	// the texture times the surface area per unit (u, v) area.
+	f := tabulateSpectrumTexture(d.emissionTexture, nu, nv,
+		func(u, v float32) float32 {
+			_, _, _, areaPerUV := uvShape.ComputeSurfaceFromUV(u, v)
+			return areaPerUV
+		})
+	d.uvShape = uvShape
+	d.uvDistribution = MakeDistribution2D(f, nu, nv)
+}
+
+// Returns the power of the light, which is exact for lights without
+// an emission texture.
+//
+// With an emission texture, the power is only estimated, from the
+// emission at a fixed set of uniformly-sampled points (so that it's
+// the same from run to run). Its error thus depends on how much
+// the texture varies, e.g. small bright spots may be missed
+// entirely. This is good enough for picking lights, which is all
+// Power() is used for, since a bad estimate only makes light
+// selection less efficient and doesn't bias the result. (Integrating
+// over the texels instead would need the surface area covered by
+// each texel, which isn't known for arbitrary shapes.)
+func (d *DiffuseAreaLight) computePower() Spectrum {
+	var power Spectrum
+	if d.emissionTexture == nil {
+		power.Scale(&d.emission, d.getHemisphereCount()*
+			math.Pi*d.shapeSet.SurfaceArea())
+		return power
+	}
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < _DAL_POWER_SAMPLE_COUNT; i++ {
+		u := randFloat32(rng)
+		v1 := randFloat32(rng)
+		v2 := randFloat32(rng)
+		j, pSurface, _, _, pdf :=
+			d.shapeSet.sampleShapeSurface(u, v1, v2)
+		if pdf == 0 {
+			continue
+		}
+		Le := d.computeShapeEmission(j, pSurface)
+		Le.ScaleInv(&Le, pdf)
+		power.Add(&power, &Le)
+	}
+	power.Scale(&power, d.getHemisphereCount()*
+		math.Pi/_DAL_POWER_SAMPLE_COUNT)
+	return power
+}
+
+// Returns the number of hemispheres light is emitted into, i.e. 2 if
+// the light is two-sided and 1 otherwise.
+func (d *DiffuseAreaLight) getHemisphereCount() float32 {
+	if d.twoSided {
+		return 2
+	}
+	return 1
+}
+
+// Returns whether or not light is emitted from a surface with normal
+// nSurface in the direction wo.
+func (d *DiffuseAreaLight) emitsTowards(nSurface Normal3, wo Vector3) bool {
+	return d.twoSided || wo.DotNormal(&nSurface) >= 0
+}
+
+// Returns the radiance emitted, in any direction light is emitted
+// in, from the point on the surface with the given (u, v)
+// coordinates.
+func (d *DiffuseAreaLight) computeEmissionAtUV(u, v float32) Spectrum {
+	if d.emissionTexture == nil {
+		return d.emission
+	}
+	Le := d.emissionTexture.Evaluate(u, v)
+	Le.Mul(&Le, &d.emission)
+	return Le
+}
+
+// Like computeEmissionAtUV(), but for the given point on the surface
+// of the shape with the given index.
+func (d *DiffuseAreaLight) computeShapeEmission(
+	i int, pSurface Point3) Spectrum {
+	if d.emissionTexture == nil {
+		return d.emission
+	}
+	u, v := d.shapeSet.computeShapeSurfaceUV(i, pSurface)
+	return d.computeEmissionAtUV(u, v)
+}
+
+// Like computeEmissionAtUV(), but for the given point on the
+// surface, which has to be looked up among the shapes.
+func (d *DiffuseAreaLight) computeEmission(pSurface Point3) Spectrum {
+	if d.emissionTexture == nil {
+		return d.emission
+	}
+	u, v := d.shapeSet.computeSurfaceUV(pSurface)
+	return d.computeEmissionAtUV(u, v)
+}
+
+// Samples a point on the surface of the light and returns it, an
+// epsilon to use for rays starting or ending at that point, the
+// normal at that point, the value of the pdf with respect to surface
+// area at that point, which may be 0, and the radiance emitted from
+// that point.
+func (d *DiffuseAreaLight) sampleSurface(u, v1, v2 float32) (
+	pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, pdfSurfaceArea float32, Le Spectrum) {
+	if d.uvShape == nil {
+		var i int
+		i, pSurface, pSurfaceEpsilon, nSurface, pdfSurfaceArea =
+			d.shapeSet.sampleShapeSurface(u, v1, v2)
+		if pdfSurfaceArea == 0 {
+			return
+		}
+		Le = d.computeShapeEmission(i, pSurface)
+		return
+	}
+	uSurface, vSurface, pdfUV := d.uvDistribution.SampleContinuous(v1, v2)
+	pSurface, pSurfaceEpsilon, nSurface, areaPerUV :=
+		d.uvShape.ComputeSurfaceFromUV(uSurface, vSurface)
+	if pdfUV == 0 || areaPerUV == 0 {
+		return
+	}
+	pdfSurfaceArea = pdfUV / areaPerUV
+	Le = d.computeEmissionAtUV(uSurface, vSurface)
+	return
+}
+
+func (d *DiffuseAreaLight) HasSpecularPosition() bool {
+	return false
+}
+
+func (d *DiffuseAreaLight) HasSpecularDirection() bool {
+	return false
 }
 
 func (d *DiffuseAreaLight) GetSampleConfig() SampleConfig {
@@ -46,15 +261,31 @@ func (d *DiffuseAreaLight) SampleSurface(sampleBundle SampleBundle) (
 	u := sampleBundle.Samples1D[0][0].U
 	v1 := sampleBundle.Samples2D[0][0].U1
 	v2 := sampleBundle.Samples2D[0][0].U2
-	pSurface, pSurfaceEpsilon, nSurface, pdf =
-		d.shapeSet.SampleSurface(u, v1, v2)
-	LeSpatial := d.ComputeLeSpatial(pSurface)
+	pSurface, pSurfaceEpsilon, nSurface, pdf, Le :=
+		d.sampleSurface(u, v1, v2)
+	if pdf == 0 {
+		return
+	}
+	var LeSpatial Spectrum
+	LeSpatial.Scale(&Le, d.getHemisphereCount()*math.Pi)
 	LeSpatialDivPdf.ScaleInv(&LeSpatial, pdf)
 	return
 }
 
+// Samples a direction from the hemisphere around nSurface, or from
+// either hemisphere with equal probability if the light is two-sided.
 func (d *DiffuseAreaLight) sampleHemisphere(nSurface Normal3, u1, u2 float32) (
 	wo Vector3, absCosTh float32) {
+	k := R3(nSurface)
+	if d.twoSided {
+		// Reuse u1 to pick the side.
+		if u1 < 0.5 {
+			u1 *= 2
+		} else {
+			u1 = minFloat32(2*u1-1, _ONE_MINUS_EPSILON)
+			k.Invert(&k)
+		}
+	}
 	var wR3 R3
 	switch d.samplingMethod {
 	case DAL_UNIFORM_SAMPLING:
@@ -62,7 +293,6 @@ func (d *DiffuseAreaLight) sampleHemisphere(nSurface Normal3, u1, u2 float32) (
 	case DAL_COSINE_SAMPLING:
 		wR3 = cosineSampleHemisphere(u1, u2)
 	}
-	k := R3(nSurface)
 	var i, j R3
 	MakeCoordinateSystemNoAlias(&k, &i, &j)
 	var wR3w R3
@@ -78,15 +308,20 @@ func (d *DiffuseAreaLight) SampleDirection(
 	w1 := sampleBundle.Samples2D[1][0].U1
 	w2 := sampleBundle.Samples2D[1][0].U2
 	wo, absCosTh := d.sampleHemisphere(nSurface, w1, w2)
+	// For a two-sided light, both LeDirectional and pdf are
+	// halved, so their ratio stays the same.
+	hemisphereCount := d.getHemisphereCount()
 	switch d.samplingMethod {
 	case DAL_UNIFORM_SAMPLING:
 		// LeDirectional = 1 / pi and pdf = 1 / (2 * pi * |cos(th)|).
 		LeDirectionalDivPdf = MakeConstantSpectrum(2 * absCosTh)
-		pdf = uniformHemispherePdfSolidAngle() / absCosTh
+		pdf = uniformHemispherePdfSolidAngle() /
+			(hemisphereCount * absCosTh)
 	case DAL_COSINE_SAMPLING:
 		// LeDirectional = 1 / pi and pdf = 1 / pi.
 		LeDirectionalDivPdf = MakeConstantSpectrum(1)
-		pdf = cosineHemispherePdfProjectedSolidAngle()
+		pdf = cosineHemispherePdfProjectedSolidAngle() /
+			hemisphereCount
 	}
 	return
 }
@@ -98,21 +333,28 @@ func (d *DiffuseAreaLight) SampleRay(sampleBundle SampleBundle) (
 	v2 := sampleBundle.Samples2D[0][0].U2
 	w1 := sampleBundle.Samples2D[1][0].U1
 	w2 := sampleBundle.Samples2D[1][0].U2
-	pSurface, pSurfaceEpsilon, nSurface, pdfSurfaceArea :=
-		d.shapeSet.SampleSurface(u, v1, v2)
+	pSurface, pSurfaceEpsilon, nSurface, pdfSurfaceArea, Le :=
+		d.sampleSurface(u, v1, v2)
+	if pdfSurfaceArea == 0 {
+		return
+	}
 	wo, absCosTh := d.sampleHemisphere(nSurface, w1, w2)
 	ray = Ray{pSurface, wo, pSurfaceEpsilon, infFloat32(+1)}
+	// For a two-sided light, the pdf is halved.
+	hemisphereCount := d.getHemisphereCount()
 	switch d.samplingMethod {
 	case DAL_UNIFORM_SAMPLING:
 		// pdf = pdfSurfaceArea / (2 * pi * |cos(th)|).
-		LeDivPdf.Scale(
-			&d.emission, 2*math.Pi*absCosTh/pdfSurfaceArea)
-		pdf = pdfSurfaceArea *
-			uniformHemispherePdfSolidAngle() / absCosTh
+		LeDivPdf.Scale(&Le, hemisphereCount*
+			2*math.Pi*absCosTh/pdfSurfaceArea)
+		pdf = pdfSurfaceArea * uniformHemispherePdfSolidAngle() /
+			(hemisphereCount * absCosTh)
 	case DAL_COSINE_SAMPLING:
 		// pdf = pdfSurfaceArea / pi.
-		LeDivPdf.Scale(&d.emission, math.Pi/pdfSurfaceArea)
-		pdf = pdfSurfaceArea * cosineHemispherePdfProjectedSolidAngle()
+		LeDivPdf.Scale(&Le, hemisphereCount*math.Pi/pdfSurfaceArea)
+		pdf = pdfSurfaceArea *
+			cosineHemispherePdfProjectedSolidAngle() /
+			hemisphereCount
 	}
 	return
 }
@@ -121,8 +363,9 @@ func (d *DiffuseAreaLight) SampleLeFromPoint(
 	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
 	LeDivPdf Spectrum, pdf float32, wi Vector3,
 	pSurface Point3, nSurface Normal3, shadowRay Ray) {
-	pSurface, pSurfaceEpsilon, nSurface, pdf :=
-		d.shapeSet.SampleSurfaceFromPoint(u, v1, v2, p, pEpsilon, n)
+	i, pSurface, pSurfaceEpsilon, nSurface, pdf :=
+		d.shapeSet.sampleShapeSurfaceFromPoint(
+			u, v1, v2, p, pEpsilon, n)
 	if pdf == 0 {
 		return
 	}
@@ -130,7 +373,10 @@ func (d *DiffuseAreaLight) SampleLeFromPoint(
 	shadowRay = Ray{p, wi, pEpsilon, r * (1 - pSurfaceEpsilon)}
 	var wo Vector3
 	wo.Flip(&wi)
-	Le := d.ComputeLe(pSurface, nSurface, wo)
+	if !d.emitsTowards(nSurface, wo) {
+		return
+	}
+	Le := d.computeShapeEmission(i, pSurface)
 	LeDivPdf.ScaleInv(&Le, pdf)
 	return
 }
@@ -141,42 +387,73 @@ func (d *DiffuseAreaLight) ComputeLePdfFromPoint(
 }
 
 func (d *DiffuseAreaLight) ComputeLeSpatial(pSurface Point3) Spectrum {
-	var LeSpatial Spectrum
-	LeSpatial.Scale(&d.emission, math.Pi)
+	LeSpatial := d.computeEmission(pSurface)
+	LeSpatial.Scale(&LeSpatial, d.getHemisphereCount()*math.Pi)
 	return LeSpatial
 }
 
 func (d *DiffuseAreaLight) ComputeLeSpatialPdf(pSurface Point3) float32 {
-	return 1 / d.shapeSet.SurfaceArea()
+	if d.uvShape == nil {
+		return 1 / d.shapeSet.SurfaceArea()
+	}
+	u, v, _ := d.uvShape.ComputeSurfaceUV(pSurface)
+	_, _, _, areaPerUV := d.uvShape.ComputeSurfaceFromUV(u, v)
+	if areaPerUV == 0 {
+		return 0
+	}
+	return d.uvDistribution.ComputeContinuousPdf(u, v) / areaPerUV
 }
 
 func (d *DiffuseAreaLight) ComputeLeDirectional(
 	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
-	if wo.DotNormal(&nSurface) < 0 {
+	if !d.emitsTowards(nSurface, wo) {
 		return Spectrum{}
 	}
-	return MakeConstantSpectrum(1 / math.Pi)
+	return MakeConstantSpectrum(1 / (d.getHemisphereCount() * math.Pi))
 }
 
 func (d *DiffuseAreaLight) ComputeLeDirectionalPdf(
 	pSurface Point3, nSurface Normal3, wo Vector3) float32 {
 	cosTh := wo.DotNormal(&nSurface)
+	if d.twoSided {
+		cosTh = absFloat32(cosTh)
+	}
 	if cosTh <= 0 {
 		return 0
 	}
+	hemisphereCount := d.getHemisphereCount()
 	switch d.samplingMethod {
 	case DAL_UNIFORM_SAMPLING:
-		return uniformHemispherePdfSolidAngle() / cosTh
+		return uniformHemispherePdfSolidAngle() /
+			(hemisphereCount * cosTh)
 	case DAL_COSINE_SAMPLING:
-		return cosineHemispherePdfProjectedSolidAngle()
+		return cosineHemispherePdfProjectedSolidAngle() /
+			hemisphereCount
 	}
 	return 0
 }
 
 func (d *DiffuseAreaLight) ComputeLe(
 	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
-	if wo.DotNormal(&nSurface) < 0 {
+	if !d.emitsTowards(nSurface, wo) {
 		return Spectrum{}
 	}
-	return d.emission
+	return d.computeEmission(pSurface)
+}
+
+func (d *DiffuseAreaLight) computeLeWithUV(pSurface Point3, u, v float32,
+	nSurface Normal3, wo Vector3) Spectrum {
+	if !d.emitsTowards(nSurface, wo) {
+		return Spectrum{}
+	}
+	return d.computeEmissionAtUV(u, v)
+}
+
+func (d *DiffuseAreaLight) Power() Spectrum {
+	return d.power
+}
+
+func (d *DiffuseAreaLight) getLightBounds() (lightBounds, bool) {
+	power := d.Power()
+	return d.shapeSet.getLightBounds(power.Y(), 0, d.twoSided), true
 }
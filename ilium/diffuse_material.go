@@ -12,6 +12,10 @@ const (
 type DiffuseMaterial struct {
 	samplingMethod DiffuseMaterialSamplingMethod
 	rho            Spectrum
+	// If non-nil, rho is given by this texture instead.
+	rhoTexture SpectrumTexture
+	// If non-nil, perturbs the shading normal.
+	normalPerturbation *shadingNormalPerturbation
 }
 
 func MakeDiffuseMaterial(config map[string]interface{}) *DiffuseMaterial {
@@ -26,8 +30,29 @@ func MakeDiffuseMaterial(config map[string]interface{}) *DiffuseMaterial {
 		panic("unknown sampling method " + samplingMethodConfig)
 	}
 	rhoConfig := config["rho"].(map[string]interface{})
-	rho := MakeSpectrumFromConfig(rhoConfig)
-	return &DiffuseMaterial{samplingMethod, rho}
+	rho, rhoTexture := makeSpectrumOrTextureFromConfig(rhoConfig)
+	normalPerturbation := makeShadingNormalPerturbationFromConfig(config)
+	return &DiffuseMaterial{
+		samplingMethod, rho, rhoTexture, normalPerturbation,
+	}
+}
+
+func (d *DiffuseMaterial) EvaluateAt(intersection *Intersection) Material {
+	if d.rhoTexture == nil {
+		return d
+	}
+	evaluated := *d
+	evaluated.rho = d.rhoTexture.Evaluate(intersection.U, intersection.V)
+	evaluated.rhoTexture = nil
+	return &evaluated
+}
+
+func (d *DiffuseMaterial) ComputeShadingNormal(
+	intersection *Intersection) Normal3 {
+	if d.normalPerturbation == nil {
+		return intersection.ShadingN
+	}
+	return d.normalPerturbation.computeShadingNormal(intersection)
 }
 
 func (d *DiffuseMaterial) SampleWi(transportType MaterialTransportType,
@@ -85,3 +110,7 @@ func (d *DiffuseMaterial) ComputePdf(transportType MaterialTransportType,
 	}
 	return 0
 }
+
+func (d *DiffuseMaterial) IsSpecular() bool {
+	return false
+}
@@ -0,0 +1,188 @@
+package ilium
+
+// A DirectionalLight is a light infinitely far away that illuminates
+// the scene from a single direction, like the sun. Its radiance is a
+// delta distribution whose integral over the neighborhood of its
+// direction is the given irradiance (i.e., the power per unit area
+// perpendicular to its direction).
+//
+// Rays from the light start on a disk that covers the bounding
+// sphere of the scene, is perpendicular to the light's direction,
+// and lies just outside the bounding sphere. The disk's normal is
+// the light's direction, and pdfs with respect to projected solid
+// angle at the light are with respect to that normal.
+type DirectionalLight struct {
+	direction  Vector3
+	irradiance Spectrum
+	diskCenter Point3
+	diskRadius float32
+	diskX      Vector3
+	diskY      Vector3
+}
+
+func MakeDirectionalLight(
+	config map[string]interface{}, sceneBound BBox) *DirectionalLight {
+	// The direction in which the light travels.
+	direction := MakeVector3FromConfig(config["direction"])
+	direction.Normalize(&direction)
+	irradianceConfig := config["irradiance"].(map[string]interface{})
+	irradiance := MakeSpectrumFromConfig(irradianceConfig)
+
+	sceneCenter, sceneRadius := sceneBound.BoundingSphere()
+	if sceneRadius <= 0 {
+		panic("Directional light needs a scene with non-zero extent")
+	}
+	var offset Vector3
+	offset.Scale(&direction, -sceneRadius)
+	var diskCenter Point3
+	diskCenter.Shift(&sceneCenter, &offset)
+	var diskX, diskY Vector3
+	MakeCoordinateSystemNoAlias(
+		(*R3)(&direction), (*R3)(&diskX), (*R3)(&diskY))
+
+	return &DirectionalLight{
+		direction:  direction,
+		irradiance: irradiance,
+		diskCenter: diskCenter,
+		diskRadius: sceneRadius,
+		diskX:      diskX,
+		diskY:      diskY,
+	}
+}
+
+func (dl *DirectionalLight) computeDiskArea() float32 {
+	return dl.diskRadius * dl.diskRadius / uniformDiskPdfSurfaceArea()
+}
+
+func (dl *DirectionalLight) sampleDisk(sampleBundle SampleBundle) Point3 {
+	u1 := sampleBundle.Samples2D[0][0].U1
+	u2 := sampleBundle.Samples2D[0][0].U2
+	x, y := uniformSampleDisk(u1, u2)
+	var offsetX, offsetY Vector3
+	offsetX.Scale(&dl.diskX, x*dl.diskRadius)
+	offsetY.Scale(&dl.diskY, y*dl.diskRadius)
+	var p Point3
+	p.Shift(&dl.diskCenter, &offsetX)
+	p.Shift(&p, &offsetY)
+	return p
+}
+
+func (dl *DirectionalLight) HasSpecularPosition() bool {
+	return false
+}
+
+func (dl *DirectionalLight) HasSpecularDirection() bool {
+	return true
+}
+
+func (dl *DirectionalLight) GetSampleConfig() SampleConfig {
+	return SampleConfig{
+		Sample1DLengths: []int{},
+		Sample2DLengths: []int{1},
+	}
+}
+
+func (dl *DirectionalLight) SampleSurface(sampleBundle SampleBundle) (
+	pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, LeSpatialDivPdf Spectrum, pdf float32) {
+	pSurface = dl.sampleDisk(sampleBundle)
+	nSurface = Normal3(dl.direction)
+	// pdf = 1 / (disk area).
+	LeSpatialDivPdf.Scale(&dl.irradiance, dl.computeDiskArea())
+	pdf = 1 / dl.computeDiskArea()
+	return
+}
+
+func (dl *DirectionalLight) SampleDirection(
+	sampleBundle SampleBundle, pSurface Point3, nSurface Normal3) (
+	wo Vector3, LeDirectionalDivPdf Spectrum, pdf float32) {
+	wo = dl.direction
+	// Both LeDirectional and the pdf with respect to projected
+	// solid angle are just 1 (with an implicit delta
+	// distribution).
+	LeDirectionalDivPdf = MakeConstantSpectrum(1)
+	pdf = 1
+	return
+}
+
+func (dl *DirectionalLight) SampleRay(sampleBundle SampleBundle) (
+	ray Ray, LeDivPdf Spectrum, pdf float32) {
+	pSurface := dl.sampleDisk(sampleBundle)
+	ray = Ray{pSurface, dl.direction, 0, infFloat32(+1)}
+	// pdf = 1 / (disk area) (with an implicit delta
+	// distribution for the direction).
+	LeDivPdf.Scale(&dl.irradiance, dl.computeDiskArea())
+	pdf = 1 / dl.computeDiskArea()
+	return
+}
+
+func (dl *DirectionalLight) SampleLeFromPoint(
+	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
+	LeDivPdf Spectrum, pdf float32, wi Vector3,
+	pSurface Point3, nSurface Normal3, shadowRay Ray) {
+	wi.Flip(&dl.direction)
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	if absCosThI < PDF_COS_THETA_EPSILON {
+		wi = Vector3{}
+		return
+	}
+
+	// The point on the disk is the projection of p along the
+	// light's direction, so the pdf w.r.t. surface area is
+	// 1 / G(p <-> pSurface) = r^2 / |cos(thI)| (with an
+	// implicit delta distribution), and the pdf w.r.t.
+	// projected solid angle is 1 / |cos(thI)|.
+	var offset Vector3
+	offset.GetOffset(&dl.diskCenter, &p)
+	r := offset.Dot(&dl.direction)
+	var toDisk Vector3
+	toDisk.Scale(&wi, r)
+	pSurface.Shift(&p, &toDisk)
+	nSurface = Normal3(dl.direction)
+	LeDivPdf.Scale(&dl.irradiance, absCosThI)
+	pdf = 1 / absCosThI
+	// Nothing in the scene is past the disk.
+	shadowRay = Ray{p, wi, pEpsilon, infFloat32(+1)}
+	return
+}
+
+func (dl *DirectionalLight) ComputeLePdfFromPoint(
+	p Point3, pEpsilon float32, n Normal3, wi Vector3) float32 {
+	// Since we're assuming wi is the direction to the light,
+	// this is the same pdf as in SampleLeFromPoint().
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	return 1 / absCosThI
+}
+
+func (dl *DirectionalLight) ComputeLeSpatial(pSurface Point3) Spectrum {
+	return dl.irradiance
+}
+
+func (dl *DirectionalLight) ComputeLeSpatialPdf(pSurface Point3) float32 {
+	return 1 / dl.computeDiskArea()
+}
+
+func (dl *DirectionalLight) ComputeLeDirectional(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	// Since we're assuming wo is the light's direction, return
+	// 1 even though we have a delta directional distribution.
+	return MakeConstantSpectrum(1)
+}
+
+func (dl *DirectionalLight) ComputeLeDirectionalPdf(
+	pSurface Point3, nSurface Normal3, wo Vector3) float32 {
+	// Since we're assuming wo is the light's direction, return
+	// 1 even though we have a delta directional distribution.
+	return 1
+}
+
+func (dl *DirectionalLight) ComputeLe(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	return dl.irradiance
+}
+
+func (dl *DirectionalLight) Power() Spectrum {
+	var power Spectrum
+	power.Scale(&dl.irradiance, dl.computeDiskArea())
+	return power
+}
@@ -23,6 +23,26 @@ func MakeDisk(config map[string]interface{}) *Disk {
 	return &Disk{center, i, j, k, radius}
 }
 
+// Returns dP/du and dP/dv at the point with offset r from the center.
+func (d *Disk) computeDPDUAndDPDV(r *Vector3) (dpdu, dpdv Vector3) {
+	// u = phi / (2 * pi), so dP/du = 2 * pi * dP/d(phi), which
+	// is r rotated 90 degrees around the normal.
+	x := ((*R3)(r)).Dot(&d.i)
+	y := ((*R3)(r)).Dot(&d.j)
+	rRotated := R3{-2 * math.Pi * y, 2 * math.Pi * x, 0}
+	var w R3
+	w.ConvertToCoordinateSystemNoAlias(&rRotated, &d.i, &d.j, &d.k)
+	dpdu = Vector3(w)
+	// v = 1 - rho / radius, so dP/dv = -radius * r / |r|, taking
+	// phi = 0 at the center.
+	if r.NormSq() == 0 {
+		dpdv.Scale((*Vector3)(&d.i), -d.radius)
+	} else {
+		dpdv.Scale(r, -d.radius/r.Norm())
+	}
+	return
+}
+
 func (d *Disk) GetCenter() Point3 {
 	return d.center
 }
@@ -54,11 +74,31 @@ func (d *Disk) Intersect(ray *Ray, intersection *Intersection) bool {
 		intersection.P = pHit
 		intersection.PEpsilon = _DISK_EPSILON_SCALE * intersection.T
 		intersection.N = Normal3(d.k)
+		intersection.ShadingN = intersection.N
+		intersection.U, intersection.V, _ = d.ComputeSurfaceUV(pHit)
+		intersection.DPDU, intersection.DPDV =
+			d.computeDPDUAndDPDV(&r)
 	}
 
 	return true
 }
 
+func (d *Disk) WorldBound() BBox {
+	// The extent of the disk along each axis is determined by
+	// how much that axis is perpendicular to the normal.
+	e := Vector3{
+		d.radius * cosToSin(d.k.X),
+		d.radius * cosToSin(d.k.Y),
+		d.radius * cosToSin(d.k.Z),
+	}
+	var negE Vector3
+	negE.Flip(&e)
+	var pMin, pMax Point3
+	pMin.Shift(&d.center, &negE)
+	pMax.Shift(&d.center, &e)
+	return BBox{pMin, pMax}
+}
+
 func (d *Disk) SurfaceArea() float32 {
 	return math.Pi * d.radius * d.radius
 }
@@ -89,3 +129,44 @@ func (d *Disk) ComputePdfFromPoint(
 	p Point3, pEpsilon float32, n Normal3, wi Vector3) float32 {
 	return ComputeEntireSurfacePdfFromPoint(d, p, pEpsilon, n, wi)
 }
+
+func (d *Disk) GetNormalBound() (axis Vector3, cosTheta float32) {
+	return Vector3(d.k), 1
+}
+
+// The (u, v) coordinates of a disk are u = phi / (2 * pi), with phi
+// measured from d.i towards d.j, and v = 1 - r / radius, so v = 1 at
+// the center.
+func (d *Disk) ComputeSurfaceUV(pSurface Point3) (u, v float32, ok bool) {
+	var r Vector3
+	r.GetOffset(&d.center, &pSurface)
+	x := ((*R3)(&r)).Dot(&d.i)
+	y := ((*R3)(&r)).Dot(&d.j)
+	z := ((*R3)(&r)).Dot(&d.k)
+	phi := float32(math.Atan2(float64(y), float64(x)))
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+	u = minFloat32(phi/(2*math.Pi), _ONE_MINUS_EPSILON)
+	rho := sqrtFloat32(x*x + y*y)
+	v = maxFloat32(1-rho/d.radius, 0)
+	epsilon := _DISK_EPSILON_SCALE * d.radius
+	ok = absFloat32(z) <= epsilon && rho <= d.radius+epsilon
+	return
+}
+
+func (d *Disk) ComputeSurfaceFromUV(u, v float32) (
+	pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, areaPerUV float32) {
+	rho := d.radius * (1 - v)
+	sinPhi, cosPhi := sincosFloat32(2 * math.Pi * u)
+	r := R3{rho * cosPhi, rho * sinPhi, 0}
+	var rW R3
+	rW.ConvertToCoordinateSystemNoAlias(&r, &d.i, &d.j, &d.k)
+	pSurface.Shift(&d.center, (*Vector3)(&rW))
+	pSurfaceEpsilon = _DISK_EPSILON_SCALE * d.radius
+	nSurface = Normal3(d.k)
+	// dA = rho * d(rho) * d(phi) = rho * radius * dv * 2 * pi * du.
+	areaPerUV = 2 * math.Pi * d.radius * rho
+	return
+}
@@ -19,6 +19,37 @@ func (d *Distribution1D) ComputeDiscretePdf(i int) float32 {
 	return d.cdf[i+1] - d.cdf[i]
 }
 
+// Treats f as a piecewise-constant function over [0, 1) and returns
+// a sample x from it, along with the pdf of x and the index of the
+// piece containing x.
+func (d *Distribution1D) SampleContinuous(u float32) (x, pdf float32, i int) {
+	greaterThanR := func(i int) bool { return d.cdf[i+1] > u }
+	n := len(d.f)
+	i = sort.Search(n, greaterThanR)
+	du := u - d.cdf[i]
+	if width := d.cdf[i+1] - d.cdf[i]; width > 0 {
+		du /= width
+	}
+	x = (float32(i) + du) / float32(n)
+	pdf = d.ComputeContinuousPdf(x)
+	return
+}
+
+func (d *Distribution1D) ComputeContinuousPdf(x float32) float32 {
+	n := len(d.f)
+	i := minInt(maxInt(int(x*float32(n)), 0), n-1)
+	if d.intF == 0 {
+		return 1
+	}
+	return d.f[i] / d.intF
+}
+
+// Returns the integral of f, treated as a piecewise-constant
+// function over [0, 1).
+func (d *Distribution1D) GetIntegral() float32 {
+	return d.intF
+}
+
 func MakeDistribution1D(f []float32) Distribution1D {
 	n := len(f)
 	cdf := make([]float32, n+1)
@@ -0,0 +1,41 @@
+package ilium
+
+// A Distribution2D treats a function as piecewise-constant over
+// [0, 1)^2 and samples from it by first sampling a row from the
+// marginal distribution and then a column from that row's
+// conditional distribution.
+type Distribution2D struct {
+	conditionals []Distribution1D
+	marginal     Distribution1D
+}
+
+// f is in row-major order, with nu columns and nv rows.
+func MakeDistribution2D(f []float32, nu, nv int) Distribution2D {
+	if len(f) != nu*nv {
+		panic("f has the wrong size")
+	}
+	conditionals := make([]Distribution1D, nv)
+	rowIntegrals := make([]float32, nv)
+	for v := 0; v < nv; v++ {
+		conditionals[v] = MakeDistribution1D(f[v*nu : (v+1)*nu])
+		rowIntegrals[v] = conditionals[v].GetIntegral()
+	}
+	marginal := MakeDistribution1D(rowIntegrals)
+	return Distribution2D{conditionals, marginal}
+}
+
+// Returns a sample (u, v) and its pdf.
+func (d *Distribution2D) SampleContinuous(u1, u2 float32) (
+	u, v, pdf float32) {
+	v, pdfV, i := d.marginal.SampleContinuous(u2)
+	u, pdfU, _ := d.conditionals[i].SampleContinuous(u1)
+	pdf = pdfU * pdfV
+	return
+}
+
+func (d *Distribution2D) ComputeContinuousPdf(u, v float32) float32 {
+	nv := len(d.conditionals)
+	i := minInt(maxInt(int(v*float32(nv)), 0), nv-1)
+	return d.marginal.ComputeContinuousPdf(v) *
+		d.conditionals[i].ComputeContinuousPdf(u)
+}
@@ -0,0 +1,244 @@
+package ilium
+
+import "math"
+
+// An InfiniteAreaLight is an infinite light that surrounds the scene,
+// and so can be hit by rays that escape the rest of it.
+type InfiniteAreaLight interface {
+	Light
+
+	// Fills in intersection with the point where the given ray,
+	// which is assumed to not hit anything else in the scene,
+	// hits this light, and returns whether there is such a
+	// point.
+	IntersectEscapedRay(ray *Ray, intersection *Intersection) bool
+}
+
+// An environmentRadiance gives the radiance arriving at the scene
+// from every direction, and a way to sample directions roughly in
+// proportion to it.
+type environmentRadiance interface {
+	// Returns the radiance arriving from direction w.
+	computeRadiance(w *Vector3) Spectrum
+
+	// Returns a sampled direction towards the environment,
+	// along with its pdf with respect to solid angle, which may
+	// be 0.
+	sampleDirection(u1, u2 float32) (w Vector3, pdf float32)
+
+	computeDirectionPdf(w *Vector3) float32
+
+	// Returns the integral of the radiance over all directions.
+	computeIntegral() Spectrum
+}
+
+// An EnvironmentLight is an infinitely far away light that
+// surrounds the scene, with its radiance given by an
+// environmentRadiance.
+//
+// The light is represented as a sphere just enclosing the rest of
+// the scene that emits inwards, such that the radiance from any
+// point on the sphere in direction w is the radiance of the
+// environment in direction -w. This gives the same radiance as the
+// environment everywhere inside the sphere, and lets the light be
+// treated like an area light.
+type EnvironmentLight struct {
+	sphere   *Sphere
+	radiance environmentRadiance
+}
+
+func makeEnvironmentLight(
+	radiance environmentRadiance, sceneBound BBox) *EnvironmentLight {
+	sceneCenter, sceneRadius := sceneBound.BoundingSphere()
+	if sceneRadius <= 0 {
+		panic("Environment light needs a scene with non-zero extent")
+	}
+	// Make the sphere slightly bigger so that nothing in the
+	// scene touches it.
+	sphere := &Sphere{
+		samplingMethod: SPHERE_SAMPLE_ENTIRE,
+		center:         sceneCenter,
+		radius:         sceneRadius * (1 + 1e-3),
+		flipNormal:     true,
+	}
+	return &EnvironmentLight{sphere, radiance}
+}
+
+// Makes an environment light whose radiance is given by the
+// equirectangular image at the given path.
+func MakeEnvironmentLight(
+	config map[string]interface{}, sceneBound BBox) *EnvironmentLight {
+	path := config["path"].(string)
+	width, height, radiances, err := readRadianceHdrFile(path)
+	if err != nil {
+		panic(err)
+	}
+	if scaleConfig, ok := config["scale"]; ok {
+		scale := MakeSpectrumFromConfig(
+			scaleConfig.(map[string]interface{}))
+		for i := 0; i < len(radiances); i++ {
+			radiances[i].Mul(&radiances[i], &scale)
+		}
+	}
+	environmentMap := makeEnvironmentMap(width, height, radiances)
+	return makeEnvironmentLight(environmentMap, sceneBound)
+}
+
+func (el *EnvironmentLight) IntersectEscapedRay(
+	ray *Ray, intersection *Intersection) bool {
+	if !el.sphere.Intersect(ray, intersection) {
+		return false
+	}
+	intersection.Light = el
+	return true
+}
+
+func (el *EnvironmentLight) HasSpecularPosition() bool {
+	return false
+}
+
+func (el *EnvironmentLight) HasSpecularDirection() bool {
+	return false
+}
+
+func (el *EnvironmentLight) GetSampleConfig() SampleConfig {
+	return SampleConfig{
+		Sample1DLengths: []int{},
+		Sample2DLengths: []int{1, 1},
+	}
+}
+
+func (el *EnvironmentLight) SampleSurface(sampleBundle SampleBundle) (
+	pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, LeSpatialDivPdf Spectrum, pdf float32) {
+	v1 := sampleBundle.Samples2D[0][0].U1
+	v2 := sampleBundle.Samples2D[0][0].U2
+	pSurface, pSurfaceEpsilon, nSurface, pdf =
+		el.sphere.SampleSurface(v1, v2)
+	LeSpatial := el.ComputeLeSpatial(pSurface)
+	LeSpatialDivPdf.ScaleInv(&LeSpatial, pdf)
+	return
+}
+
+func (el *EnvironmentLight) SampleDirection(
+	sampleBundle SampleBundle, pSurface Point3, nSurface Normal3) (
+	wo Vector3, LeDirectionalDivPdf Spectrum, pdf float32) {
+	w1 := sampleBundle.Samples2D[1][0].U1
+	w2 := sampleBundle.Samples2D[1][0].U2
+	wEnvironment, pdfSolidAngle := el.radiance.sampleDirection(w1, w2)
+	if pdfSolidAngle == 0 {
+		return
+	}
+	wo.Flip(&wEnvironment)
+	// Directions that point out of the sphere are wasted.
+	cosThO := wo.DotNormal(&nSurface)
+	if cosThO < PDF_COS_THETA_EPSILON {
+		wo = Vector3{}
+		return
+	}
+	pdf = pdfSolidAngle / cosThO
+	LeDirectional := el.radiance.computeRadiance(&wEnvironment)
+	LeDirectionalDivPdf.ScaleInv(&LeDirectional, pdf)
+	return
+}
+
+func (el *EnvironmentLight) SampleRay(sampleBundle SampleBundle) (
+	ray Ray, LeDivPdf Spectrum, pdf float32) {
+	pSurface, pSurfaceEpsilon, nSurface, LeSpatialDivPdf, pdfSpatial :=
+		el.SampleSurface(sampleBundle)
+	wo, LeDirectionalDivPdf, pdfDirectional :=
+		el.SampleDirection(sampleBundle, pSurface, nSurface)
+	if LeDirectionalDivPdf.IsBlack() || pdfDirectional == 0 {
+		return
+	}
+	ray = Ray{pSurface, wo, pSurfaceEpsilon, infFloat32(+1)}
+	LeDivPdf.Mul(&LeSpatialDivPdf, &LeDirectionalDivPdf)
+	pdf = pdfSpatial * pdfDirectional
+	return
+}
+
+func (el *EnvironmentLight) SampleLeFromPoint(
+	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
+	LeDivPdf Spectrum, pdf float32, wi Vector3,
+	pSurface Point3, nSurface Normal3, shadowRay Ray) {
+	wi, pdfSolidAngle := el.radiance.sampleDirection(v1, v2)
+	if pdfSolidAngle == 0 {
+		wi = Vector3{}
+		return
+	}
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	if absCosThI < PDF_COS_THETA_EPSILON {
+		wi = Vector3{}
+		return
+	}
+
+	ray := Ray{p, wi, pEpsilon, infFloat32(+1)}
+	var intersection Intersection
+	if !el.sphere.Intersect(&ray, &intersection) {
+		wi = Vector3{}
+		return
+	}
+
+	Le := el.radiance.computeRadiance(&wi)
+	pdf = pdfSolidAngle / absCosThI
+	LeDivPdf.ScaleInv(&Le, pdf)
+	pSurface = intersection.P
+	nSurface = intersection.N
+	shadowRay = Ray{p, wi, pEpsilon, intersection.T * (1 - 5e-4)}
+	return
+}
+
+func (el *EnvironmentLight) ComputeLePdfFromPoint(
+	p Point3, pEpsilon float32, n Normal3, wi Vector3) float32 {
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	if absCosThI < PDF_COS_THETA_EPSILON {
+		return 0
+	}
+	return el.radiance.computeDirectionPdf(&wi) / absCosThI
+}
+
+func (el *EnvironmentLight) ComputeLeSpatial(pSurface Point3) Spectrum {
+	// The split of the emitted radiance into spatial and
+	// directional components is arbitrary, so put all of it in
+	// the directional component.
+	return MakeConstantSpectrum(1)
+}
+
+func (el *EnvironmentLight) ComputeLeSpatialPdf(pSurface Point3) float32 {
+	return 1 / el.sphere.SurfaceArea()
+}
+
+func (el *EnvironmentLight) ComputeLeDirectional(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	if wo.DotNormal(&nSurface) <= 0 {
+		return Spectrum{}
+	}
+	var wEnvironment Vector3
+	wEnvironment.Flip(&wo)
+	return el.radiance.computeRadiance(&wEnvironment)
+}
+
+func (el *EnvironmentLight) ComputeLeDirectionalPdf(
+	pSurface Point3, nSurface Normal3, wo Vector3) float32 {
+	cosThO := wo.DotNormal(&nSurface)
+	if cosThO < PDF_COS_THETA_EPSILON {
+		return 0
+	}
+	var wEnvironment Vector3
+	wEnvironment.Flip(&wo)
+	return el.radiance.computeDirectionPdf(&wEnvironment) / cosThO
+}
+
+func (el *EnvironmentLight) ComputeLe(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	return el.ComputeLeDirectional(pSurface, nSurface, wo)
+}
+
+func (el *EnvironmentLight) Power() Spectrum {
+	// Each direction of the environment illuminates a disk with
+	// the same radius as the sphere.
+	integral := el.radiance.computeIntegral()
+	var power Spectrum
+	power.Scale(&integral, math.Pi*el.sphere.radius*el.sphere.radius)
+	return power
+}
@@ -0,0 +1,110 @@
+package ilium
+
+import "math"
+
+// An environmentMap is an environmentRadiance given by an
+// equirectangular (i.e., latitude-longitude) image. The top row of
+// the image is the +Z direction, and column u of an image with width
+// w is at phi = 2 * pi * (u + 0.5) / w around the Z axis, starting
+// from +X and going towards +Y.
+//
+// Directions are sampled in proportion to the luminance of each
+// pixel.
+type environmentMap struct {
+	width        int
+	height       int
+	radiances    []Spectrum
+	distribution Distribution2D
+}
+
+// radiances is in row-major order, starting from the top row.
+func makeEnvironmentMap(
+	width, height int, radiances []Spectrum) *environmentMap {
+	// Weigh each pixel by sin(theta) to account for the
+	// distortion of the equirectangular mapping.
+	f := make([]float32, width*height)
+	for y := 0; y < height; y++ {
+		theta := math.Pi * (float32(y) + 0.5) / float32(height)
+		sinTheta, _ := sincosFloat32(theta)
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			f[i] = radiances[i].Y() * sinTheta
+		}
+	}
+	distribution := MakeDistribution2D(f, width, height)
+	return &environmentMap{width, height, radiances, distribution}
+}
+
+// Returns the image coordinates of the given direction, along with
+// sin(theta).
+func equirectangularDirectionToUV(w *Vector3) (u, v, sinTheta float32) {
+	cosTheta := minFloat32(maxFloat32(w.Z, -1), 1)
+	sinTheta = cosToSin(cosTheta)
+	theta := float32(math.Acos(float64(cosTheta)))
+	phi := float32(math.Atan2(float64(w.Y), float64(w.X)))
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+	u = phi / (2 * math.Pi)
+	v = theta / math.Pi
+	return
+}
+
+func equirectangularUVToDirection(u, v float32) (
+	w Vector3, sinTheta float32) {
+	sinTheta, cosTheta := sincosFloat32(v * math.Pi)
+	sinPhi, cosPhi := sincosFloat32(u * 2 * math.Pi)
+	w = Vector3{sinTheta * cosPhi, sinTheta * sinPhi, cosTheta}
+	return
+}
+
+func (em *environmentMap) computeRadiance(w *Vector3) Spectrum {
+	u, v, _ := equirectangularDirectionToUV(w)
+	x := minInt(int(u*float32(em.width)), em.width-1)
+	y := minInt(int(v*float32(em.height)), em.height-1)
+	return em.radiances[y*em.width+x]
+}
+
+func (em *environmentMap) sampleDirection(u1, u2 float32) (
+	w Vector3, pdf float32) {
+	u, v, pdfUV := em.distribution.SampleContinuous(u1, u2)
+	if pdfUV == 0 {
+		return
+	}
+	w, sinTheta := equirectangularUVToDirection(u, v)
+	if sinTheta == 0 {
+		return Vector3{}, 0
+	}
+	// The Jacobian of the map from (u, v) to w is
+	// 2 * pi^2 * sin(theta).
+	pdf = pdfUV / (2 * math.Pi * math.Pi * sinTheta)
+	return
+}
+
+func (em *environmentMap) computeDirectionPdf(w *Vector3) float32 {
+	u, v, sinTheta := equirectangularDirectionToUV(w)
+	if sinTheta == 0 {
+		return 0
+	}
+	pdfUV := em.distribution.ComputeContinuousPdf(u, v)
+	return pdfUV / (2 * math.Pi * math.Pi * sinTheta)
+}
+
+func (em *environmentMap) computeIntegral() Spectrum {
+	var integral Spectrum
+	for y := 0; y < em.height; y++ {
+		theta := math.Pi * (float32(y) + 0.5) / float32(em.height)
+		sinTheta, _ := sincosFloat32(theta)
+		// Each pixel covers 2 * pi^2 * sin(theta) / (w * h)
+		// steradians.
+		pixelSolidAngle := 2 * math.Pi * math.Pi * sinTheta /
+			float32(em.width*em.height)
+		for x := 0; x < em.width; x++ {
+			var pixelIntegral Spectrum
+			pixelIntegral.Scale(
+				&em.radiances[y*em.width+x], pixelSolidAngle)
+			integral.Add(&integral, &pixelIntegral)
+		}
+	}
+	return integral
+}
@@ -0,0 +1,126 @@
+package ilium
+
+// Returns the fraction of unpolarized light reflected at the
+// boundary between two dielectrics, where etaI is the index of
+// refraction on the side of the incident direction and etaT is the
+// one on the other side. cosThI is the cosine of the angle between
+// the incident direction and the normal on its side.
+func computeFresnelDielectric(cosThI, etaI, etaT float32) float32 {
+	sinThT := etaI / etaT * cosToSin(cosThI)
+	if sinThT >= 1 {
+		// Total internal reflection.
+		return 1
+	}
+	cosThT := sinToCos(sinThT)
+	rParallel := (etaT*cosThI - etaI*cosThT) / (etaT*cosThI + etaI*cosThT)
+	rPerpendicular :=
+		(etaI*cosThI - etaT*cosThT) / (etaI*cosThI + etaT*cosThT)
+	return 0.5 * (rParallel*rParallel + rPerpendicular*rPerpendicular)
+}
+
+// Returns the fraction of unpolarized light reflected at the
+// boundary between a dielectric with index of refraction 1 and a
+// conductor with complex index of refraction eta + i*k. cosThI is
+// the cosine of the angle between the incident direction and the
+// normal on the dielectric side.
+func computeFresnelConductor(cosThI, eta, k float32) float32 {
+	cos2ThI := cosThI * cosThI
+	sin2ThI := 1 - cos2ThI
+	eta2 := eta * eta
+	k2 := k * k
+
+	t0 := eta2 - k2 - sin2ThI
+	a2PlusB2 := sqrtFloat32(t0*t0 + 4*eta2*k2)
+	t1 := a2PlusB2 + cos2ThI
+	a := sqrtFloat32(maxFloat32(0, 0.5*(a2PlusB2+t0)))
+	t2 := 2 * cosThI * a
+	rPerpendicular := (t1 - t2) / (t1 + t2)
+
+	t3 := cos2ThI*a2PlusB2 + sin2ThI*sin2ThI
+	t4 := t2 * sin2ThI
+	rParallel := rPerpendicular * (t3 - t4) / (t3 + t4)
+
+	return 0.5 * (rParallel + rPerpendicular)
+}
+
+// A Fresnel computes the (possibly spectrally-varying) fraction of
+// light reflected at a surface.
+type Fresnel interface {
+	// Returns the reflectance for the given cosine of the angle
+	// between the incident direction and the (micro)surface
+	// normal, which must be positive.
+	ComputeReflectance(cosThI float32) Spectrum
+}
+
+// Returns a Fresnel from the given config, which has a "type" key of
+// either "none" or "conductor".
+func MakeFresnel(config map[string]interface{}) Fresnel {
+	fresnelType := config["type"].(string)
+	switch fresnelType {
+	case "none":
+		return &NoOpFresnel{}
+	case "conductor":
+		return MakeConductorFresnel(config)
+	default:
+		panic("unknown Fresnel type " + fresnelType)
+	}
+}
+
+// A NoOpFresnel reflects all light.
+type NoOpFresnel struct{}
+
+func (f *NoOpFresnel) ComputeReflectance(cosThI float32) Spectrum {
+	return MakeConstantSpectrum(1)
+}
+
+// A ConductorFresnel computes the reflectance of a metal with a
+// complex index of refraction eta + i*k per RGB channel.
+type ConductorFresnel struct {
+	eta, k Spectrum
+}
+
+// Approximate values of eta and k at the R, G, and B wavelengths for
+// some common metals.
+var conductorPresets = map[string]ConductorFresnel{
+	"gold": {
+		MakeRGBSpectrum(0.143, 0.374, 1.442),
+		MakeRGBSpectrum(3.983, 2.385, 1.603),
+	},
+	"copper": {
+		MakeRGBSpectrum(0.200, 0.924, 1.102),
+		MakeRGBSpectrum(3.912, 2.452, 2.142),
+	},
+	"aluminum": {
+		MakeRGBSpectrum(1.657, 0.880, 0.521),
+		MakeRGBSpectrum(9.224, 6.270, 4.837),
+	},
+	"silver": {
+		MakeRGBSpectrum(0.155, 0.117, 0.138),
+		MakeRGBSpectrum(4.828, 3.122, 2.147),
+	},
+}
+
+// The config has either a "metal" key naming one of the presets, or
+// "eta" and "k" spectra.
+func MakeConductorFresnel(config map[string]interface{}) *ConductorFresnel {
+	if metalConfig, ok := config["metal"]; ok {
+		metal := metalConfig.(string)
+		preset, ok := conductorPresets[metal]
+		if !ok {
+			panic("unknown metal " + metal)
+		}
+		return &preset
+	}
+	etaConfig := config["eta"].(map[string]interface{})
+	eta := MakeSpectrumFromConfig(etaConfig)
+	kConfig := config["k"].(map[string]interface{})
+	k := MakeSpectrumFromConfig(kConfig)
+	return &ConductorFresnel{eta, k}
+}
+
+func (f *ConductorFresnel) ComputeReflectance(cosThI float32) Spectrum {
+	return MakeRGBSpectrum(
+		computeFresnelConductor(cosThI, f.eta.r, f.k.r),
+		computeFresnelConductor(cosThI, f.eta.g, f.k.g),
+		computeFresnelConductor(cosThI, f.eta.b, f.k.b))
+}
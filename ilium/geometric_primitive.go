@@ -24,6 +24,10 @@ func (gp *GeometricPrimitive) Intersect(
 	return true
 }
 
+func (gp *GeometricPrimitive) WorldBound() BBox {
+	return gp.shape.WorldBound()
+}
+
 func (gp *GeometricPrimitive) GetSensors() []Sensor {
 	return gp.shared.sensors
 }
@@ -35,11 +39,40 @@ func (gp *GeometricPrimitive) GetLights() []Light {
 	return []Light{gp.shared.light}
 }
 
+// Returns the material to use for the given shape, which is the one
+// for its material group in groupMaterials, if any, and
+// defaultMaterial otherwise.
+func getShapeMaterial(shape Shape, defaultMaterial Material,
+	groupMaterials map[string]Material) Material {
+	if groupShape, ok := shape.(MaterialGroupShape); ok {
+		group := groupShape.GetMaterialGroup()
+		if material, ok := groupMaterials[group]; ok {
+			return material
+		}
+		if defaultMaterial == nil {
+			panic("no material for material group " + group)
+		}
+	}
+	if defaultMaterial == nil {
+		panic("no material for shape")
+	}
+	return defaultMaterial
+}
+
 func MakeGeometricPrimitives(config map[string]interface{}) []Primitive {
 	shapeConfig := config["shape"].(map[string]interface{})
 	shapes := MakeShapes(shapeConfig)
-	materialConfig := config["material"].(map[string]interface{})
-	material := MakeMaterial(materialConfig)
+	var defaultMaterial Material
+	if materialConfig, ok := config["material"].(map[string]interface{}); ok {
+		defaultMaterial = MakeMaterial(materialConfig)
+	}
+	groupMaterials := make(map[string]Material)
+	if materialsConfig, ok := config["materials"].(map[string]interface{}); ok {
+		for group, o := range materialsConfig {
+			groupMaterials[group] = MakeMaterial(
+				o.(map[string]interface{}))
+		}
+	}
 	var light Light
 	if lightConfig, ok := config["light"].(map[string]interface{}); ok {
 		light = MakeLight(lightConfig, shapes)
@@ -51,13 +84,22 @@ func MakeGeometricPrimitives(config map[string]interface{}) []Primitive {
 			sensors = append(sensors, sensor)
 		}
 	}
-	shared := geometricPrimitiveShared{material, light, sensors}
+	// Shapes with the same material share the same
+	// geometricPrimitiveShared object.
+	sharedMap := make(map[Material]*geometricPrimitiveShared)
 	primitives := []Primitive{}
-	if len(shapes) > 0 {
-		for i := 0; i < len(shapes); i++ {
-			primitive := &GeometricPrimitive{shapes[i], &shared}
-			primitives = append(primitives, primitive)
+	for i := 0; i < len(shapes); i++ {
+		material := getShapeMaterial(
+			shapes[i], defaultMaterial, groupMaterials)
+		shared, ok := sharedMap[material]
+		if !ok {
+			shared = &geometricPrimitiveShared{
+				material, light, sensors,
+			}
+			sharedMap[material] = shared
 		}
+		primitive := &GeometricPrimitive{shapes[i], shared}
+		primitives = append(primitives, primitive)
 	}
 	return primitives
 }
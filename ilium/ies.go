@@ -0,0 +1,313 @@
+package ilium
+
+import "bufio"
+import "fmt"
+import "math"
+import "os"
+import "sort"
+import "strconv"
+import "strings"
+
+// An iesProfile is the candela distribution of a luminaire from an
+// IES LM-63 photometric file with type C photometry, i.e. with
+// vertical angles measured from the nadir (straight down) and
+// horizontal angles measured around it.
+type iesProfile struct {
+	// Both in degrees, and in increasing order.
+	verticalAngles   []float32
+	horizontalAngles []float32
+	// candelas[h][v] is the (scaled) candela value at
+	// horizontalAngles[h] and verticalAngles[v].
+	candelas [][]float32
+}
+
+type iesReader struct {
+	path   string
+	tokens []string
+}
+
+func (r *iesReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", r.path, fmt.Sprintf(format, args...))
+}
+
+func (r *iesReader) readFloat() (float32, error) {
+	if len(r.tokens) == 0 {
+		return 0, r.errorf("unexpected end of data")
+	}
+	token := r.tokens[0]
+	r.tokens = r.tokens[1:]
+	f, err := strconv.ParseFloat(token, 32)
+	if err != nil {
+		return 0, r.errorf("invalid number %q", token)
+	}
+	return float32(f), nil
+}
+
+func (r *iesReader) readFloats(n int) ([]float32, error) {
+	fs := make([]float32, n)
+	for i := 0; i < n; i++ {
+		var err error
+		if fs[i], err = r.readFloat(); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+func (r *iesReader) readCount(name string) (int, error) {
+	f, err := r.readFloat()
+	if err != nil {
+		return 0, err
+	}
+	if f < 1 || f != float32(int(f)) {
+		return 0, r.errorf("invalid %s %v", name, f)
+	}
+	return int(f), nil
+}
+
+func isSortedFloat32s(fs []float32) bool {
+	for i := 1; i < len(fs); i++ {
+		if fs[i] <= fs[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// Reads the IES LM-63 file at the given path. Only type C photometry
+// is supported, and TILT data is ignored.
+func readIesFile(path string) (*iesProfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := iesReader{path: path}
+	scanner := bufio.NewScanner(f)
+	// Skip keyword lines until the TILT line, after which
+	// everything is a list of numbers.
+	foundTilt := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if foundTilt {
+			line = strings.Replace(line, ",", " ", -1)
+			r.tokens = append(r.tokens, strings.Fields(line)...)
+			continue
+		}
+		if strings.HasPrefix(line, "TILT=") {
+			tilt := strings.TrimPrefix(line, "TILT=")
+			if tilt != "NONE" && tilt != "INCLUDE" {
+				return nil, r.errorf(
+					"unsupported TILT file %s", tilt)
+			}
+			foundTilt = true
+			if tilt == "INCLUDE" {
+				r.tokens = []string{"INCLUDE"}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !foundTilt {
+		return nil, r.errorf("missing TILT line")
+	}
+
+	if len(r.tokens) > 0 && r.tokens[0] == "INCLUDE" {
+		// Skip the lamp-to-luminaire geometry, and the
+		// angles and multiplying factors.
+		r.tokens = r.tokens[1:]
+		if _, err := r.readFloat(); err != nil {
+			return nil, err
+		}
+		tiltCount, err := r.readCount("TILT count")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.readFloats(2 * tiltCount); err != nil {
+			return nil, err
+		}
+	}
+
+	// The number of lamps and the lumens per lamp, which are
+	// used only for relative photometry.
+	if _, err := r.readFloats(2); err != nil {
+		return nil, err
+	}
+	candelaMultiplier, err := r.readFloat()
+	if err != nil {
+		return nil, err
+	}
+	verticalCount, err := r.readCount("vertical angle count")
+	if err != nil {
+		return nil, err
+	}
+	horizontalCount, err := r.readCount("horizontal angle count")
+	if err != nil {
+		return nil, err
+	}
+	photometricType, err := r.readFloat()
+	if err != nil {
+		return nil, err
+	}
+	if photometricType != 1 {
+		return nil, r.errorf(
+			"unsupported photometric type %v", photometricType)
+	}
+	// The units type, the luminous opening dimensions, and the
+	// input watts don't affect the candela distribution.
+	if _, err := r.readFloats(4); err != nil {
+		return nil, err
+	}
+	factors, err := r.readFloats(2)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.readFloat(); err != nil {
+		return nil, err
+	}
+	scale := candelaMultiplier * factors[0] * factors[1]
+
+	verticalAngles, err := r.readFloats(verticalCount)
+	if err != nil {
+		return nil, err
+	}
+	if !isSortedFloat32s(verticalAngles) ||
+		verticalAngles[0] < 0 ||
+		verticalAngles[verticalCount-1] > 180 {
+		return nil, r.errorf("invalid vertical angles")
+	}
+	horizontalAngles, err := r.readFloats(horizontalCount)
+	if err != nil {
+		return nil, err
+	}
+	if !isSortedFloat32s(horizontalAngles) || horizontalAngles[0] != 0 {
+		return nil, r.errorf("unsupported horizontal angles")
+	}
+	switch horizontalAngles[horizontalCount-1] {
+	case 0, 90, 180, 360:
+	default:
+		return nil, r.errorf("unsupported horizontal angles")
+	}
+	candelas := make([][]float32, horizontalCount)
+	for h := 0; h < horizontalCount; h++ {
+		if candelas[h], err = r.readFloats(verticalCount); err != nil {
+			return nil, err
+		}
+		for v := 0; v < verticalCount; v++ {
+			if candelas[h][v] < 0 {
+				return nil, r.errorf("negative candela value")
+			}
+			candelas[h][v] *= scale
+		}
+	}
+	return &iesProfile{verticalAngles, horizontalAngles, candelas}, nil
+}
+
+// Returns the index i such that angles[i] <= angle <= angles[i+1],
+// and the position of angle between them, assuming that angle is
+// within the range of angles and that there are at least two of
+// them.
+func findIesAngleInterval(angles []float32, angle float32) (
+	i int, t float32) {
+	i = sort.Search(len(angles), func(j int) bool {
+		return angles[j] > angle
+	}) - 1
+	i = minInt(maxInt(i, 0), len(angles)-2)
+	t = (angle - angles[i]) / (angles[i+1] - angles[i])
+	t = minFloat32(maxFloat32(t, 0), 1)
+	return
+}
+
+// Returns the candela value at the given horizontal index and
+// vertical angle (in degrees).
+func (ip *iesProfile) computeVerticalCandela(h int, theta float32) float32 {
+	n := len(ip.verticalAngles)
+	if theta < ip.verticalAngles[0] || theta > ip.verticalAngles[n-1] {
+		return 0
+	}
+	if n == 1 {
+		return ip.candelas[h][0]
+	}
+	i, t := findIesAngleInterval(ip.verticalAngles, theta)
+	return (1-t)*ip.candelas[h][i] + t*ip.candelas[h][i+1]
+}
+
+// Returns the range of vertical angles (in degrees) outside of
+// which the candela values are zero, taking into account the
+// interpolation between them.
+func (ip *iesProfile) computeEmittingVerticalAngleRange() (
+	thetaMin, thetaMax float32) {
+	n := len(ip.verticalAngles)
+	vMin := n
+	vMax := -1
+	for h := range ip.candelas {
+		for v, candela := range ip.candelas[h] {
+			if candela != 0 {
+				vMin = minInt(vMin, v)
+				vMax = maxInt(vMax, v)
+			}
+		}
+	}
+	if vMax < 0 {
+		return 0, 0
+	}
+	thetaMin = ip.verticalAngles[maxInt(vMin-1, 0)]
+	thetaMax = ip.verticalAngles[minInt(vMax+1, n-1)]
+	return
+}
+
+// Returns the candela value at the given vertical and horizontal
+// angles (in degrees), with phi in [0, 360).
+func (ip *iesProfile) computeCandela(theta, phi float32) float32 {
+	n := len(ip.horizontalAngles)
+	if n == 1 {
+		// Rotationally symmetric.
+		return ip.computeVerticalCandela(0, theta)
+	}
+	// Fold phi into the range covered by the horizontal angles,
+	// using the symmetry implied by that range.
+	switch ip.horizontalAngles[n-1] {
+	case 90:
+		if phi > 180 {
+			phi = 360 - phi
+		}
+		if phi > 90 {
+			phi = 180 - phi
+		}
+	case 180:
+		if phi > 180 {
+			phi = 360 - phi
+		}
+	}
+	i, t := findIesAngleInterval(ip.horizontalAngles, phi)
+	c0 := ip.computeVerticalCandela(i, theta)
+	c1 := ip.computeVerticalCandela(i+1, theta)
+	return (1-t)*c0 + t*c1
+}
+
+// Returns the candela value at theta = pi * v and phi = 2 * pi * u,
+// which lets the profile be tabulated like a SpectrumTexture.
+func (ip *iesProfile) Evaluate(u, v float32) Spectrum {
+	theta := 180 * minFloat32(maxFloat32(v, 0), 1)
+	phi := 360 * wrapTextureCoordinate(u)
+	return MakeConstantSpectrum(ip.computeCandela(theta, phi))
+}
+
+// Returns the integral of the candela distribution over the sphere,
+// using the midpoint rule on an nu x nv grid over (phi, theta).
+func (ip *iesProfile) computeIntegral(nu, nv int) float32 {
+	var integral float32
+	for j := 0; j < nv; j++ {
+		v := (float32(j) + 0.5) / float32(nv)
+		sinTheta, _ := sincosFloat32(math.Pi * v)
+		for i := 0; i < nu; i++ {
+			u := (float32(i) + 0.5) / float32(nu)
+			integral += ip.computeCandela(180*v, 360*u) * sinTheta
+		}
+	}
+	// d(omega) = sin(theta) * d(theta) * d(phi) = sin(theta) *
+	// pi * dv * 2 * pi * du.
+	return integral * 2 * math.Pi * math.Pi / float32(nu*nv)
+}
@@ -0,0 +1,308 @@
+package ilium
+
+import "math"
+
+// The resolution over (phi, theta) at which to tabulate IES profiles
+// for sampling and integration.
+const _IES_SAMPLING_RESOLUTION_PHI = 256
+const _IES_SAMPLING_RESOLUTION_THETA = 128
+
+// An IESLight is like a PointLight, except that its intensity in each
+// direction is given by the candela distribution from an IES LM-63
+// photometric file, times a scale. The nadir of the distribution
+// points to a target, and the horizontal angles are measured around
+// it from an azimuth reference direction.
+//
+// Directions are sampled in proportion to the tabulated
+// distribution. As with PointLight, the pdfs with respect to
+// projected solid angle at the light are computed as if the normal
+// always pointed along the direction in question.
+type IESLight struct {
+	position Point3
+	scale    Spectrum
+	profile  *iesProfile
+	// The directions at which theta = 0 (the nadir), and theta =
+	// 90 degrees with phi = 0 and phi = 90 degrees, respectively.
+	nadirHat     Vector3
+	azimuth0Hat  Vector3
+	azimuth90Hat Vector3
+	// Over (phi / (2 * pi), theta / pi).
+	distribution Distribution2D
+	// The integral of the candela distribution over the sphere.
+	integral float32
+}
+
+func MakeIESLight(
+	config map[string]interface{}, shapes []Shape) *IESLight {
+	if len(shapes) != 1 {
+		panic("IES light must have exactly one PointShape")
+	}
+	pointShape, ok := shapes[0].(*PointShape)
+	if !ok {
+		panic("IES light must have exactly one PointShape")
+	}
+	profile, err := readIesFile(config["path"].(string))
+	if err != nil {
+		panic(err)
+	}
+	scale := MakeConstantSpectrum(1)
+	if scaleConfig, ok := config["scale"]; ok {
+		scale = MakeSpectrumFromConfig(
+			scaleConfig.(map[string]interface{}))
+	}
+
+	position := pointShape.P
+	target := MakePoint3FromConfig(config["target"])
+	var nadirHat Vector3
+	nadirHat.GetOffset(&position, &target)
+	nadirHat.Normalize(&nadirHat)
+	var azimuth0Hat, azimuth90Hat Vector3
+	if referenceConfig, ok := config["azimuthReference"]; ok {
+		// Use the part of the reference direction that's
+		// perpendicular to the nadir.
+		reference := MakeVector3FromConfig(referenceConfig)
+		var parallel Vector3
+		parallel.Scale(&nadirHat, reference.Dot(&nadirHat))
+		azimuth0Hat.Sub(&reference, &parallel)
+		if azimuth0Hat.NormSq() == 0 {
+			panic("azimuthReference must not be parallel " +
+				"to the direction to the target")
+		}
+		azimuth0Hat.Normalize(&azimuth0Hat)
+		azimuth90Hat.CrossNoAlias(&nadirHat, &azimuth0Hat)
+	} else {
+		MakeCoordinateSystemNoAlias((*R3)(&nadirHat),
+			(*R3)(&azimuth0Hat), (*R3)(&azimuth90Hat))
+	}
+
+	nu := _IES_SAMPLING_RESOLUTION_PHI
+	nv := _IES_SAMPLING_RESOLUTION_THETA
+	// Weigh each cell by sin(theta) to account for the
+	// distortion of the mapping to directions.
+	f := tabulateSpectrumTexture(profile, nu, nv,
+		func(u, v float32) float32 {
+			sinTheta, _ := sincosFloat32(math.Pi * v)
+			return sinTheta
+		})
+	distribution := MakeDistribution2D(f, nu, nv)
+	integral := profile.computeIntegral(nu, nv)
+	if integral <= 0 {
+		panic("IES profile must have non-zero candela values")
+	}
+
+	return &IESLight{
+		position:     position,
+		scale:        scale,
+		profile:      profile,
+		nadirHat:     nadirHat,
+		azimuth0Hat:  azimuth0Hat,
+		azimuth90Hat: azimuth90Hat,
+		distribution: distribution,
+		integral:     integral,
+	}
+}
+
+// Returns the angles (in radians) of direction w, with theta in [0,
+// pi] and phi in [0, 2 * pi), along with sin(theta).
+func (il *IESLight) computeAngles(w *Vector3) (
+	theta, phi, sinTheta float32) {
+	cosTheta := maxFloat32(-1, minFloat32(w.Dot(&il.nadirHat), 1))
+	theta = float32(math.Acos(float64(cosTheta)))
+	sinTheta = cosToSin(cosTheta)
+	x := w.Dot(&il.azimuth0Hat)
+	y := w.Dot(&il.azimuth90Hat)
+	phi = float32(math.Atan2(float64(y), float64(x)))
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+	phi = minFloat32(phi, 2*math.Pi*_ONE_MINUS_EPSILON)
+	return
+}
+
+// Returns the candela value of the profile in direction w.
+func (il *IESLight) computeCandela(w *Vector3) float32 {
+	theta, phi, _ := il.computeAngles(w)
+	return il.profile.computeCandela(
+		theta*(180/math.Pi), phi*(180/math.Pi))
+}
+
+// Returns a direction sampled roughly in proportion to the candela
+// distribution, and its pdf with respect to solid angle, which may be
+// 0.
+func (il *IESLight) sampleDirection(
+	sampleBundle SampleBundle) (wo Vector3, pdf float32) {
+	u1 := sampleBundle.Samples2D[0][0].U1
+	u2 := sampleBundle.Samples2D[0][0].U2
+	u, v, pdfUV := il.distribution.SampleContinuous(u1, u2)
+	if pdfUV == 0 {
+		return
+	}
+	theta := math.Pi * v
+	sinTheta, cosTheta := sincosFloat32(theta)
+	if sinTheta == 0 {
+		return
+	}
+	r3Canonical := MakeSphericalDirection(cosTheta, 2*math.Pi*u)
+	var w R3
+	w.ConvertToCoordinateSystemNoAlias(&r3Canonical,
+		(*R3)(&il.azimuth0Hat), (*R3)(&il.azimuth90Hat),
+		(*R3)(&il.nadirHat))
+	wo = Vector3(w)
+	pdf = pdfUV / (2 * math.Pi * math.Pi * sinTheta)
+	return
+}
+
+func (il *IESLight) HasSpecularPosition() bool {
+	return true
+}
+
+func (il *IESLight) HasSpecularDirection() bool {
+	return false
+}
+
+func (il *IESLight) GetSampleConfig() SampleConfig {
+	return SampleConfig{
+		Sample1DLengths: []int{},
+		Sample2DLengths: []int{1},
+	}
+}
+
+func (il *IESLight) SampleSurface(sampleBundle SampleBundle) (
+	pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, LeSpatialDivPdf Spectrum, pdf float32) {
+	pSurface = il.position
+	// The pdf with respect to surface area is just 1 (with an
+	// implicit delta distribution).
+	LeSpatialDivPdf = il.ComputeLeSpatial(pSurface)
+	pdf = 1
+	return
+}
+
+func (il *IESLight) SampleDirection(
+	sampleBundle SampleBundle, pSurface Point3, nSurface Normal3) (
+	wo Vector3, LeDirectionalDivPdf Spectrum, pdf float32) {
+	wo, pdf = il.sampleDirection(sampleBundle)
+	if pdf == 0 {
+		wo = Vector3{}
+		return
+	}
+	// LeDirectional = candela / integral.
+	LeDirectionalDivPdf = MakeConstantSpectrum(
+		il.computeCandela(&wo) / (il.integral * pdf))
+	return
+}
+
+func (il *IESLight) SampleRay(sampleBundle SampleBundle) (
+	ray Ray, LeDivPdf Spectrum, pdf float32) {
+	wo, pdf := il.sampleDirection(sampleBundle)
+	if pdf == 0 {
+		return
+	}
+	ray = Ray{il.position, wo, 0, infFloat32(+1)}
+	LeDivPdf.Scale(&il.scale, il.computeCandela(&wo)/pdf)
+	return
+}
+
+func (il *IESLight) SampleLeFromPoint(
+	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
+	LeDivPdf Spectrum, pdf float32, wi Vector3,
+	pSurface Point3, nSurface Normal3, shadowRay Ray) {
+	r := wi.GetDirectionAndDistance(&p, &il.position)
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	if absCosThI < PDF_COS_THETA_EPSILON || r < PDF_R_EPSILON {
+		wi = Vector3{}
+		return
+	}
+
+	var wo Vector3
+	wo.Flip(&wi)
+	candela := il.computeCandela(&wo)
+	if candela == 0 {
+		wi = Vector3{}
+		return
+	}
+
+	// The pdf w.r.t. surface area is just 1 (with an implicit
+	// delta distribution), so pdf = 1 / G(p <-> position) =
+	// r^2 / |cos(thI)|, and Le = scale * candela.
+	LeDivPdf.Scale(&il.scale, candela*absCosThI/(r*r))
+	pdf = (r * r) / absCosThI
+	pSurface = il.position
+	shadowRay = Ray{p, wi, pEpsilon, r * (1 - 5e-4)}
+	return
+}
+
+func (il *IESLight) ComputeLePdfFromPoint(
+	p Point3, pEpsilon float32, n Normal3, wi Vector3) float32 {
+	// Since we're assuming wi points towards the light, this is
+	// the same pdf as in SampleLeFromPoint().
+	r := il.position.Distance(&p)
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	return r * r / absCosThI
+}
+
+func (il *IESLight) ComputeLeSpatial(pSurface Point3) Spectrum {
+	var LeSpatial Spectrum
+	LeSpatial.Scale(&il.scale, il.integral)
+	return LeSpatial
+}
+
+func (il *IESLight) ComputeLeSpatialPdf(pSurface Point3) float32 {
+	// Since we're assuming pSurface is the light's position,
+	// return 1 even though we have a delta spatial distribution.
+	return 1
+}
+
+func (il *IESLight) ComputeLeDirectional(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	return MakeConstantSpectrum(il.computeCandela(&wo) / il.integral)
+}
+
+func (il *IESLight) ComputeLeDirectionalPdf(
+	pSurface Point3, nSurface Normal3, wo Vector3) float32 {
+	theta, phi, sinTheta := il.computeAngles(&wo)
+	if sinTheta == 0 {
+		return 0
+	}
+	pdfUV := il.distribution.ComputeContinuousPdf(
+		phi/(2*math.Pi), theta/math.Pi)
+	return pdfUV / (2 * math.Pi * math.Pi * sinTheta)
+}
+
+func (il *IESLight) ComputeLe(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	var Le Spectrum
+	Le.Scale(&il.scale, il.computeCandela(&wo))
+	return Le
+}
+
+func (il *IESLight) Power() Spectrum {
+	return il.ComputeLeSpatial(il.position)
+}
+
+func (il *IESLight) getLightBounds() (lightBounds, bool) {
+	power := il.Power()
+	// Treat the cone around the nadir (or the zenith) that
+	// contains the directions with nonzero candela values as the
+	// normals, with no spread around them.
+	thetaMin, thetaMax :=
+		il.profile.computeEmittingVerticalAngleRange()
+	normalCone := makeEntireSphereDirectionCone()
+	if thetaMin == 0 && thetaMax < 180 {
+		_, cosThetaMax := sincosFloat32(thetaMax * (math.Pi / 180))
+		normalCone = directionCone{il.nadirHat, cosThetaMax}
+	} else if thetaMin > 0 && thetaMax == 180 {
+		var zenithHat Vector3
+		zenithHat.Flip(&il.nadirHat)
+		_, cosThetaMin := sincosFloat32(
+			(180 - thetaMin) * (math.Pi / 180))
+		normalCone = directionCone{zenithHat, cosThetaMin}
+	}
+	_, cosThetaE := sincosFloat32(_LIGHT_BOUNDS_MIN_THETA_E)
+	return lightBounds{
+		bound:      MakeBBoxFromPoint(il.position),
+		power:      power.Y(),
+		normalCone: normalCone,
+		cosThetaE:  cosThetaE,
+	}, true
+}
@@ -0,0 +1,59 @@
+package ilium
+
+import "os"
+import "path/filepath"
+import "testing"
+
+// A rotationally symmetric profile that emits only into the lower
+// hemisphere.
+const _TEST_DOWNWARD_IES_PROFILE = `IESNA:LM-63-2002
+TILT=NONE
+1 -1 1 4 1 1 2 0 0 0
+1.0 1.0 20
+0 30 60 90
+0
+100 80 40 0
+`
+
+func makeTestIESLight(path string) *IESLight {
+	return MakeIESLight(map[string]interface{}{
+		"path":   path,
+		"target": []interface{}{0.0, 0.0, -1.0},
+	}, makeTestPointShapes(0, 0, 0))
+}
+
+func TestIESLightBoundsImportance(t *testing.T) {
+	downwardPath := filepath.Join(t.TempDir(), "downward.ies")
+	err := os.WriteFile(
+		downwardPath, []byte(_TEST_DOWNWARD_IES_PROFILE), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	below := Point3{0.5, 0, -2}
+	above := Point3{0, 0, 2}
+	tests := []struct {
+		path                  string
+		expectImportanceAbove bool
+	}{
+		{"../examples/downlight.ies", true},
+		{downwardPath, false},
+	}
+	for _, test := range tests {
+		light := makeTestIESLight(test.path)
+		bounds, ok := light.getLightBounds()
+		if !ok {
+			t.Fatalf("%s: no bounds", test.path)
+		}
+		if importance := bounds.computeImportance(
+			below, Normal3{}); importance <= 0 {
+			t.Errorf("%s: importance below=%f, expected > 0",
+				test.path, importance)
+		}
+		importanceAbove := bounds.computeImportance(above, Normal3{})
+		if (importanceAbove > 0) != test.expectImportanceAbove {
+			t.Errorf("%s: importance above=%f, expected "+
+				"nonzero=%t", test.path, importanceAbove,
+				test.expectImportanceAbove)
+		}
+	}
+}
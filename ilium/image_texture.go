@@ -0,0 +1,138 @@
+package ilium
+
+import "image"
+import _ "image/jpeg"
+import _ "image/png"
+import "math"
+import "os"
+import "path/filepath"
+import "strings"
+
+// An ImageTexture looks up (u, v) in an image with bilinear
+// filtering. The bottom row of the image is at v = 0, and the left
+// column is at u = 0.
+//
+// 8-bit images are assumed to be sRGB-encoded unless their encoding
+// is given as linear, which is what images of non-color data (e.g.,
+// roughness maps) usually are.
+type ImageTexture struct {
+	width  int
+	height int
+	// In row-major order, starting from the top row.
+	pixels []Spectrum
+}
+
+// Converts an 8-bit linear value to a float in [0, 1].
+func convertLinear8ToFloat(x uint8) float32 {
+	return float32(x) / 255
+}
+
+// Converts an 8-bit sRGB-encoded value to a linear one.
+func convertSRGBToLinear(x uint8) float32 {
+	c := float32(x) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return powFloat32((c+0.055)/1.055, 2.4)
+}
+
+// Reads an 8-bit image (e.g., a PNG or JPEG) at the given path,
+// decoding each channel with the given function, and returns its
+// pixels in row-major order, starting from the top row.
+func read8BitImageFile(path string, decode func(uint8) float32) (
+	width, height int, pixels []Spectrum, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return
+	}
+	bounds := img.Bounds()
+	width = bounds.Dx()
+	height = bounds.Dy()
+	pixels = make([]Spectrum, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			// Alpha is ignored.
+			r, g, b, _ := c.RGBA()
+			pixels[y*width+x] = MakeRGBSpectrum(
+				decode(uint8(r>>8)),
+				decode(uint8(g>>8)),
+				decode(uint8(b>>8)))
+		}
+	}
+	return
+}
+
+func MakeImageTexture(config map[string]interface{}) *ImageTexture {
+	path := config["path"].(string)
+	var width, height int
+	var pixels []Spectrum
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hdr":
+		width, height, pixels, err = readRadianceHdrFile(path)
+	default:
+		decode := convertSRGBToLinear
+		if encodingConfig, ok := config["encoding"]; ok {
+			switch encodingConfig.(string) {
+			case "sRGB":
+			case "linear":
+				decode = convertLinear8ToFloat
+			default:
+				panic("unknown encoding " +
+					encodingConfig.(string))
+			}
+		}
+		width, height, pixels, err = read8BitImageFile(path, decode)
+	}
+	if err != nil {
+		panic(err)
+	}
+	if scaleConfig, ok := config["scale"]; ok {
+		scale := MakeSpectrumFromConfig(
+			scaleConfig.(map[string]interface{}))
+		for i := 0; i < len(pixels); i++ {
+			pixels[i].Mul(&pixels[i], &scale)
+		}
+	}
+	return &ImageTexture{width, height, pixels}
+}
+
+func (it *ImageTexture) getPixel(x, y int) *Spectrum {
+	x %= it.width
+	if x < 0 {
+		x += it.width
+	}
+	y %= it.height
+	if y < 0 {
+		y += it.height
+	}
+	return &it.pixels[y*it.width+x]
+}
+
+func (it *ImageTexture) Evaluate(u, v float32) Spectrum {
+	// Pixel centers are at half-integer coordinates.
+	x := wrapTextureCoordinate(u)*float32(it.width) - 0.5
+	y := (1-wrapTextureCoordinate(v))*float32(it.height) - 0.5
+	x0 := math.Floor(float64(x))
+	y0 := math.Floor(float64(y))
+	dx := x - float32(x0)
+	dy := y - float32(y0)
+	xi := int(x0)
+	yi := int(y0)
+	var s00, s10, s01, s11, s Spectrum
+	s00.Scale(it.getPixel(xi, yi), (1-dx)*(1-dy))
+	s10.Scale(it.getPixel(xi+1, yi), dx*(1-dy))
+	s01.Scale(it.getPixel(xi, yi+1), (1-dx)*dy)
+	s11.Scale(it.getPixel(xi+1, yi+1), dx*dy)
+	s.Add(&s00, &s10)
+	s.Add(&s, &s01)
+	s.Add(&s, &s11)
+	return s
+}
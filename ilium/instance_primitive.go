@@ -0,0 +1,115 @@
+package ilium
+
+// An InstancePrimitive places a shared primitive, defined in object
+// space, into world space with an affine transform. Any number of
+// instances can refer to the same primitive without duplicating it.
+//
+// Lights and sensors beneath the primitive are wrapped per instance
+// so that they are also transformed, but this is supported only for
+// rigid transforms.
+type InstancePrimitive struct {
+	primitive     Primitive
+	objectToWorld Transform
+	worldToObject Transform
+	lights        map[Light]Light
+	sensors       map[Sensor]Sensor
+}
+
+func MakeInstancePrimitive(
+	primitive Primitive, objectToWorld Transform) *InstancePrimitive {
+	lights := make(map[Light]Light)
+	for _, light := range primitive.GetLights() {
+		lights[light] = makeTransformedLight(light, objectToWorld)
+	}
+	sensors := make(map[Sensor]Sensor)
+	for _, sensor := range primitive.GetSensors() {
+		sensors[sensor] = makeTransformedSensor(sensor, objectToWorld)
+	}
+	return &InstancePrimitive{
+		primitive, objectToWorld, objectToWorld.Inverse(),
+		lights, sensors,
+	}
+}
+
+func MakeInstancePrimitiveFromConfig(
+	config map[string]interface{},
+	namedPrimitives map[string]Primitive) *InstancePrimitive {
+	name := config["name"].(string)
+	primitive, ok := namedPrimitives[name]
+	if !ok {
+		panic("unknown named primitive " + name)
+	}
+	var objectToWorld Transform
+	if transformConfig, ok := config["transform"]; ok {
+		objectToWorld = MakeTransformFromConfig(transformConfig)
+	} else {
+		objectToWorld = MakeIdentityTransform()
+	}
+	return MakeInstancePrimitive(primitive, objectToWorld)
+}
+
+func (ip *InstancePrimitive) Intersect(
+	ray *Ray, intersection *Intersection) bool {
+	// Shapes assume that ray directions are normalized, so
+	// normalize the object-space direction and scale the
+	// parameter range to match.
+	objectRay := ip.worldToObject.TransformRay(*ray)
+	l := objectRay.D.Norm()
+	objectRay.D.Scale(&objectRay.D, 1/l)
+	objectRay.MinT *= l
+	objectRay.MaxT *= l
+	if !ip.primitive.Intersect(&objectRay, intersection) {
+		return false
+	}
+	if intersection != nil {
+		intersection.T /= l
+		intersection.P = ip.objectToWorld.TransformPoint(
+			intersection.P)
+		intersection.PEpsilon /= l
+		intersection.N = transformNormalNormalized(
+			&ip.objectToWorld, intersection.N)
+		intersection.ShadingN = transformNormalNormalized(
+			&ip.objectToWorld, intersection.ShadingN)
+		intersection.DPDU = ip.objectToWorld.TransformVector(
+			intersection.DPDU)
+		intersection.DPDV = ip.objectToWorld.TransformVector(
+			intersection.DPDV)
+		if intersection.Light != nil {
+			intersection.Light = ip.lights[intersection.Light]
+		}
+		if len(intersection.Sensors) > 0 {
+			sensors := make([]Sensor, len(intersection.Sensors))
+			for i, sensor := range intersection.Sensors {
+				sensors[i] = ip.sensors[sensor]
+			}
+			intersection.Sensors = sensors
+		}
+	}
+	return true
+}
+
+func (ip *InstancePrimitive) WorldBound() BBox {
+	return ip.objectToWorld.TransformBBox(ip.primitive.WorldBound())
+}
+
+// Returns the sensors in the same order as the primitive does,
+// instead of in the (random) iteration order of ip.sensors, so that
+// renders are reproducible.
+func (ip *InstancePrimitive) GetSensors() []Sensor {
+	sensors := []Sensor{}
+	for _, sensor := range ip.primitive.GetSensors() {
+		sensors = append(sensors, ip.sensors[sensor])
+	}
+	return sensors
+}
+
+// Like GetSensors(), returns the lights in the same order as the
+// primitive does, which also determines which light each light
+// sample picks.
+func (ip *InstancePrimitive) GetLights() []Light {
+	lights := []Light{}
+	for _, light := range ip.primitive.GetLights() {
+		lights = append(lights, ip.lights[light])
+	}
+	return lights
+}
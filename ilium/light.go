@@ -1,6 +1,16 @@
 package ilium
 
 type Light interface {
+	// Returns whether or not this light has a specular position
+	// (e.g., it consists of a single point), in which case it
+	// can't be hit by rays and it has no surface normal.
+	HasSpecularPosition() bool
+
+	// Returns whether or not this light emits light only in a
+	// single direction (e.g., it's infinitely far away), in
+	// which case it can't be hit by rays.
+	HasSpecularDirection() bool
+
 	GetSampleConfig() SampleConfig
 	SampleSurface(sampleBundle SampleBundle) (
 		pSurface Point3, pSurfaceEpsilon float32,
@@ -47,6 +57,45 @@ type Light interface {
 	ComputeLeDirectionalPdf(
 		pSurface Point3, nSurface Normal3, wo Vector3) float32
 	ComputeLe(pSurface Point3, nSurface Normal3, wo Vector3) Spectrum
+
+	// Returns the total power emitted by this light. For lights
+	// that surround the scene, this is the power entering the
+	// scene's bounding sphere, and may be an approximation.
+	Power() Spectrum
+}
+
+// Lights that occupy a bounded region of space implement
+// boundedLight, which lets them be picked by a light BVH.
+type boundedLight interface {
+	Light
+
+	// Returns the bounds of the light's emission, or false if
+	// it turns out to be unbounded.
+	getLightBounds() (lightBounds, bool)
+}
+
+// Lights whose emission varies over their surface (e.g., with an
+// emission texture) implement uvLight, which lets the emitted
+// radiance be computed from the (u, v) coordinates of a point
+// (e.g., of an intersection) instead of by looking them up.
+type uvLight interface {
+	Light
+
+	// Like ComputeLe(), but also given the (u, v) coordinates
+	// of pSurface.
+	computeLeWithUV(pSurface Point3, u, v float32,
+		nSurface Normal3, wo Vector3) Spectrum
+}
+
+// Returns the radiance emitted by the given light from the point
+// pSurface, which has the given (u, v) coordinates and normal, in
+// the direction wo.
+func computeLightLeWithUV(light Light, pSurface Point3, u, v float32,
+	nSurface Normal3, wo Vector3) Spectrum {
+	if uvLight, ok := light.(uvLight); ok {
+		return uvLight.computeLeWithUV(pSurface, u, v, nSurface, wo)
+	}
+	return light.ComputeLe(pSurface, nSurface, wo)
 }
 
 func MakeLight(config map[string]interface{}, shapes []Shape) Light {
@@ -54,11 +103,36 @@ func MakeLight(config map[string]interface{}, shapes []Shape) Light {
 	switch lightType {
 	case "DiffuseAreaLight":
 		return MakeDiffuseAreaLight(config, shapes)
+	case "PointLight":
+		return MakePointLight(config, shapes)
+	case "SpotLight":
+		return MakeSpotLight(config, shapes)
+	case "IESLight":
+		return MakeIESLight(config, shapes)
 	default:
 		panic("unknown light type " + lightType)
 	}
 }
 
+// Makes a light that isn't attached to any primitive, given the
+// bounds of the rest of the scene.
+func MakeInfiniteLight(
+	config map[string]interface{}, sceneBound BBox) Light {
+	lightType := config["type"].(string)
+	switch lightType {
+	case "DirectionalLight":
+		return MakeDirectionalLight(config, sceneBound)
+	case "EnvironmentLight":
+		return MakeEnvironmentLight(config, sceneBound)
+	case "ConstantEnvironmentLight":
+		return MakeConstantEnvironmentLight(config, sceneBound)
+	case "SkyLight":
+		return MakeSkyLight(config, sceneBound)
+	default:
+		panic("unknown infinite light type " + lightType)
+	}
+}
+
 // A wrapper that implements the Material interface in terms of Light
 // functions.
 type LightMaterial struct {
@@ -81,3 +155,7 @@ func (lm *LightMaterial) ComputePdf(transportType MaterialTransportType,
 	wo, wi Vector3, n Normal3) float32 {
 	return lm.light.ComputeLeDirectionalPdf(lm.pSurface, n, wi)
 }
+
+func (lm *LightMaterial) IsSpecular() bool {
+	return false
+}
@@ -0,0 +1,170 @@
+package ilium
+
+import "math"
+
+// A directionCone bounds a set of directions by a cone around w
+// with half-angle acos(cosTheta). A cosTheta of -1 means the entire
+// sphere of directions, and w may then be arbitrary.
+type directionCone struct {
+	w        Vector3
+	cosTheta float32
+}
+
+func makeEntireSphereDirectionCone() directionCone {
+	return directionCone{Vector3{0, 0, 1}, -1}
+}
+
+// Returns the smallest cone (roughly) that contains both c1 and c2.
+func unionDirectionCones(c1, c2 *directionCone) directionCone {
+	if c1.cosTheta == -1 || c2.cosTheta == -1 {
+		return makeEntireSphereDirectionCone()
+	}
+	theta1 := float32(math.Acos(float64(c1.cosTheta)))
+	theta2 := float32(math.Acos(float64(c2.cosTheta)))
+	cosThetaD := minFloat32(maxFloat32(c1.w.Dot(&c2.w), -1), 1)
+	thetaD := float32(math.Acos(float64(cosThetaD)))
+	if minFloat32(thetaD+theta2, math.Pi) <= theta1 {
+		return *c1
+	}
+	if minFloat32(thetaD+theta1, math.Pi) <= theta2 {
+		return *c2
+	}
+
+	thetaO := 0.5 * (theta1 + thetaD + theta2)
+	if thetaO >= math.Pi {
+		return makeEntireSphereDirectionCone()
+	}
+
+	// Rotate c1.w towards c2.w by thetaO - theta1.
+	var axis Vector3
+	axis.CrossNoAlias(&c1.w, &c2.w)
+	if axis.NormSq() == 0 {
+		return makeEntireSphereDirectionCone()
+	}
+	axis.Normalize(&axis)
+	var perp Vector3
+	perp.CrossNoAlias(&axis, &c1.w)
+	sinThetaR, cosThetaR := sincosFloat32(thetaO - theta1)
+	var wParallel, wPerp, w Vector3
+	wParallel.Scale(&c1.w, cosThetaR)
+	wPerp.Scale(&perp, sinThetaR)
+	w.Add(&wParallel, &wPerp)
+	w.Normalize(&w)
+	_, cosThetaO := sincosFloat32(thetaO)
+	return directionCone{w, cosThetaO}
+}
+
+// A lightBounds bounds the emission of a light, or part of one, for
+// the purposes of estimating how much it contributes to a point.
+// Surface normals at the light lie within normalCone, and light is
+// emitted at most acos(cosThetaE) away from those normals (on both
+// sides of the surface, if twoSided is set).
+type lightBounds struct {
+	bound      BBox
+	power      float32
+	normalCone directionCone
+	cosThetaE  float32
+	twoSided   bool
+}
+
+// The smallest spread (in radians) to use for the emission of a
+// light around its normals, e.g. for lights whose emission exactly
+// fills their normal cone, since computeImportance() treats a
+// cosThetaE of 1 as not emitting in any direction.
+const _LIGHT_BOUNDS_MIN_THETA_E float32 = 1e-3
+
+func unionLightBounds(lb1, lb2 *lightBounds) lightBounds {
+	if lb1.power == 0 {
+		return *lb2
+	}
+	if lb2.power == 0 {
+		return *lb1
+	}
+	var bound BBox
+	bound.Union(&lb1.bound, &lb2.bound)
+	return lightBounds{
+		bound:      bound,
+		power:      lb1.power + lb2.power,
+		normalCone: unionDirectionCones(&lb1.normalCone, &lb2.normalCone),
+		cosThetaE:  minFloat32(lb1.cosThetaE, lb2.cosThetaE),
+		twoSided:   lb1.twoSided || lb2.twoSided,
+	}
+}
+
+// Returns cos(max(0, a - b)) given the sines and cosines of a and b.
+func cosSubClamped(sinA, cosA, sinB, cosB float32) float32 {
+	if cosA > cosB {
+		return 1
+	}
+	return cosA*cosB + sinA*sinB
+}
+
+// Returns sin(max(0, a - b)) given the sines and cosines of a and b.
+func sinSubClamped(sinA, cosA, sinB, cosB float32) float32 {
+	if cosA > cosB {
+		return 0
+	}
+	return sinA*cosB - cosA*sinB
+}
+
+// Returns a conservative estimate of how much light from the bounded
+// emitters reaches p, which has normal n (which may be zero if p has
+// no normal), following "Importance Sampling of Many Lights with
+// Adaptive Tree Splitting" by Conty Estevez and Kulla.
+func (lb *lightBounds) computeImportance(p Point3, n Normal3) float32 {
+	if lb.power == 0 {
+		return 0
+	}
+
+	pCenter := lb.bound.GetCenter()
+	var wi Vector3
+	d := wi.GetDirectionAndDistance(&pCenter, &p)
+	// Avoid blowing up for points close to (or in) the bounds.
+	diagonal := lb.bound.GetDiagonal()
+	d2 := maxFloat32(d*d, 0.5*diagonal.Norm())
+	if d == 0 {
+		wi = lb.normalCone.w
+	}
+
+	// The angle between the normal cone's axis and the
+	// direction to p.
+	cosThetaW := lb.normalCone.w.Dot(&wi)
+	if lb.twoSided {
+		cosThetaW = absFloat32(cosThetaW)
+	}
+	sinThetaW := cosToSin(minFloat32(maxFloat32(cosThetaW, -1), 1))
+
+	// The half-angle of the cone from p that contains the
+	// bounds.
+	cosThetaB := float32(-1)
+	_, radius := lb.bound.BoundingSphere()
+	if d > radius {
+		sinThetaB := radius / d
+		cosThetaB = sinToCos(sinThetaB)
+	}
+	sinThetaB := cosToSin(cosThetaB)
+
+	// The smallest angle between any emitter normal and any
+	// direction from the bounds to p.
+	cosThetaO := lb.normalCone.cosTheta
+	sinThetaO := cosToSin(cosThetaO)
+	cosThetaX := cosSubClamped(sinThetaW, cosThetaW, sinThetaO, cosThetaO)
+	sinThetaX := sinSubClamped(sinThetaW, cosThetaW, sinThetaO, cosThetaO)
+	cosThetaP := cosSubClamped(sinThetaX, cosThetaX, sinThetaB, cosThetaB)
+	if cosThetaP <= lb.cosThetaE {
+		return 0
+	}
+
+	importance := lb.power * cosThetaP / d2
+
+	if n != (Normal3{}) {
+		// The smallest angle between n (or -n) and any
+		// direction from p to the bounds.
+		cosThetaI := absFloat32(wi.DotNormal(&n))
+		sinThetaI := cosToSin(minFloat32(cosThetaI, 1))
+		importance *= cosSubClamped(
+			sinThetaI, cosThetaI, sinThetaB, cosThetaB)
+	}
+
+	return maxFloat32(importance, 0)
+}
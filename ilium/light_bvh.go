@@ -0,0 +1,189 @@
+package ilium
+
+import "sort"
+
+// A node of a lightBVH, stored in depth-first order so that the
+// first child of an interior node immediately follows it.
+type lightBVHNode struct {
+	bounds lightBounds
+	// For leaf nodes, the index of the emitter; for interior
+	// nodes, the index of the second child.
+	offset int
+	isLeaf bool
+}
+
+// A lightBVH is a bounding volume hierarchy over a list of emitters
+// (lights, or parts of a light) that picks an emitter for a point by
+// walking down the tree, choosing each child in proportion to its
+// estimated importance to the point.
+type lightBVH struct {
+	nodes []lightBVHNode
+	// For each emitter, the path from the root to its leaf,
+	// with bit i set if the second child was taken at depth i.
+	bitTrails []uint64
+}
+
+func makeLightBVH(emitterBounds []lightBounds) *lightBVH {
+	bvh := &lightBVH{bitTrails: make([]uint64, len(emitterBounds))}
+	if len(emitterBounds) == 0 {
+		return bvh
+	}
+	indices := make([]int, len(emitterBounds))
+	for i := range indices {
+		indices[i] = i
+	}
+	bvh.buildRecursive(emitterBounds, indices, 0, 0)
+	return bvh
+}
+
+func (bvh *lightBVH) buildRecursive(
+	emitterBounds []lightBounds, indices []int,
+	bitTrail uint64, depth uint) lightBounds {
+	nodeIndex := len(bvh.nodes)
+	bvh.nodes = append(bvh.nodes, lightBVHNode{})
+	if len(indices) == 1 {
+		i := indices[0]
+		bvh.nodes[nodeIndex] = lightBVHNode{
+			bounds: emitterBounds[i],
+			offset: i,
+			isLeaf: true,
+		}
+		bvh.bitTrails[i] = bitTrail
+		return emitterBounds[i]
+	}
+
+	// Split at the median along the axis in which the centroids
+	// vary the most.
+	centroidBound := MakeEmptyBBox()
+	for _, i := range indices {
+		centroid := emitterBounds[i].bound.GetCenter()
+		centroidBound.UnionPoint(&centroidBound, &centroid)
+	}
+	axis := centroidBound.MaximumExtent()
+	sort.Slice(indices, func(a, b int) bool {
+		ca := emitterBounds[indices[a]].bound.GetCenter()
+		cb := emitterBounds[indices[b]].bound.GetCenter()
+		return ((*R3)(&ca)).GetComponent(axis) <
+			((*R3)(&cb)).GetComponent(axis)
+	})
+	mid := len(indices) / 2
+
+	bounds0 := bvh.buildRecursive(
+		emitterBounds, indices[:mid], bitTrail, depth+1)
+	secondChildIndex := len(bvh.nodes)
+	bounds1 := bvh.buildRecursive(
+		emitterBounds, indices[mid:], bitTrail|(1<<depth), depth+1)
+	bounds := unionLightBounds(&bounds0, &bounds1)
+	bvh.nodes[nodeIndex] = lightBVHNode{
+		bounds: bounds,
+		offset: secondChildIndex,
+	}
+	return bounds
+}
+
+// Returns the probability of picking the first child of the given
+// interior node from the given point, or -1 if neither child is
+// important to it.
+func (bvh *lightBVH) computeFirstChildProbability(
+	nodeIndex int, p Point3, n Normal3) float32 {
+	node := &bvh.nodes[nodeIndex]
+	importance0 := bvh.nodes[nodeIndex+1].bounds.computeImportance(p, n)
+	importance1 := bvh.nodes[node.offset].bounds.computeImportance(p, n)
+	if importance0 == 0 && importance1 == 0 {
+		return -1
+	}
+	return importance0 / (importance0 + importance1)
+}
+
+// Picks an emitter for the given point with normal n (which may be
+// zero), and returns its index and the probability of picking it,
+// which is 0 if no emitter could be picked.
+func (bvh *lightBVH) sample(u float32, p Point3, n Normal3) (
+	i int, pmf float32) {
+	if len(bvh.nodes) == 0 {
+		return -1, 0
+	}
+	nodeIndex := 0
+	pmf = 1
+	for !bvh.nodes[nodeIndex].isLeaf {
+		p0 := bvh.computeFirstChildProbability(nodeIndex, p, n)
+		if p0 < 0 {
+			return -1, 0
+		}
+		// Reuse u for the next level.
+		if u < p0 {
+			nodeIndex++
+			u = minFloat32(u/p0, _ONE_MINUS_EPSILON)
+			pmf *= p0
+		} else {
+			nodeIndex = bvh.nodes[nodeIndex].offset
+			u = minFloat32((u-p0)/(1-p0), _ONE_MINUS_EPSILON)
+			pmf *= 1 - p0
+		}
+	}
+	i = bvh.nodes[nodeIndex].offset
+	return
+}
+
+// Returns the probability that sample() picks the given emitter for
+// the given point.
+func (bvh *lightBVH) computePmf(i int, p Point3, n Normal3) float32 {
+	if len(bvh.nodes) == 0 {
+		return 0
+	}
+	bitTrail := bvh.bitTrails[i]
+	nodeIndex := 0
+	var pmf float32 = 1
+	for !bvh.nodes[nodeIndex].isLeaf {
+		p0 := bvh.computeFirstChildProbability(nodeIndex, p, n)
+		if p0 < 0 {
+			return 0
+		}
+		if bitTrail&1 == 0 {
+			nodeIndex++
+			pmf *= p0
+		} else {
+			nodeIndex = bvh.nodes[nodeIndex].offset
+			pmf *= 1 - p0
+		}
+		bitTrail >>= 1
+	}
+	return pmf
+}
+
+// Calls visit with the index of and the probability that sample()
+// picks each emitter whose bounds intersect the given ray, where the
+// importance is computed from the ray's origin and n.
+func (bvh *lightBVH) visitEmittersAlongRay(ray *Ray, n Normal3,
+	visit func(i int, pmf float32)) {
+	if len(bvh.nodes) == 0 {
+		return
+	}
+	invD := R3{1 / ray.D.X, 1 / ray.D.Y, 1 / ray.D.Z}
+	bvh.visitEmittersAlongRayRecursive(0, 1, ray, &invD, n, visit)
+}
+
+func (bvh *lightBVH) visitEmittersAlongRayRecursive(
+	nodeIndex int, pmf float32, ray *Ray, invD *R3, n Normal3,
+	visit func(i int, pmf float32)) {
+	node := &bvh.nodes[nodeIndex]
+	if !node.bounds.bound.IntersectRay(ray, invD) {
+		return
+	}
+	if node.isLeaf {
+		visit(node.offset, pmf)
+		return
+	}
+	p0 := bvh.computeFirstChildProbability(nodeIndex, ray.O, n)
+	if p0 < 0 {
+		return
+	}
+	if p0 > 0 {
+		bvh.visitEmittersAlongRayRecursive(
+			nodeIndex+1, pmf*p0, ray, invD, n, visit)
+	}
+	if p0 < 1 {
+		bvh.visitEmittersAlongRayRecursive(
+			node.offset, pmf*(1-p0), ray, invD, n, visit)
+	}
+}
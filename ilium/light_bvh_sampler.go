@@ -0,0 +1,81 @@
+package ilium
+
+// A lightBVHSampler picks lights to sample from a point using a
+// light BVH over the lights that implement boundedLight. The
+// remaining lights (e.g., infinite lights) are picked uniformly, with
+// the BVH as a whole counting as one more light.
+type lightBVHSampler struct {
+	bvh *lightBVH
+	// Maps emitter indices in bvh to light indices.
+	boundedLightIndices []int
+	// Maps light indices to emitter indices in bvh, or -1 for
+	// unbounded lights.
+	emitterIndices        []int
+	unboundedLightIndices []int
+	pUnbounded            float32
+}
+
+func makeLightBVHSampler(lights []Light) *lightBVHSampler {
+	var emitterBounds []lightBounds
+	var boundedLightIndices []int
+	var unboundedLightIndices []int
+	emitterIndices := make([]int, len(lights))
+	for i, light := range lights {
+		emitterIndices[i] = -1
+		if bl, ok := light.(boundedLight); ok {
+			if bounds, ok := bl.getLightBounds(); ok {
+				emitterIndices[i] = len(emitterBounds)
+				emitterBounds = append(emitterBounds, bounds)
+				boundedLightIndices = append(
+					boundedLightIndices, i)
+				continue
+			}
+		}
+		unboundedLightIndices = append(unboundedLightIndices, i)
+	}
+
+	var pUnbounded float32
+	if len(unboundedLightIndices) > 0 {
+		choiceCount := len(unboundedLightIndices)
+		if len(boundedLightIndices) > 0 {
+			choiceCount++
+		}
+		pUnbounded = float32(len(unboundedLightIndices)) /
+			float32(choiceCount)
+	}
+
+	return &lightBVHSampler{
+		bvh:                   makeLightBVH(emitterBounds),
+		boundedLightIndices:   boundedLightIndices,
+		emitterIndices:        emitterIndices,
+		unboundedLightIndices: unboundedLightIndices,
+		pUnbounded:            pUnbounded,
+	}
+}
+
+func (lbs *lightBVHSampler) sample(u float32, p Point3, n Normal3) (
+	i int, pmf float32) {
+	if u < lbs.pUnbounded {
+		unboundedCount := len(lbs.unboundedLightIndices)
+		j := minInt(int(u/lbs.pUnbounded*float32(unboundedCount)),
+			unboundedCount-1)
+		return lbs.unboundedLightIndices[j],
+			lbs.pUnbounded / float32(unboundedCount)
+	}
+	u = minFloat32((u-lbs.pUnbounded)/(1-lbs.pUnbounded),
+		_ONE_MINUS_EPSILON)
+	e, pmfEmitter := lbs.bvh.sample(u, p, n)
+	if pmfEmitter == 0 {
+		return -1, 0
+	}
+	return lbs.boundedLightIndices[e], (1 - lbs.pUnbounded) * pmfEmitter
+}
+
+func (lbs *lightBVHSampler) computePmf(
+	i int, p Point3, n Normal3) float32 {
+	e := lbs.emitterIndices[i]
+	if e < 0 {
+		return lbs.pUnbounded / float32(len(lbs.unboundedLightIndices))
+	}
+	return (1 - lbs.pUnbounded) * lbs.bvh.computePmf(e, p, n)
+}
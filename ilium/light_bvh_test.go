@@ -0,0 +1,106 @@
+package ilium
+
+import "math/rand"
+import "testing"
+
+func makeTestRGBConfig(r, g, b float64) map[string]interface{} {
+	return map[string]interface{}{"type": "rgb", "r": r, "g": g, "b": b}
+}
+
+func makeTestPointShapes(x, y, z float32) []Shape {
+	return []Shape{&PointShape{Point3{x, y, z}}}
+}
+
+// Returns lights of each bounded type, pointing in various
+// directions, including a spot light with a hard edge.
+func makeTestBoundedLights() []Light {
+	disk := MakeDisk(map[string]interface{}{
+		"center": []interface{}{0.0, 3.0, 0.0},
+		"normal": []interface{}{0.0, 0.0, -1.0},
+		"radius": 0.5,
+	})
+	return []Light{
+		MakePointLight(map[string]interface{}{
+			"intensity": makeTestRGBConfig(1, 1, 1),
+		}, makeTestPointShapes(0, 0, 0)),
+		MakeSpotLight(map[string]interface{}{
+			"intensity":      makeTestRGBConfig(5, 5, 5),
+			"target":         []interface{}{3.0, 0.0, -1.0},
+			"innerConeAngle": 20.0,
+			"outerConeAngle": 20.0,
+		}, makeTestPointShapes(3, 0, 0)),
+		MakeSpotLight(map[string]interface{}{
+			"intensity":      makeTestRGBConfig(5, 5, 5),
+			"target":         []interface{}{-3.0, 1.0, 0.0},
+			"innerConeAngle": 10.0,
+			"outerConeAngle": 30.0,
+		}, makeTestPointShapes(-3, 0, 0)),
+		MakeDiffuseAreaLight(map[string]interface{}{
+			"samplingMethod": "uniform",
+			"emission":       makeTestRGBConfig(2, 2, 2),
+		}, []Shape{disk}),
+	}
+}
+
+// Returns random points in [-5, 5]^3, each with either a random
+// normal or no normal.
+func makeTestPointsAndNormals(
+	rng *rand.Rand, count int) ([]Point3, []Normal3) {
+	randomFloat32 := func() float32 {
+		return 10*rng.Float32() - 5
+	}
+	ps := make([]Point3, count)
+	ns := make([]Normal3, count)
+	for i := 0; i < count; i++ {
+		ps[i] = Point3{randomFloat32(), randomFloat32(), randomFloat32()}
+		if i%2 == 0 {
+			ns[i] = Normal3{
+				randomFloat32(), randomFloat32(), randomFloat32(),
+			}
+			ns[i].Normalize(&ns[i])
+		}
+	}
+	return ps, ns
+}
+
+func TestLightBVHSamplePmf(t *testing.T) {
+	lights := makeTestBoundedLights()
+	sampler := makeLightBVHSampler(lights)
+	rng := rand.New(rand.NewSource(1))
+	ps, ns := makeTestPointsAndNormals(rng, 1000)
+	for j := range ps {
+		i, pmf := sampler.sample(rng.Float32(), ps[j], ns[j])
+		if pmf == 0 {
+			continue
+		}
+		expectedPmf := sampler.computePmf(i, ps[j], ns[j])
+		if pmf != expectedPmf {
+			t.Errorf("p=%v, n=%v: light %d has pmf=%f, "+
+				"expected %f", ps[j], ns[j], i, pmf,
+				expectedPmf)
+		}
+	}
+}
+
+func TestLightBVHPicksEveryLight(t *testing.T) {
+	lights := makeTestBoundedLights()
+	sampler := makeLightBVHSampler(lights)
+	rng := rand.New(rand.NewSource(1))
+	ps, ns := makeTestPointsAndNormals(rng, 1000)
+	for i, light := range lights {
+		power := light.Power()
+		if power.Y() <= 0 {
+			continue
+		}
+		canPick := false
+		for j := range ps {
+			if sampler.computePmf(i, ps[j], ns[j]) > 0 {
+				canPick = true
+				break
+			}
+		}
+		if !canPick {
+			t.Errorf("light %d (%T) is never picked", i, light)
+		}
+	}
+}
@@ -8,6 +8,10 @@ const (
 )
 
 type Material interface {
+	// For specular materials, and for directions sampled from
+	// the specular component of a PartiallySpecularMaterial, the
+	// returned pdf is the probability of picking wi out of the
+	// discrete set of possible directions.
 	SampleWi(transportType MaterialTransportType,
 		u1, u2 float32, wo Vector3, n Normal3) (
 		wi Vector3, fDivPdf Spectrum, pdf float32)
@@ -15,6 +19,88 @@ type Material interface {
 		wo, wi Vector3, n Normal3) Spectrum
 	ComputePdf(transportType MaterialTransportType,
 		wo, wi Vector3, n Normal3) float32
+	// Returns whether the BSDF is a delta distribution, in
+	// which case ComputeF and ComputePdf always return zero.
+	IsSpecular() bool
+}
+
+// Materials with both specular and non-specular components (e.g., a
+// smooth coat over a diffuse base) implement
+// PartiallySpecularMaterial. IsSpecular() returns false for them,
+// and ComputeF() and ComputePdf() cover only the non-specular
+// component, with the pdf including the probability of sampling it.
+type PartiallySpecularMaterial interface {
+	Material
+
+	// Like SampleWi(), but also returns whether wi was sampled
+	// from the specular component, in which case the tracers
+	// must treat the vertex as specular for that direction.
+	SampleWiWithSpecular(transportType MaterialTransportType,
+		u1, u2 float32, wo Vector3, n Normal3) (
+		wi Vector3, fDivPdf Spectrum, pdf float32, isSpecular bool)
+}
+
+// Like material.SampleWi(), but also returns whether wi was sampled
+// from a specular component of the material.
+func sampleMaterialWi(material Material,
+	transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32, isSpecular bool) {
+	if partiallySpecularMaterial, ok :=
+		material.(PartiallySpecularMaterial); ok {
+		return partiallySpecularMaterial.SampleWiWithSpecular(
+			transportType, u1, u2, wo, n)
+	}
+	wi, fDivPdf, pdf = material.SampleWi(transportType, u1, u2, wo, n)
+	return wi, fDivPdf, pdf, material.IsSpecular()
+}
+
+// Materials that vary over the surface (e.g., anisotropic ones,
+// which are oriented along dP/du, or ones with parameters from
+// textures) implement VaryingMaterial.
+type VaryingMaterial interface {
+	Material
+
+	// Returns the material evaluated at the given intersection,
+	// which may be this material itself if nothing varies.
+	EvaluateAt(intersection *Intersection) Material
+}
+
+// Materials that can perturb the shading normal (e.g., with bump or
+// normal maps) implement NormalPerturbingMaterial.
+type NormalPerturbingMaterial interface {
+	Material
+
+	// Returns the shading normal to use at the given
+	// intersection, which must be on the same side as its
+	// geometric normal.
+	ComputeShadingNormal(intersection *Intersection) Normal3
+}
+
+// Perturbs the shading normal of the given intersection if its
+// material is a NormalPerturbingMaterial, replaces the material, if
+// it's a VaryingMaterial, with the one evaluated at the intersection,
+// and wraps it to use the shading normal if that differs from the
+// geometric one. This should be called once the closest intersection
+// of a ray is found.
+func evaluateIntersectionMaterial(intersection *Intersection) {
+	if normalPerturbingMaterial, ok :=
+		intersection.Material.(NormalPerturbingMaterial); ok {
+		intersection.ShadingN =
+			normalPerturbingMaterial.ComputeShadingNormal(
+				intersection)
+	}
+	if varyingMaterial, ok :=
+		intersection.Material.(VaryingMaterial); ok {
+		intersection.Material = varyingMaterial.EvaluateAt(
+			intersection)
+	}
+	if intersection.Material != nil &&
+		intersection.ShadingN != intersection.N {
+		intersection.Material = &shadingNormalMaterial{
+			intersection.Material, intersection.ShadingN,
+		}
+	}
 }
 
 func MakeMaterial(config map[string]interface{}) Material {
@@ -24,6 +110,18 @@ func MakeMaterial(config map[string]interface{}) Material {
 		return MakeDiffuseMaterial(config)
 	case "MicrofacetMaterial":
 		return MakeMicrofacetMaterial(config)
+	case "DielectricMaterial":
+		return MakeDielectricMaterial(config)
+	case "SpecularReflectionMaterial":
+		return MakeSpecularReflectionMaterial(config)
+	case "RoughDielectricMaterial":
+		return MakeRoughDielectricMaterial(config)
+	case "MixMaterial":
+		return MakeMixMaterial(config)
+	case "OrenNayarMaterial":
+		return MakeOrenNayarMaterial(config)
+	case "CoatedDiffuseMaterial":
+		return MakeCoatedDiffuseMaterial(config)
 	default:
 		panic("unknown material type " + materialType)
 	}
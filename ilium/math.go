@@ -67,6 +67,25 @@ func tanFloat32(x float32) float32 {
 	return float32(math.Tan(float64(x)))
 }
 
+func expFloat32(x float32) float32 {
+	return float32(math.Exp(float64(x)))
+}
+
+func logFloat32(x float32) float32 {
+	return float32(math.Log(float64(x)))
+}
+
+func erfFloat32(x float32) float32 {
+	return float32(math.Erf(float64(x)))
+}
+
+func erfInvFloat32(x float32) float32 {
+	return float32(math.Erfinv(float64(x)))
+}
+
+// The largest float32 less than 1.
+const _ONE_MINUS_EPSILON float32 = 1 - 1.0/(1<<24)
+
 func cosToSin(cosTh float32) float32 {
 	return sqrtFloat32(maxFloat32(0, 1-cosTh*cosTh))
 }
@@ -0,0 +1,519 @@
+package ilium
+
+import "math"
+
+// A MicrofacetDistribution describes the distribution of microfacet
+// normals of a rough surface. All directions are in the local
+// coordinate system of the surface, i.e. with the surface normal
+// along +Z, and are assumed to be normalized.
+type MicrofacetDistribution interface {
+	// Returns the density of microfacets with normal wh per unit
+	// area and unit solid angle.
+	ComputeD(wh *R3) float32
+	// Returns the fraction of microfacets with normal wh that
+	// are visible from both wo and wi.
+	ComputeG(wo, wi, wh *R3) float32
+	// Returns the fraction of microfacets with normal wh that
+	// are visible from w.
+	ComputeG1(w, wh *R3) float32
+	// Samples wh with pdf D(wh) * |cos(th_h)| with respect to
+	// solid angle.
+	SampleWh(u1, u2 float32) R3
+	// Samples wh from the distribution of normals visible from
+	// wo, i.e. with pdf G1(wo, wh) * max(0, wo * wh) * D(wh) /
+	// |cos(th_o)| with respect to solid angle.
+	SampleVisibleWh(wo *R3, u1, u2 float32) R3
+	// Returns whether SampleVisibleWh() is supported.
+	CanSampleVisibleWh() bool
+	// Returns whether the distribution depends on the azimuth of
+	// wh, i.e. on how the local coordinate system is oriented
+	// around the normal.
+	IsAnisotropic() bool
+}
+
+// A microfacetDistributionTexture gives a MicrofacetDistribution for
+// every (u, v), for distributions with parameters from textures.
+type microfacetDistributionTexture struct {
+	distributionType string
+	// The Blinn exponent, or the roughness along X and Y. Each
+	// is used only if its texture is nil.
+	param1, param2               float32
+	param1Texture, param2Texture SpectrumTexture
+}
+
+// The smallest roughness that textured parameters are clamped to.
+const _MICROFACET_MIN_TEXTURED_ROUGHNESS float32 = 1e-4
+
+func makeMicrofacetDistributionWithParams(
+	distributionType string,
+	param1, param2 float32) MicrofacetDistribution {
+	switch distributionType {
+	case "blinn":
+		return &BlinnMicrofacetDistribution{param1}
+	case "beckmann":
+		return &BeckmannMicrofacetDistribution{param1, param2}
+	case "ggx":
+		return &GGXMicrofacetDistribution{param1, param2}
+	default:
+		panic("unknown microfacet distribution " + distributionType)
+	}
+}
+
+func (mdt *microfacetDistributionTexture) Evaluate(
+	u, v float32) MicrofacetDistribution {
+	param1 := mdt.param1
+	if mdt.param1Texture != nil {
+		param1 = evaluateFloatTexture(mdt.param1Texture, u, v)
+	}
+	param2 := mdt.param2
+	if mdt.param2Texture != nil {
+		param2 = evaluateFloatTexture(mdt.param2Texture, u, v)
+	}
+	if mdt.distributionType == "blinn" {
+		param1 = maxFloat32(param1, 0)
+	} else {
+		param1 = maxFloat32(param1, _MICROFACET_MIN_TEXTURED_ROUGHNESS)
+		param2 = maxFloat32(param2, _MICROFACET_MIN_TEXTURED_ROUGHNESS)
+	}
+	return makeMicrofacetDistributionWithParams(
+		mdt.distributionType, param1, param2)
+}
+
+// Reads the microfacet distribution from the given config. If any of
+// its parameters are textures, returns a nil distribution and a
+// texture for it instead.
+func makeMicrofacetDistributionFromConfig(
+	config map[string]interface{}) (
+	distribution MicrofacetDistribution,
+	texture *microfacetDistributionTexture) {
+	distributionType := "blinn"
+	if distributionConfig, ok := config["distribution"]; ok {
+		distributionType = distributionConfig.(string)
+	}
+	var param1, param2 float32
+	var param1Texture, param2Texture SpectrumTexture
+	switch distributionType {
+	case "blinn":
+		param1, param1Texture = makeFloatOrTextureFromConfig(
+			config["blinnExponent"])
+	case "beckmann", "ggx":
+		param1, param2, param1Texture, param2Texture =
+			makeMicrofacetRoughnessFromConfig(config)
+	default:
+		panic("unknown microfacet distribution " + distributionType)
+	}
+	if param1Texture != nil || param2Texture != nil {
+		texture = &microfacetDistributionTexture{
+			distributionType,
+			param1, param2, param1Texture, param2Texture,
+		}
+		return
+	}
+	distribution = makeMicrofacetDistributionWithParams(
+		distributionType, param1, param2)
+	return
+}
+
+// Reads either "roughness", for an isotropic distribution, or
+// "roughnessX" and "roughnessY", for an anisotropic one, where X is
+// along dP/du. Roughness values are the alpha parameters of the
+// distribution, i.e. the RMS microfacet slopes (times sqrt(2) for
+// Beckmann), and may be given by textures instead.
+func makeMicrofacetRoughnessFromConfig(
+	config map[string]interface{}) (alphaX, alphaY float32,
+	alphaXTexture, alphaYTexture SpectrumTexture) {
+	if roughnessConfig, ok := config["roughness"]; ok {
+		alphaX, alphaXTexture =
+			makeFloatOrTextureFromConfig(roughnessConfig)
+		alphaY, alphaYTexture = alphaX, alphaXTexture
+	} else {
+		alphaX, alphaXTexture =
+			makeFloatOrTextureFromConfig(config["roughnessX"])
+		alphaY, alphaYTexture =
+			makeFloatOrTextureFromConfig(config["roughnessY"])
+	}
+	if (alphaXTexture == nil && alphaX <= 0) ||
+		(alphaYTexture == nil && alphaY <= 0) {
+		panic("roughness must be positive")
+	}
+	return
+}
+
+// Helper functions for directions in the local coordinate system.
+
+func localCos2Th(w *R3) float32 {
+	return w.Z * w.Z
+}
+
+func localTan2Th(w *R3) float32 {
+	cos2Th := localCos2Th(w)
+	return maxFloat32(0, 1-cos2Th) / cos2Th
+}
+
+// Returns cos^2(phi) and sin^2(phi) for w, or (1, 0) if w is along
+// the normal.
+func localCos2Sin2Phi(w *R3) (cos2Phi, sin2Phi float32) {
+	sin2Th := w.X*w.X + w.Y*w.Y
+	if sin2Th == 0 {
+		return 1, 0
+	}
+	return w.X * w.X / sin2Th, w.Y * w.Y / sin2Th
+}
+
+// Returns the alpha parameter of an anisotropic distribution in the
+// plane containing w and the normal.
+func projectMicrofacetAlpha(w *R3, alphaX, alphaY float32) float32 {
+	cos2Phi, sin2Phi := localCos2Sin2Phi(w)
+	return sqrtFloat32(cos2Phi*alphaX*alphaX + sin2Phi*alphaY*alphaY)
+}
+
+// Returns the height-correlated Smith masking-shadowing function
+// given the Smith lambda function of a distribution.
+func computeSmithG(
+	wo, wi, wh *R3, computeLambda func(w *R3) float32) float32 {
+	if wo.Dot(wh)*wo.Z <= 0 || wi.Dot(wh)*wi.Z <= 0 {
+		return 0
+	}
+	return 1 / (1 + computeLambda(wo) + computeLambda(wi))
+}
+
+func computeSmithG1(w, wh *R3, computeLambda func(w *R3) float32) float32 {
+	if w.Dot(wh)*w.Z <= 0 {
+		return 0
+	}
+	return 1 / (1 + computeLambda(w))
+}
+
+// Samples phi for an anisotropic distribution, so that
+// tan(phi) = (alphaY / alphaX) * tan(2 * pi * u).
+func sampleAnisotropicMicrofacetPhi(u, alphaX, alphaY float32) float32 {
+	phi := float32(math.Atan(float64(
+		alphaY / alphaX * tanFloat32(2*math.Pi*u+0.5*math.Pi))))
+	if u > 0.5 {
+		phi += math.Pi
+	}
+	return phi
+}
+
+// The Blinn distribution D(wh) = (e + 2) * cos^e(th_h) / (2 * pi),
+// which is used with the V-cavity masking-shadowing function.
+type BlinnMicrofacetDistribution struct {
+	exponent float32
+}
+
+func (b *BlinnMicrofacetDistribution) ComputeD(wh *R3) float32 {
+	if wh.Z <= 0 {
+		return 0
+	}
+	e := b.exponent
+	return (e + 2) * powFloat32(wh.Z, e) / (2 * math.Pi)
+}
+
+func (b *BlinnMicrofacetDistribution) ComputeG(wo, wi, wh *R3) float32 {
+	absWoDotWh := absFloat32(wo.Dot(wh))
+	return minFloat32(1, 2*absFloat32(wh.Z)*minFloat32(
+		absFloat32(wo.Z), absFloat32(wi.Z))/absWoDotWh)
+}
+
+func (b *BlinnMicrofacetDistribution) ComputeG1(w, wh *R3) float32 {
+	absWDotWh := absFloat32(w.Dot(wh))
+	return minFloat32(1, 2*absFloat32(wh.Z)*absFloat32(w.Z)/absWDotWh)
+}
+
+func (b *BlinnMicrofacetDistribution) SampleWh(u1, u2 float32) R3 {
+	absCosThH := powFloat32(u1, 1/(b.exponent+2))
+	phiH := 2 * math.Pi * u2
+	return MakeSphericalDirection(absCosThH, phiH)
+}
+
+// Samples wh with pdf (e + 1) * cos^e(th_h) / (2 * pi), i.e.
+// proportional to D(wh) alone.
+func (b *BlinnMicrofacetDistribution) sampleWhWithoutCosine(
+	u1, u2 float32) R3 {
+	absCosThH := powFloat32(u1, 1/(b.exponent+1))
+	phiH := 2 * math.Pi * u2
+	return MakeSphericalDirection(absCosThH, phiH)
+}
+
+func (b *BlinnMicrofacetDistribution) computePdfWithoutCosine(
+	wh *R3) float32 {
+	e := b.exponent
+	return (e + 1) * b.ComputeD(wh) / (e + 2)
+}
+
+func (b *BlinnMicrofacetDistribution) SampleVisibleWh(
+	wo *R3, u1, u2 float32) R3 {
+	panic("visible normal sampling is not supported for Blinn")
+}
+
+func (b *BlinnMicrofacetDistribution) CanSampleVisibleWh() bool {
+	return false
+}
+
+func (b *BlinnMicrofacetDistribution) IsAnisotropic() bool {
+	return false
+}
+
+// The anisotropic Beckmann distribution, used with the Smith
+// masking-shadowing function.
+type BeckmannMicrofacetDistribution struct {
+	alphaX, alphaY float32
+}
+
+func (b *BeckmannMicrofacetDistribution) ComputeD(wh *R3) float32 {
+	if wh.Z <= 0 {
+		return 0
+	}
+	tan2Th := localTan2Th(wh)
+	if !isFiniteFloat32(tan2Th) {
+		return 0
+	}
+	cos2Th := localCos2Th(wh)
+	cos2Phi, sin2Phi := localCos2Sin2Phi(wh)
+	ax2 := b.alphaX * b.alphaX
+	ay2 := b.alphaY * b.alphaY
+	e := tan2Th * (cos2Phi/ax2 + sin2Phi/ay2)
+	return expFloat32(-e) /
+		(math.Pi * b.alphaX * b.alphaY * cos2Th * cos2Th)
+}
+
+// Uses the rational approximation from Walter et al.
+func (b *BeckmannMicrofacetDistribution) computeLambda(w *R3) float32 {
+	tan2Th := localTan2Th(w)
+	if !isFiniteFloat32(tan2Th) {
+		return 0
+	}
+	alpha := projectMicrofacetAlpha(w, b.alphaX, b.alphaY)
+	a := 1 / (alpha * sqrtFloat32(tan2Th))
+	if a >= 1.6 {
+		return 0
+	}
+	return (1 - 1.259*a + 0.396*a*a) / (3.535*a + 2.181*a*a)
+}
+
+func (b *BeckmannMicrofacetDistribution) ComputeG(wo, wi, wh *R3) float32 {
+	return computeSmithG(wo, wi, wh, b.computeLambda)
+}
+
+func (b *BeckmannMicrofacetDistribution) ComputeG1(w, wh *R3) float32 {
+	return computeSmithG1(w, wh, b.computeLambda)
+}
+
+func (b *BeckmannMicrofacetDistribution) SampleWh(u1, u2 float32) R3 {
+	logU := logFloat32(1 - u1)
+	var tan2Th, phi float32
+	if b.alphaX == b.alphaY {
+		tan2Th = -b.alphaX * b.alphaX * logU
+		phi = 2 * math.Pi * u2
+	} else {
+		phi = sampleAnisotropicMicrofacetPhi(u2, b.alphaX, b.alphaY)
+		sinPhi, cosPhi := sincosFloat32(phi)
+		tan2Th = -logU / (cosPhi*cosPhi/(b.alphaX*b.alphaX) +
+			sinPhi*sinPhi/(b.alphaY*b.alphaY))
+	}
+	cosTh := 1 / sqrtFloat32(1+tan2Th)
+	return MakeSphericalDirection(cosTh, phi)
+}
+
+// Samples the slopes of the visible normals of the Beckmann
+// distribution with alpha = 1 and the given incident angle, by
+// numerically inverting the CDF of the x slope.
+func sampleBeckmannSlopes11(cosThO, u1, u2 float32) (slopeX, slopeY float32) {
+	if cosThO > 0.9999 {
+		// Normal incidence, where the visible normals are
+		// distributed like all normals.
+		r := sqrtFloat32(-logFloat32(1 - u1))
+		sinPhi, cosPhi := sincosFloat32(2 * math.Pi * u2)
+		return r * cosPhi, r * sinPhi
+	}
+
+	sinThO := cosToSin(cosThO)
+	tanThO := sinThO / cosThO
+	cotThO := 1 / tanThO
+
+	// Search in the range of erf().
+	a := float32(-1)
+	c := erfFloat32(cotThO)
+	u := maxFloat32(u1, 1e-6)
+
+	// Start with an approximation of the inverse.
+	thO := float32(math.Acos(float64(cosThO)))
+	fit := 1 + thO*(-0.876+thO*(0.4265-0.0594*thO))
+	b := c - (1+c)*powFloat32(1-u, fit)
+
+	const invSqrtPi = 0.56418958354775628695
+	normalization := 1 /
+		(1 + c + invSqrtPi*tanThO*expFloat32(-cotThO*cotThO))
+
+	// Use Newton's method, falling back to bisection if it
+	// leaves the search range.
+	for i := 0; i < 10; i++ {
+		// This is written to also catch NaNs.
+		if !(b >= a && b <= c) {
+			b = 0.5 * (a + c)
+		}
+
+		invErfB := erfInvFloat32(b)
+		value := normalization*(1+b+invSqrtPi*tanThO*
+			expFloat32(-invErfB*invErfB)) - u
+		derivative := normalization * (1 - invErfB*tanThO)
+
+		if absFloat32(value) < 1e-5 {
+			break
+		}
+
+		if value > 0 {
+			c = b
+		} else {
+			a = b
+		}
+
+		b -= value / derivative
+	}
+
+	slopeX = erfInvFloat32(b)
+	slopeY = erfInvFloat32(2*maxFloat32(u2, 1e-6) - 1)
+	return
+}
+
+// Uses the method from Heitz and d'Eon's "Importance Sampling
+// Microfacet-Based BSDFs using the Distribution of Visible Normals",
+// which stretches wo to the configuration where alpha = 1, samples
+// slopes there, and then unstretches them.
+func (b *BeckmannMicrofacetDistribution) SampleVisibleWh(
+	wo *R3, u1, u2 float32) R3 {
+	woStretched := R3{b.alphaX * wo.X, b.alphaY * wo.Y, wo.Z}
+	woStretched.Normalize(&woStretched)
+
+	cos2Phi, sin2Phi := localCos2Sin2Phi(&woStretched)
+	cosPhi := sqrtFloat32(cos2Phi)
+	if woStretched.X < 0 {
+		cosPhi = -cosPhi
+	}
+	sinPhi := sqrtFloat32(sin2Phi)
+	if woStretched.Y < 0 {
+		sinPhi = -sinPhi
+	}
+
+	slopeX, slopeY := sampleBeckmannSlopes11(woStretched.Z, u1, u2)
+
+	// Rotate and unstretch.
+	slopeX, slopeY = cosPhi*slopeX-sinPhi*slopeY,
+		sinPhi*slopeX+cosPhi*slopeY
+	slopeX *= b.alphaX
+	slopeY *= b.alphaY
+
+	wh := R3{-slopeX, -slopeY, 1}
+	wh.Normalize(&wh)
+	return wh
+}
+
+func (b *BeckmannMicrofacetDistribution) CanSampleVisibleWh() bool {
+	return true
+}
+
+func (b *BeckmannMicrofacetDistribution) IsAnisotropic() bool {
+	return b.alphaX != b.alphaY
+}
+
+// The anisotropic GGX (Trowbridge-Reitz) distribution, used with the
+// Smith masking-shadowing function.
+type GGXMicrofacetDistribution struct {
+	alphaX, alphaY float32
+}
+
+func (g *GGXMicrofacetDistribution) ComputeD(wh *R3) float32 {
+	if wh.Z <= 0 {
+		return 0
+	}
+	tan2Th := localTan2Th(wh)
+	if !isFiniteFloat32(tan2Th) {
+		return 0
+	}
+	cos2Th := localCos2Th(wh)
+	cos2Phi, sin2Phi := localCos2Sin2Phi(wh)
+	ax2 := g.alphaX * g.alphaX
+	ay2 := g.alphaY * g.alphaY
+	e := 1 + tan2Th*(cos2Phi/ax2+sin2Phi/ay2)
+	return 1 / (math.Pi * g.alphaX * g.alphaY * cos2Th * cos2Th * e * e)
+}
+
+func (g *GGXMicrofacetDistribution) computeLambda(w *R3) float32 {
+	tan2Th := localTan2Th(w)
+	if !isFiniteFloat32(tan2Th) {
+		return 0
+	}
+	alpha := projectMicrofacetAlpha(w, g.alphaX, g.alphaY)
+	return 0.5 * (-1 + sqrtFloat32(1+alpha*alpha*tan2Th))
+}
+
+func (g *GGXMicrofacetDistribution) ComputeG(wo, wi, wh *R3) float32 {
+	return computeSmithG(wo, wi, wh, g.computeLambda)
+}
+
+func (g *GGXMicrofacetDistribution) ComputeG1(w, wh *R3) float32 {
+	return computeSmithG1(w, wh, g.computeLambda)
+}
+
+func (g *GGXMicrofacetDistribution) SampleWh(u1, u2 float32) R3 {
+	var tan2Th, phi float32
+	if g.alphaX == g.alphaY {
+		tan2Th = g.alphaX * g.alphaX * u1 / (1 - u1)
+		phi = 2 * math.Pi * u2
+	} else {
+		phi = sampleAnisotropicMicrofacetPhi(u2, g.alphaX, g.alphaY)
+		sinPhi, cosPhi := sincosFloat32(phi)
+		alpha2 := 1 / (cosPhi*cosPhi/(g.alphaX*g.alphaX) +
+			sinPhi*sinPhi/(g.alphaY*g.alphaY))
+		tan2Th = alpha2 * u1 / (1 - u1)
+	}
+	cosTh := 1 / sqrtFloat32(1+tan2Th)
+	return MakeSphericalDirection(cosTh, phi)
+}
+
+// Uses the method from Heitz's "Sampling the GGX Distribution of
+// Visible Normals", which samples the projection of a hemisphere.
+func (g *GGXMicrofacetDistribution) SampleVisibleWh(
+	wo *R3, u1, u2 float32) R3 {
+	// Transform wo to the hemisphere configuration.
+	vh := R3{g.alphaX * wo.X, g.alphaY * wo.Y, wo.Z}
+	vh.Normalize(&vh)
+
+	// Build an orthonormal basis around vh.
+	var t1 R3
+	lenSq := vh.X*vh.X + vh.Y*vh.Y
+	if lenSq > 0 {
+		t1 = R3{-vh.Y, vh.X, 0}
+		t1.ScaleInv(&t1, sqrtFloat32(lenSq))
+	} else {
+		t1 = R3{1, 0, 0}
+	}
+	var t2 R3
+	t2.CrossNoAlias(&vh, &t1)
+
+	// Sample the projected area of the hemisphere.
+	r := sqrtFloat32(u1)
+	sinPhi, cosPhi := sincosFloat32(2 * math.Pi * u2)
+	p1 := r * cosPhi
+	p2 := r * sinPhi
+	s := 0.5 * (1 + vh.Z)
+	p2 = (1-s)*sqrtFloat32(maxFloat32(0, 1-p1*p1)) + s*p2
+	p3 := sqrtFloat32(maxFloat32(0, 1-p1*p1-p2*p2))
+
+	// Reproject onto the hemisphere.
+	var nh R3
+	nh.ConvertToCoordinateSystemNoAlias(&R3{p1, p2, p3}, &t1, &t2, &vh)
+
+	// Transform back to the ellipsoid configuration.
+	wh := R3{g.alphaX * nh.X, g.alphaY * nh.Y, maxFloat32(0, nh.Z)}
+	wh.Normalize(&wh)
+	return wh
+}
+
+func (g *GGXMicrofacetDistribution) CanSampleVisibleWh() bool {
+	return true
+}
+
+func (g *GGXMicrofacetDistribution) IsAnisotropic() bool {
+	return g.alphaX != g.alphaY
+}
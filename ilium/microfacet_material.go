@@ -9,6 +9,7 @@ const (
 	MICROFACET_COSINE_SAMPLING              MicrofacetSamplingMethod = iota
 	MICROFACET_DISTRIBUTION_SAMPLING        MicrofacetSamplingMethod = iota
 	MICROFACET_DISTRIBUTION_COSINE_SAMPLING MicrofacetSamplingMethod = iota
+	MICROFACET_VISIBLE_NORMAL_SAMPLING      MicrofacetSamplingMethod = iota
 )
 
 const _MICROFACET_COS_THETA_EPSILON float32 = 1e-7
@@ -16,39 +17,151 @@ const _MICROFACET_COS_THETA_EPSILON float32 = 1e-7
 type MicrofacetMaterial struct {
 	samplingMethod MicrofacetSamplingMethod
 	rho            Spectrum
-	blinnExponent  float32
+	fresnel        Fresnel
+	distribution   MicrofacetDistribution
+	// dP/du at the intersection the material was evaluated at,
+	// if the distribution is anisotropic.
+	dpdu Vector3
+	// If non-nil, rho and distribution are given by these
+	// textures instead.
+	rhoTexture          SpectrumTexture
+	distributionTexture *microfacetDistributionTexture
+	// If non-nil, perturbs the shading normal.
+	normalPerturbation *shadingNormalPerturbation
 }
 
-func MakeMicrofacetMaterial(config map[string]interface{}) *MicrofacetMaterial {
-	var samplingMethod MicrofacetSamplingMethod
-	samplingMethodConfig := config["samplingMethod"].(string)
-	switch samplingMethodConfig {
+func MakeMicrofacetSamplingMethod(
+	samplingMethodString string) MicrofacetSamplingMethod {
+	switch samplingMethodString {
 	case "uniform":
-		samplingMethod = MICROFACET_UNIFORM_SAMPLING
+		return MICROFACET_UNIFORM_SAMPLING
 	case "cosine":
-		samplingMethod = MICROFACET_COSINE_SAMPLING
+		return MICROFACET_COSINE_SAMPLING
 	case "distribution":
-		samplingMethod = MICROFACET_DISTRIBUTION_SAMPLING
+		return MICROFACET_DISTRIBUTION_SAMPLING
 	case "distributionCosine":
-		samplingMethod = MICROFACET_DISTRIBUTION_COSINE_SAMPLING
+		return MICROFACET_DISTRIBUTION_COSINE_SAMPLING
+	case "visibleNormals":
+		return MICROFACET_VISIBLE_NORMAL_SAMPLING
 	default:
-		panic("unknown sampling method " + samplingMethodConfig)
+		panic("unknown sampling method " + samplingMethodString)
 	}
+}
+
+func MakeMicrofacetMaterial(config map[string]interface{}) *MicrofacetMaterial {
+	samplingMethod := MakeMicrofacetSamplingMethod(
+		config["samplingMethod"].(string))
 	rhoConfig := config["rho"].(map[string]interface{})
-	rho := MakeSpectrumFromConfig(rhoConfig)
-	blinnExponent := float32(config["blinnExponent"].(float64))
-	return &MicrofacetMaterial{samplingMethod, rho, blinnExponent}
+	rho, rhoTexture := makeSpectrumOrTextureFromConfig(rhoConfig)
+	var fresnel Fresnel = &NoOpFresnel{}
+	if fresnelConfig, ok := config["fresnel"]; ok {
+		fresnel = MakeFresnel(fresnelConfig.(map[string]interface{}))
+	}
+	distribution, distributionTexture :=
+		makeMicrofacetDistributionFromConfig(config)
+	// The sampling method depends only on the type of the
+	// distribution, so check it against any instance.
+	typeDistribution := distribution
+	if distributionTexture != nil {
+		typeDistribution = distributionTexture.Evaluate(0, 0)
+	}
+	switch samplingMethod {
+	case MICROFACET_DISTRIBUTION_SAMPLING:
+		if _, ok :=
+			typeDistribution.(*BlinnMicrofacetDistribution); !ok {
+			panic("distribution sampling is supported only " +
+				"for the Blinn distribution")
+		}
+	case MICROFACET_VISIBLE_NORMAL_SAMPLING:
+		if !typeDistribution.CanSampleVisibleWh() {
+			panic("visible normal sampling is not supported " +
+				"for this distribution")
+		}
+	}
+	normalPerturbation := makeShadingNormalPerturbationFromConfig(config)
+	return &MicrofacetMaterial{
+		samplingMethod, rho, fresnel, distribution, Vector3{},
+		rhoTexture, distributionTexture, normalPerturbation,
+	}
+}
+
+func (m *MicrofacetMaterial) ComputeShadingNormal(
+	intersection *Intersection) Normal3 {
+	if m.normalPerturbation == nil {
+		return intersection.ShadingN
+	}
+	return m.normalPerturbation.computeShadingNormal(intersection)
 }
 
-func (m *MicrofacetMaterial) computeG(
-	absCosThO, absCosThI, absCosThH, absWoDotWh float32) float32 {
-	return minFloat32(
-		1, 2*absCosThH*minFloat32(absCosThO, absCosThI)/absWoDotWh)
+func (m *MicrofacetMaterial) EvaluateAt(
+	intersection *Intersection) Material {
+	if m.rhoTexture == nil && m.distributionTexture == nil &&
+		!m.distribution.IsAnisotropic() {
+		return m
+	}
+	u := intersection.U
+	v := intersection.V
+	evaluated := *m
+	if m.rhoTexture != nil {
+		evaluated.rho = m.rhoTexture.Evaluate(u, v)
+		evaluated.rhoTexture = nil
+	}
+	if m.distributionTexture != nil {
+		evaluated.distribution = m.distributionTexture.Evaluate(u, v)
+		evaluated.distributionTexture = nil
+	}
+	if evaluated.distribution.IsAnisotropic() {
+		evaluated.dpdu = intersection.DPDU
+	}
+	return &evaluated
+}
+
+// Returns the coordinate system (i, j, k=n) in which microfacet
+// distributions are evaluated, with i along dP/du projected onto the
+// plane perpendicular to n, so that anisotropic distributions follow
+// the surface's parametrization. If that projection is degenerate
+// (e.g., dpdu is zero for isotropic distributions), i and j are an
+// arbitrary but consistent choice instead.
+func makeMicrofacetFrame(n Normal3, dpdu *Vector3) (i, j, k R3) {
+	k = R3(n)
+	var parallel R3
+	r := R3(*dpdu)
+	parallel.Scale(&k, r.Dot(&k))
+	i.Sub(&r, &parallel)
+	if i.NormSq() == 0 {
+		MakeCoordinateSystemNoAlias(&k, &i, &j)
+		return
+	}
+	i.Normalize(&i)
+	j.CrossNoAlias(&k, &i)
+	return
 }
 
-func (m *MicrofacetMaterial) computeBlinnD(absCosThH float32) float32 {
-	e := m.blinnExponent
-	return (e + 2) * powFloat32(absCosThH, e) / (2 * math.Pi)
+func convertToMicrofacetFrame(w *Vector3, i, j, k *R3) R3 {
+	r := R3(*w)
+	return R3{r.Dot(i), r.Dot(j), r.Dot(k)}
+}
+
+// Returns the pdf of sampling wh with the given method with respect
+// to solid angle, given that wo was fixed. Both are in the local
+// frame.
+func computeMicrofacetWhPdf(samplingMethod MicrofacetSamplingMethod,
+	distribution MicrofacetDistribution, vo, vh *R3) float32 {
+	switch samplingMethod {
+	case MICROFACET_UNIFORM_SAMPLING:
+		return 1 / (2 * math.Pi)
+	case MICROFACET_COSINE_SAMPLING:
+		return vh.Z / math.Pi
+	case MICROFACET_DISTRIBUTION_SAMPLING:
+		blinn := distribution.(*BlinnMicrofacetDistribution)
+		return blinn.computePdfWithoutCosine(vh)
+	case MICROFACET_DISTRIBUTION_COSINE_SAMPLING:
+		return distribution.ComputeD(vh) * vh.Z
+	case MICROFACET_VISIBLE_NORMAL_SAMPLING:
+		G1 := distribution.ComputeG1(vo, vh)
+		return G1 * vo.Dot(vh) * distribution.ComputeD(vh) / vo.Z
+	}
+	panic("unexpectedly reached")
 }
 
 func (m *MicrofacetMaterial) SampleWi(transportType MaterialTransportType,
@@ -60,6 +173,9 @@ func (m *MicrofacetMaterial) SampleWi(transportType MaterialTransportType,
 	}
 	absCosThO := cosThO
 
+	i, j, k := makeMicrofacetFrame(n, &m.dpdu)
+	vo := convertToMicrofacetFrame(&wo, &i, &j, &k)
+
 	var vh R3
 	switch m.samplingMethod {
 	case MICROFACET_UNIFORM_SAMPLING:
@@ -67,20 +183,16 @@ func (m *MicrofacetMaterial) SampleWi(transportType MaterialTransportType,
 	case MICROFACET_COSINE_SAMPLING:
 		vh = cosineSampleHemisphere(u1, u2)
 	case MICROFACET_DISTRIBUTION_SAMPLING:
-		absCosThH := powFloat32(u1, 1/(m.blinnExponent+1))
-		phiH := 2 * math.Pi * u2
-		vh = MakeSphericalDirection(absCosThH, phiH)
+		blinn := m.distribution.(*BlinnMicrofacetDistribution)
+		vh = blinn.sampleWhWithoutCosine(u1, u2)
 	case MICROFACET_DISTRIBUTION_COSINE_SAMPLING:
-		absCosThH := powFloat32(u1, 1/(m.blinnExponent+2))
-		phiH := 2 * math.Pi * u2
-		vh = MakeSphericalDirection(absCosThH, phiH)
+		vh = m.distribution.SampleWh(u1, u2)
+	case MICROFACET_VISIBLE_NORMAL_SAMPLING:
+		vh = m.distribution.SampleVisibleWh(&vo, u1, u2)
 	}
 	absCosThH := vh.Z
 
 	// Convert the sampled vector to be around (i, j, k=n).
-	k := R3(n)
-	var i, j R3
-	MakeCoordinateSystemNoAlias(&k, &i, &j)
 	var vhW R3
 	vhW.ConvertToCoordinateSystemNoAlias(&vh, &i, &j, &k)
 	wh := Vector3(vhW)
@@ -100,43 +212,51 @@ func (m *MicrofacetMaterial) SampleWi(transportType MaterialTransportType,
 		return
 	}
 	absCosThI := cosThI
+	vi := convertToMicrofacetFrame(&wi, &i, &j, &k)
+
+	// The pdf of wi with respect to projected solid angle is
+	// pdf(w_h) / (|cos(th_i)| * 4 * |w_o * w_h|).
+	pdfWh := computeMicrofacetWhPdf(
+		m.samplingMethod, m.distribution, &vo, &vh)
+	pdf = pdfWh / (4 * absCosThI * absWoDotWh)
+	if pdf == 0 {
+		wi = Vector3{}
+		return
+	}
+
+	// rhoF = rho * F(w_o * w_h).
+	F := m.fresnel.ComputeReflectance(absWoDotWh)
+	var rhoF Spectrum
+	rhoF.Mul(&m.rho, &F)
 
 	switch m.samplingMethod {
-	case MICROFACET_UNIFORM_SAMPLING:
+	case MICROFACET_UNIFORM_SAMPLING, MICROFACET_COSINE_SAMPLING:
 		f := m.ComputeF(transportType, wo, wi, n)
-		// pdf = 1 / (2 * pi * |cos(th_i)| * 4 * |w_o * w_h|).
-		fDivPdf.Scale(&f, 8*math.Pi*absCosThI*absWoDotWh)
-		pdf = 1 / (8 * math.Pi * absCosThI * absWoDotWh)
-	case MICROFACET_COSINE_SAMPLING:
-		f := m.ComputeF(transportType, wo, wi, n)
-		// pdf = |cos(th_h)| / (pi * |cos(th_i)| * 4 * |w_o * w_h|).
-		fDivPdf.Scale(&f, 4*math.Pi*absCosThI*absWoDotWh/absCosThH)
-		pdf = absCosThH / (4 * math.Pi * absCosThI * absWoDotWh)
+		fDivPdf.ScaleInv(&f, pdf)
 	case MICROFACET_DISTRIBUTION_SAMPLING:
-		e := m.blinnExponent
-		G := m.computeG(absCosThO, absCosThI, absCosThH, absWoDotWh)
-		// f = (rho * D_blinn * G) / (4 * |cos(th_o) * cos(th_i)|),
-		// and pdf = ((e + 1) * |cos^e(th_h)|) /
-		//   (2 * pi * |cos(th_i)| * 4 * |w_o * w_h|) =
-		// ((e + 1) * D_blinn) /
+		e := m.distribution.(*BlinnMicrofacetDistribution).exponent
+		G := m.distribution.ComputeG(&vo, &vi, &vh)
+		// f = (rho * F * D_blinn * G) / (4 * |cos(th_o) * cos(th_i)|),
+		// and pdf = ((e + 1) * D_blinn) /
 		//   ((e + 2) * |cos(th_i)| * 4 * |w_o * w_h|), so
-		// f / pdf = (rho * (e + 2) * G * |w_o * w_h|) /
+		// f / pdf = (rho * F * (e + 2) * G * |w_o * w_h|) /
 		//   ((e + 1) * |cos(th_o)|).
-		fDivPdf.Scale(&m.rho, ((e+2)*G*absWoDotWh)/((e+1)*absCosThO))
-		blinnD := m.computeBlinnD(absCosThH)
-		pdf = ((e + 1) * blinnD) /
-			(4 * (e + 2) * absCosThI * absWoDotWh)
+		fDivPdf.Scale(&rhoF, ((e+2)*G*absWoDotWh)/((e+1)*absCosThO))
 	case MICROFACET_DISTRIBUTION_COSINE_SAMPLING:
-		G := m.computeG(absCosThO, absCosThI, absCosThH, absWoDotWh)
-		// f = (rho * D_blinn * G) / (4 * |cos(th_o) * cos(th_i)|),
-		// and pdf = ((e + 2) * |cos^(e+1)(th_h)|) /
-		//   (2 * pi * |cos(th_i)| * 4 * |w_o * w_h|) =
-		// (D_blinn * |cos(th_h)|) / (|cos(th_i)| * 4 * |w_o * w_h|),
-		// so f / pdf =
-		//   (rho * G * |w_o * w_h|) / |cos(th_h) * cos(th_o)|.
-		fDivPdf.Scale(&m.rho, (G*absWoDotWh)/(absCosThH*absCosThO))
-		blinnD := m.computeBlinnD(absCosThH)
-		pdf = (blinnD * absCosThH) / (4 * absCosThI * absWoDotWh)
+		G := m.distribution.ComputeG(&vo, &vi, &vh)
+		// f = (rho * F * D * G) / (4 * |cos(th_o) * cos(th_i)|),
+		// and pdf = (D * |cos(th_h)|) /
+		//   (|cos(th_i)| * 4 * |w_o * w_h|), so f / pdf =
+		//   (rho * F * G * |w_o * w_h|) / |cos(th_h) * cos(th_o)|.
+		fDivPdf.Scale(&rhoF, (G*absWoDotWh)/(absCosThH*absCosThO))
+	case MICROFACET_VISIBLE_NORMAL_SAMPLING:
+		G := m.distribution.ComputeG(&vo, &vi, &vh)
+		G1 := m.distribution.ComputeG1(&vo, &vh)
+		// f = (rho * F * D * G) / (4 * |cos(th_o) * cos(th_i)|),
+		// and pdf = (G1(w_o) * D) /
+		//   (4 * |cos(th_o)| * |cos(th_i)|), so
+		// f / pdf = (rho * F * G) / G1(w_o).
+		fDivPdf.Scale(&rhoF, G/G1)
 	}
 	return
 }
@@ -166,17 +286,19 @@ func (m *MicrofacetMaterial) ComputeF(transportType MaterialTransportType,
 	}
 	absWoDotWh := woDotWh
 
-	// Assume perfect reflection for now (i.e., a Fresnel term of 1).
-	//
-	// TODO(akalin): Implement a real Fresnel term and refraction.
-	cosThH := wh.DotNormal(&n)
+	// TODO(akalin): Implement refraction.
+	i, j, k := makeMicrofacetFrame(n, &m.dpdu)
+	vo := convertToMicrofacetFrame(&wo, &i, &j, &k)
+	vi := convertToMicrofacetFrame(&wi, &i, &j, &k)
 	// By construction, wh is always in the same hemisphere as wo
 	// (with respect to n).
-	absCosThH := cosThH
-	blinnD := m.computeBlinnD(absCosThH)
-	G := m.computeG(absCosThO, absCosThI, absCosThH, absWoDotWh)
+	vh := convertToMicrofacetFrame(&wh, &i, &j, &k)
+	D := m.distribution.ComputeD(&vh)
+	G := m.distribution.ComputeG(&vo, &vi, &vh)
+	F := m.fresnel.ComputeReflectance(absWoDotWh)
 	var f Spectrum
-	f.Scale(&m.rho, (blinnD*G)/(4*absCosThO*absCosThI))
+	f.Mul(&m.rho, &F)
+	f.Scale(&f, (D*G)/(4*absCosThO*absCosThI))
 	return f
 }
 
@@ -204,22 +326,14 @@ func (m *MicrofacetMaterial) ComputePdf(transportType MaterialTransportType,
 	}
 	absWoDotWh := woDotWh
 
-	switch m.samplingMethod {
-	case MICROFACET_UNIFORM_SAMPLING:
-		return 1 / (8 * math.Pi * absCosThI * absWoDotWh)
-	case MICROFACET_COSINE_SAMPLING:
-		absCosThH := absFloat32(wh.DotNormal(&n))
-		return absCosThH / (4 * math.Pi * absCosThI * absWoDotWh)
-	case MICROFACET_DISTRIBUTION_SAMPLING:
-		absCosThH := absFloat32(wh.DotNormal(&n))
-		e := m.blinnExponent
-		blinnD := m.computeBlinnD(absCosThH)
-		return ((e + 1) * blinnD) /
-			(4 * (e + 2) * absCosThI * absWoDotWh)
-	case MICROFACET_DISTRIBUTION_COSINE_SAMPLING:
-		absCosThH := absFloat32(wh.DotNormal(&n))
-		blinnD := m.computeBlinnD(absCosThH)
-		return (blinnD * absCosThH) / (4 * absCosThI * absWoDotWh)
-	}
-	panic("unexpectedly reached")
+	i, j, k := makeMicrofacetFrame(n, &m.dpdu)
+	vo := convertToMicrofacetFrame(&wo, &i, &j, &k)
+	vh := convertToMicrofacetFrame(&wh, &i, &j, &k)
+	pdfWh := computeMicrofacetWhPdf(
+		m.samplingMethod, m.distribution, &vo, &vh)
+	return pdfWh / (4 * absCosThI * absWoDotWh)
+}
+
+func (m *MicrofacetMaterial) IsSpecular() bool {
+	return false
 }
@@ -0,0 +1,144 @@
+package ilium
+
+// A MixMaterial is a blend of two materials, with BSDF (1 - weight)
+// * f1 + weight * f2, where the weight is in [0, 1] and may be given
+// by a texture, e.g. to add dust or wear to another material.
+//
+// Directions are sampled by picking one of the materials with
+// probability equal to its weight, so the pdf is the same mixture of
+// the pdfs of the materials. The materials must either both be
+// specular or both be non-specular, although either may have a
+// specular component (see PartiallySpecularMaterial).
+type MixMaterial struct {
+	material1 Material
+	material2 Material
+	weight    float32
+	// If non-nil, weight is given by this texture instead.
+	weightTexture SpectrumTexture
+}
+
+func MakeMixMaterial(config map[string]interface{}) *MixMaterial {
+	materialsConfig := config["materials"].([]interface{})
+	if len(materialsConfig) != 2 {
+		panic("exactly two materials must be given")
+	}
+	material1 := MakeMaterial(
+		materialsConfig[0].(map[string]interface{}))
+	material2 := MakeMaterial(
+		materialsConfig[1].(map[string]interface{}))
+	if material1.IsSpecular() != material2.IsSpecular() {
+		panic("specular and non-specular materials can't be mixed")
+	}
+	weight, weightTexture := makeFloatOrTextureFromConfig(config["weight"])
+	if weightTexture == nil && (weight < 0 || weight > 1) {
+		panic("weight must be in [0, 1]")
+	}
+	return &MixMaterial{material1, material2, weight, weightTexture}
+}
+
+// Returns the given material evaluated at the given intersection,
+// wrapped to use its perturbed shading normal, if any, with respect
+// to the unperturbed one (which is what the MixMaterial itself is
+// evaluated with).
+func evaluateMixedMaterial(
+	material Material, intersection *Intersection) Material {
+	nShading := intersection.ShadingN
+	if normalPerturbingMaterial, ok :=
+		material.(NormalPerturbingMaterial); ok {
+		nShading = normalPerturbingMaterial.ComputeShadingNormal(
+			intersection)
+	}
+	if varyingMaterial, ok := material.(VaryingMaterial); ok {
+		material = varyingMaterial.EvaluateAt(intersection)
+	}
+	if nShading != intersection.ShadingN {
+		material = &shadingNormalMaterial{material, nShading}
+	}
+	return material
+}
+
+func (m *MixMaterial) EvaluateAt(intersection *Intersection) Material {
+	material1 := evaluateMixedMaterial(m.material1, intersection)
+	material2 := evaluateMixedMaterial(m.material2, intersection)
+	weight := m.weight
+	if m.weightTexture != nil {
+		weight = evaluateFloatTexture(
+			m.weightTexture, intersection.U, intersection.V)
+		weight = minFloat32(maxFloat32(weight, 0), 1)
+	} else if material1 == m.material1 && material2 == m.material2 {
+		return m
+	}
+	return &MixMaterial{material1, material2, weight, nil}
+}
+
+func (m *MixMaterial) SampleWi(transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32) {
+	wi, fDivPdf, pdf, _ = m.SampleWiWithSpecular(
+		transportType, u1, u2, wo, n)
+	return
+}
+
+func (m *MixMaterial) SampleWiWithSpecular(
+	transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32, isSpecular bool) {
+	// Pick a material, and then reuse u1 to sample wi.
+	var material Material
+	var pChoose float32
+	if u1 < m.weight {
+		material = m.material2
+		pChoose = m.weight
+		u1 /= m.weight
+	} else {
+		material = m.material1
+		pChoose = 1 - m.weight
+		u1 = (u1 - m.weight) / (1 - m.weight)
+	}
+	u1 = minFloat32(u1, _ONE_MINUS_EPSILON)
+
+	wi, fDivPdf, pdf, isSpecular = sampleMaterialWi(
+		material, transportType, u1, u2, wo, n)
+	if pdf == 0 {
+		return
+	}
+
+	if isSpecular {
+		// Ignoring the (unlikely) case of both materials
+		// having the same specular direction, the weight and
+		// the probability of picking the material cancel
+		// out.
+		pdf *= pChoose
+		return
+	}
+
+	f := m.ComputeF(transportType, wo, wi, n)
+	pdf = m.ComputePdf(transportType, wo, wi, n)
+	if f.IsBlack() || pdf == 0 {
+		return Vector3{}, Spectrum{}, 0, false
+	}
+	fDivPdf.ScaleInv(&f, pdf)
+	return
+}
+
+func (m *MixMaterial) ComputeF(transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) Spectrum {
+	f1 := m.material1.ComputeF(transportType, wo, wi, n)
+	f2 := m.material2.ComputeF(transportType, wo, wi, n)
+	var f Spectrum
+	f1.Scale(&f1, 1-m.weight)
+	f2.Scale(&f2, m.weight)
+	f.Add(&f1, &f2)
+	return f
+}
+
+func (m *MixMaterial) ComputePdf(transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) float32 {
+	pdf1 := m.material1.ComputePdf(transportType, wo, wi, n)
+	pdf2 := m.material2.ComputePdf(transportType, wo, wi, n)
+	return (1-m.weight)*pdf1 + m.weight*pdf2
+}
+
+func (m *MixMaterial) IsSpecular() bool {
+	return m.material1.IsSpecular()
+}
@@ -6,6 +6,17 @@ func MakeNormal3FromConfig(config interface{}) Normal3 {
 	return Normal3(MakeR3FromConfig(config))
 }
 
+func MakeNormal3sFromConfig(config interface{}) []Normal3 {
+	arrayConfig := config.([]interface{})
+	normals := []Normal3{}
+	for i := 0; i < len(arrayConfig); i += 3 {
+		normals = append(
+			normals,
+			Normal3(MakeR3FromConfig(arrayConfig[i:i+3])))
+	}
+	return normals
+}
+
 func (out *Normal3) Flip(n *Normal3) {
 	((*R3)(out)).Invert((*R3)(n))
 }
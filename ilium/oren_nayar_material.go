@@ -0,0 +1,131 @@
+package ilium
+
+import "math"
+
+// An OrenNayarMaterial is a rough diffuse surface, e.g. clay or
+// concrete, modeled with the qualitative BRDF from Oren and Nayar's
+// "Generalization of Lambert's Reflectance Model". Its roughness
+// sigma is the standard deviation (in degrees) of the angle of the
+// microfacets from the normal; a sigma of 0 gives a Lambertian
+// surface.
+//
+// The BRDF is symmetric, so it's the same for both transport types.
+// Directions are sampled with a cosine-weighted distribution.
+type OrenNayarMaterial struct {
+	rho Spectrum
+	// If non-nil, rho is given by this texture instead.
+	rhoTexture SpectrumTexture
+	// The A and B coefficients computed from sigma.
+	a, b float32
+	// If non-nil, perturbs the shading normal.
+	normalPerturbation *shadingNormalPerturbation
+}
+
+func MakeOrenNayarMaterial(config map[string]interface{}) *OrenNayarMaterial {
+	rhoConfig := config["rho"].(map[string]interface{})
+	rho, rhoTexture := makeSpectrumOrTextureFromConfig(rhoConfig)
+	sigmaDegrees := float32(config["sigma"].(float64))
+	if sigmaDegrees < 0 {
+		panic("sigma must be non-negative")
+	}
+	sigma := sigmaDegrees * (math.Pi / 180)
+	sigma2 := sigma * sigma
+	a := 1 - sigma2/(2*(sigma2+0.33))
+	b := 0.45 * sigma2 / (sigma2 + 0.09)
+	normalPerturbation := makeShadingNormalPerturbationFromConfig(config)
+	return &OrenNayarMaterial{rho, rhoTexture, a, b, normalPerturbation}
+}
+
+func (o *OrenNayarMaterial) EvaluateAt(
+	intersection *Intersection) Material {
+	if o.rhoTexture == nil {
+		return o
+	}
+	evaluated := *o
+	evaluated.rho = o.rhoTexture.Evaluate(intersection.U, intersection.V)
+	evaluated.rhoTexture = nil
+	return &evaluated
+}
+
+func (o *OrenNayarMaterial) ComputeShadingNormal(
+	intersection *Intersection) Normal3 {
+	if o.normalPerturbation == nil {
+		return intersection.ShadingN
+	}
+	return o.normalPerturbation.computeShadingNormal(intersection)
+}
+
+// Returns f / (rho / pi), assuming that wo and wi are both on the
+// side of n.
+func (o *OrenNayarMaterial) computeScale(wo, wi *Vector3, n *Normal3) float32 {
+	cosThO := wo.DotNormal(n)
+	cosThI := wi.DotNormal(n)
+	sinThO := cosToSin(cosThO)
+	sinThI := cosToSin(cosThI)
+
+	// cos(phi_i - phi_o) is the cosine of the angle between the
+	// projections of wi and wo onto the plane perpendicular to
+	// n.
+	var maxCos float32
+	if sinThI > 1e-4 && sinThO > 1e-4 {
+		cosDPhi := (wi.Dot(wo) - cosThI*cosThO) / (sinThI * sinThO)
+		maxCos = maxFloat32(0, cosDPhi)
+	}
+
+	// alpha = max(th_i, th_o) and beta = min(th_i, th_o).
+	var sinAlpha, tanBeta float32
+	if cosThI > cosThO {
+		sinAlpha = sinThO
+		tanBeta = sinThI / cosThI
+	} else {
+		sinAlpha = sinThI
+		tanBeta = sinThO / cosThO
+	}
+	return o.a + o.b*maxCos*sinAlpha*tanBeta
+}
+
+func (o *OrenNayarMaterial) SampleWi(transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32) {
+	if wo.DotNormal(&n) <= 0 {
+		return
+	}
+	r3 := cosineSampleHemisphere(u1, u2)
+	if r3.Z <= 0 {
+		return
+	}
+	// Convert the sampled vector to be around (i, j, k=n).
+	k := R3(n)
+	var i, j R3
+	MakeCoordinateSystemNoAlias(&k, &i, &j)
+	var r3w R3
+	r3w.ConvertToCoordinateSystemNoAlias(&r3, &i, &j, &k)
+	wi = Vector3(r3w)
+	// f = (rho / pi) * scale and pdf = 1 / pi, so f / pdf = rho *
+	// scale.
+	fDivPdf.Scale(&o.rho, o.computeScale(&wo, &wi, &n))
+	pdf = cosineHemispherePdfProjectedSolidAngle()
+	return
+}
+
+func (o *OrenNayarMaterial) ComputeF(transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) Spectrum {
+	if wo.DotNormal(&n) <= 0 || wi.DotNormal(&n) <= 0 {
+		return Spectrum{}
+	}
+	var f Spectrum
+	f.Scale(&o.rho, o.computeScale(&wo, &wi, &n)/math.Pi)
+	return f
+}
+
+func (o *OrenNayarMaterial) ComputePdf(transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) float32 {
+	if wo.DotNormal(&n) <= 0 || wi.DotNormal(&n) <= 0 {
+		return 0
+	}
+	return cosineHemispherePdfProjectedSolidAngle()
+}
+
+func (o *OrenNayarMaterial) IsSpecular() bool {
+	return false
+}
@@ -0,0 +1,154 @@
+package ilium
+
+import "math"
+import "math/rand"
+import "testing"
+
+func makeTestOrenNayarMaterial(rho, sigma float64) *OrenNayarMaterial {
+	return MakeOrenNayarMaterial(map[string]interface{}{
+		"rho": map[string]interface{}{
+			"type": "rgb", "r": rho, "g": rho, "b": rho,
+		},
+		"sigma": sigma,
+	})
+}
+
+// Returns the direction with the given polar angle from (0, 0, 1) and
+// the given azimuth.
+func makeTestDirection(th, phi float64) Vector3 {
+	return Vector3{
+		float32(math.Sin(th) * math.Cos(phi)),
+		float32(math.Sin(th) * math.Sin(phi)),
+		float32(math.Cos(th)),
+	}
+}
+
+// Integrates f * cos(th_i) over the hemisphere around (0, 0, 1) with
+// the midpoint rule, i.e. returns the directional albedo of the
+// given material for wo.
+func integrateTestAlbedo(
+	material Material, transportType MaterialTransportType,
+	wo Vector3) float64 {
+	const thSteps = 256
+	const phiSteps = 512
+	n := Normal3{0, 0, 1}
+	dTh := (math.Pi / 2) / thSteps
+	dPhi := (2 * math.Pi) / phiSteps
+	var albedo float64
+	for i := 0; i < thSteps; i++ {
+		th := (float64(i) + 0.5) * dTh
+		for j := 0; j < phiSteps; j++ {
+			phi := (float64(j) + 0.5) * dPhi
+			wi := makeTestDirection(th, phi)
+			f := material.ComputeF(transportType, wo, wi, n)
+			albedo += float64(f.r) * math.Cos(th) * math.Sin(th) *
+				dTh * dPhi
+		}
+	}
+	return albedo
+}
+
+// Returns the directional albedo of the Oren-Nayar BRDF for wo at
+// polar angle thO, which is rho * (A + (2 * B / pi) * (sin(th_o) *
+// (th_o / 2 - sin(2 * th_o) / 4) + tan(th_o) * (1 - sin^3(th_o)) /
+// 3)).
+func computeTestOrenNayarAlbedo(rho, a, b, thO float64) float64 {
+	sinThO := math.Sin(thO)
+	inner := sinThO * (thO/2 - math.Sin(2*thO)/4)
+	outer := math.Tan(thO) * (1 - sinThO*sinThO*sinThO) / 3
+	return rho * (a + (2*b/math.Pi)*(inner+outer))
+}
+
+func TestOrenNayarLambertianAlbedo(t *testing.T) {
+	const rho = 0.6
+	material := makeTestOrenNayarMaterial(rho, 0)
+	for _, thO := range []float64{0, 0.5, 1, 1.4} {
+		wo := makeTestDirection(thO, 0.3)
+		albedo := integrateTestAlbedo(
+			material, MATERIAL_LIGHT_TRANSPORT, wo)
+		if math.Abs(albedo-rho) > 1e-3 {
+			t.Errorf("th_o=%f: albedo=%f, expected %f",
+				thO, albedo, rho)
+		}
+	}
+}
+
+func TestOrenNayarAlbedo(t *testing.T) {
+	const rho = 0.8
+	for _, sigma := range []float64{10, 20, 40} {
+		material := makeTestOrenNayarMaterial(rho, sigma)
+		for _, thO := range []float64{0, 0.5, 1, 1.4} {
+			wo := makeTestDirection(thO, 1.2)
+			albedo := integrateTestAlbedo(
+				material, MATERIAL_LIGHT_TRANSPORT, wo)
+			expectedAlbedo := computeTestOrenNayarAlbedo(
+				rho, float64(material.a), float64(material.b),
+				thO)
+			if math.Abs(albedo-expectedAlbedo) > 2e-3 {
+				t.Errorf("sigma=%f, th_o=%f: albedo=%f, "+
+					"expected %f", sigma, thO,
+					albedo, expectedAlbedo)
+			}
+		}
+	}
+}
+
+func TestOrenNayarSymmetry(t *testing.T) {
+	material := makeTestOrenNayarMaterial(0.5, 30)
+	rng := rand.New(rand.NewSource(1))
+	n := Normal3{0, 0, 1}
+	for i := 0; i < 1000; i++ {
+		wo := makeTestDirection(
+			rng.Float64()*math.Pi/2, rng.Float64()*2*math.Pi)
+		wi := makeTestDirection(
+			rng.Float64()*math.Pi/2, rng.Float64()*2*math.Pi)
+		f := material.ComputeF(MATERIAL_LIGHT_TRANSPORT, wo, wi, n)
+		fSwapped := material.ComputeF(
+			MATERIAL_LIGHT_TRANSPORT, wi, wo, n)
+		fImportance := material.ComputeF(
+			MATERIAL_IMPORTANCE_TRANSPORT, wo, wi, n)
+		if math.Abs(float64(f.r-fSwapped.r)) > 1e-5 {
+			t.Errorf("wo=%v, wi=%v: f=%f != f swapped=%f",
+				wo, wi, f.r, fSwapped.r)
+		}
+		if f != fImportance {
+			t.Errorf("wo=%v, wi=%v: f=%v != f importance=%v",
+				wo, wi, f, fImportance)
+		}
+	}
+}
+
+func TestOrenNayarSampleWi(t *testing.T) {
+	material := makeTestOrenNayarMaterial(0.5, 30)
+	rng := rand.New(rand.NewSource(1))
+	n := Normal3{0, 0, 1}
+	for _, transportType := range []MaterialTransportType{
+		MATERIAL_LIGHT_TRANSPORT, MATERIAL_IMPORTANCE_TRANSPORT,
+	} {
+		for i := 0; i < 1000; i++ {
+			wo := makeTestDirection(
+				rng.Float64()*math.Pi/2,
+				rng.Float64()*2*math.Pi)
+			wi, fDivPdf, pdf := material.SampleWi(
+				transportType, rng.Float32(), rng.Float32(),
+				wo, n)
+			if pdf == 0 {
+				continue
+			}
+			expectedPdf := material.ComputePdf(
+				transportType, wo, wi, n)
+			if math.Abs(float64(pdf-expectedPdf)) > 1e-5 {
+				t.Errorf("wo=%v, wi=%v: pdf=%f, expected %f",
+					wo, wi, pdf, expectedPdf)
+			}
+			f := material.ComputeF(transportType, wo, wi, n)
+			var expectedFDivPdf Spectrum
+			expectedFDivPdf.ScaleInv(&f, expectedPdf)
+			if math.Abs(float64(
+				fDivPdf.r-expectedFDivPdf.r)) > 1e-5 {
+				t.Errorf("wo=%v, wi=%v: f/pdf=%f, expected %f",
+					wo, wi, fDivPdf.r, expectedFDivPdf.r)
+			}
+		}
+	}
+}
@@ -170,29 +170,40 @@ func (pt *ParticleTracer) makeWWeAlphaDebugRecords(
 	return debugRecords
 }
 
-func (pt *ParticleTracer) hasBackwardsPath(edgeCount int, sensor Sensor) bool {
+func (pt *ParticleTracer) hasBackwardsPath(edgeCount int, sensor Sensor,
+	light Light, specularVertices TracerSpecularVertices) bool {
 	return pt.pathTypes.HasAlternatePath(
-		TRACER_EMITTED_LIGHT_PATH, edgeCount, sensor) ||
+		TRACER_EMITTED_LIGHT_PATH, edgeCount, sensor,
+		light, specularVertices) ||
 		pt.pathTypes.HasAlternatePath(
-			TRACER_DIRECT_LIGHTING_PATH, edgeCount, sensor)
+			TRACER_DIRECT_LIGHTING_PATH, edgeCount, sensor,
+			light, specularVertices)
 }
 
+// Adds the pdfs of the alternate paths that sample the vertex
+// before the next one from it. These go through the next vertex's
+// BSDF with the directions swapped and with light transport, which
+// matters for BSDFs whose pdfs aren't symmetric (e.g., rough
+// dielectrics).
 func (pt *ParticleTracer) addVertexQs(
 	weightTracker *TracerWeightTracker, qVertexIndex, edgeCount int,
 	sensor Sensor, light Light, pNext Point3, pEpsilonNext float32,
 	nNext Normal3, woNext, wiNext Vector3, materialNext Material,
-	pChooseLight float32) {
+	isSpecularNext bool, scene *Scene,
+	specularVertices TracerSpecularVertices) {
 	var effectiveRussianRouletteState *RussianRouletteState
 	if pt.shouldIncludeRR() {
 		effectiveRussianRouletteState = pt.russianRouletteState
 	}
 	if qVertexIndex == 0 {
 		if pt.pathTypes.HasAlternatePath(
-			TRACER_EMITTED_LIGHT_PATH, edgeCount, sensor) {
+			TRACER_EMITTED_LIGHT_PATH, edgeCount, sensor,
+			light, specularVertices) {
 			pdf := ComputePdfForWeight(
 				pt.weighingMethod,
 				effectiveRussianRouletteState,
-				materialNext, MATERIAL_LIGHT_TRANSPORT,
+				materialNext, isSpecularNext,
+				MATERIAL_LIGHT_TRANSPORT,
 				wiNext, woNext, nNext)
 
 			// One for the direction to the light from
@@ -201,22 +212,26 @@ func (pt *ParticleTracer) addVertexQs(
 		}
 
 		if pt.pathTypes.HasAlternatePath(
-			TRACER_DIRECT_LIGHTING_PATH, edgeCount, sensor) {
+			TRACER_DIRECT_LIGHTING_PATH, edgeCount, sensor,
+			light, specularVertices) {
 			// One for direct sampling the light from
 			// vertex 1.
 			switch pt.weighingMethod {
 			case TRACER_UNIFORM_WEIGHTS:
 				weightTracker.AddQ(0, 1)
 			case TRACER_POWER_WEIGHTS:
+				pChooseLight := scene.ComputeLightPdfFromPoint(
+					light, pNext, nNext)
 				pdfDirect := light.ComputeLePdfFromPoint(
 					pNext, pEpsilonNext, nNext, woNext)
 				weightTracker.AddQ(0, pChooseLight*pdfDirect)
 			}
 		}
-	} else if pt.hasBackwardsPath(edgeCount, sensor) {
+	} else if pt.hasBackwardsPath(edgeCount, sensor, light, specularVertices) {
 		pdf := ComputePdfForWeight(
 			pt.weighingMethod, effectiveRussianRouletteState,
-			materialNext, MATERIAL_LIGHT_TRANSPORT,
+			materialNext, isSpecularNext,
+			MATERIAL_LIGHT_TRANSPORT,
 			wiNext, woNext, nNext)
 		// One for the direction to this vertex from the next
 		// vertex.
@@ -226,9 +241,10 @@ func (pt *ParticleTracer) addVertexQs(
 
 func (pt *ParticleTracer) addSensorDirectionalQs(
 	weightTracker *TracerWeightTracker, qVertexIndex, edgeCount int,
-	sensor Sensor, x, y int, wiPrev Vector3, pSurface Point3,
-	nSurface Normal3) {
-	if pt.hasBackwardsPath(edgeCount, sensor) {
+	sensor Sensor, light Light, x, y int, wiPrev Vector3,
+	pSurface Point3, nSurface Normal3,
+	specularVertices TracerSpecularVertices) {
+	if pt.hasBackwardsPath(edgeCount, sensor, light, specularVertices) {
 		// One for the direction to this vertex from the
 		// sensor.
 		switch pt.weighingMethod {
@@ -249,8 +265,9 @@ func (pt *ParticleTracer) addSensorDirectionalQs(
 
 func (pt *ParticleTracer) addSensorSpatialQs(
 	weightTracker *TracerWeightTracker, qVertexIndex, edgeCount int,
-	sensor Sensor, pSurface Point3) {
-	if pt.hasBackwardsPath(edgeCount, sensor) {
+	sensor Sensor, light Light, pSurface Point3,
+	specularVertices TracerSpecularVertices) {
+	if pt.hasBackwardsPath(edgeCount, sensor, light, specularVertices) {
 		// One for the point on the sensor and picking the
 		// sensor pixel.
 		switch pt.weighingMethod {
@@ -267,9 +284,11 @@ func (pt *ParticleTracer) computeEmittedImportanceWeight(
 	sensorWeightTracker *TracerWeightTracker,
 	edgeCount int, sensor Sensor, x, y int, light Light,
 	pPrev Point3, pEpsilonPrev float32, nPrev Normal3, wiPrev, wo Vector3,
-	intersection *Intersection, pChooseLight float32) float32 {
+	intersection *Intersection, scene *Scene,
+	specularVertices TracerSpecularVertices) float32 {
 	if pt.pathTypes.HasAlternatePath(
-		TRACER_DIRECT_SENSOR_PATH, edgeCount, sensor) {
+		TRACER_DIRECT_SENSOR_PATH, edgeCount, sensor,
+		light, specularVertices) {
 		pVertexIndex := edgeCount
 		switch pt.weighingMethod {
 		case TRACER_UNIFORM_WEIGHTS:
@@ -286,16 +305,19 @@ func (pt *ParticleTracer) computeEmittedImportanceWeight(
 	qVertexIndex := edgeCount - 1
 	pt.addVertexQs(sensorWeightTracker, qVertexIndex, edgeCount, sensor,
 		light, p, intersection.PEpsilon, intersection.N,
-		wo, Vector3{}, &SensorMaterial{sensor, x, y, p}, pChooseLight)
+		wo, Vector3{}, &SensorMaterial{sensor, x, y, p}, false, scene,
+		specularVertices)
 	qVertexIndex++
 	pt.addSensorSpatialQs(
-		sensorWeightTracker, qVertexIndex, edgeCount, sensor, p)
+		sensorWeightTracker, qVertexIndex, edgeCount, sensor, light,
+		p, specularVertices)
 
 	vertexCount := edgeCount + 1
 	w := sensorWeightTracker.ComputeWeight(vertexCount)
 	if pt.weighingMethod == TRACER_UNIFORM_WEIGHTS {
-		expectedW := 1 / float32(
-			pt.pathTypes.ComputePathCount(edgeCount, sensor))
+		expectedW := 1 / float32(pt.pathTypes.ComputePathCount(
+			edgeCount, sensor, light,
+			specularVertices))
 		if w != expectedW {
 			panic(fmt.Sprintf("(edgeCount=%d) w=%f != expectedW=%f",
 				edgeCount, w, expectedW))
@@ -308,7 +330,8 @@ func (pt *ParticleTracer) computeEmittedImportance(
 	edgeCount int, alpha *Spectrum, light Light,
 	templateWeightTracker TracerWeightTracker,
 	pPrev Point3, pEpsilonPrev float32, nPrev Normal3, wiPrev, wo Vector3,
-	intersection *Intersection, pChooseLight float32,
+	intersection *Intersection, scene *Scene,
+	specularVertices TracerSpecularVertices,
 	records []TracerRecord) []TracerRecord {
 	for _, sensor := range intersection.Sensors {
 		x, y, We := sensor.ComputePixelPositionAndWe(
@@ -329,7 +352,7 @@ func (pt *ParticleTracer) computeEmittedImportance(
 		w := pt.computeEmittedImportanceWeight(
 			&sensorWeightTracker, edgeCount, sensor, x, y, light,
 			pPrev, pEpsilonPrev, nPrev, wiPrev, wo, intersection,
-			pChooseLight)
+			scene, specularVertices)
 		if !isFiniteFloat32(w) {
 			fmt.Printf("Invalid weight %v returned for "+
 				"intersection %v and wo %v and sensor %v\n",
@@ -362,7 +385,8 @@ func (pt *ParticleTracer) computeDirectSensorWeight(
 	sensorEdgeCount int, sensor Sensor, x, y int, light Light,
 	alpha, f *Spectrum, p Point3, pEpsilon float32, n Normal3,
 	wo, wi Vector3, material Material, pSurface Point3, nSurface Normal3,
-	pChooseLight, pdfDirect float32) float32 {
+	scene *Scene, pdfDirect float32,
+	specularVertices TracerSpecularVertices) float32 {
 	pVertexIndex := sensorEdgeCount
 	switch pt.weighingMethod {
 	case TRACER_UNIFORM_WEIGHTS:
@@ -372,7 +396,8 @@ func (pt *ParticleTracer) computeDirectSensorWeight(
 	}
 
 	if pt.pathTypes.HasAlternatePath(
-		TRACER_EMITTED_IMPORTANCE_PATH, sensorEdgeCount, sensor) {
+		TRACER_EMITTED_IMPORTANCE_PATH, sensorEdgeCount, sensor,
+		light, specularVertices) {
 		switch pt.weighingMethod {
 		case TRACER_UNIFORM_WEIGHTS:
 			sensorWeightTracker.AddP(pVertexIndex, 1)
@@ -397,22 +422,24 @@ func (pt *ParticleTracer) computeDirectSensorWeight(
 		qVertexIndex := sensorEdgeCount - 2
 		pt.addVertexQs(sensorWeightTracker, qVertexIndex,
 			sensorEdgeCount, sensor, light, p, pEpsilon, n,
-			wo, wi, material, pChooseLight)
+			wo, wi, material, material.IsSpecular(), scene,
+			specularVertices)
 	}
 	qVertexIndex := sensorEdgeCount - 1
 	pt.addSensorDirectionalQs(
 		sensorWeightTracker, qVertexIndex, sensorEdgeCount, sensor,
-		x, y, wi, pSurface, nSurface)
+		light, x, y, wi, pSurface, nSurface, specularVertices)
 	qVertexIndex++
 	pt.addSensorSpatialQs(
 		sensorWeightTracker, qVertexIndex, sensorEdgeCount, sensor,
-		pSurface)
+		light, pSurface, specularVertices)
 
 	vertexCount := sensorEdgeCount + 1
 	w := sensorWeightTracker.ComputeWeight(vertexCount)
 	if pt.weighingMethod == TRACER_UNIFORM_WEIGHTS {
 		expectedW := 1 / float32(pt.pathTypes.ComputePathCount(
-			sensorEdgeCount, sensor))
+			sensorEdgeCount, sensor, light,
+			specularVertices))
 		if w != expectedW {
 			panic(fmt.Sprintf(
 				"(edgeCount=%d) w=%f != expectedW=%f",
@@ -425,10 +452,10 @@ func (pt *ParticleTracer) computeDirectSensorWeight(
 // This implements the sensor equivalent of direct lighting sampling.
 func (pt *ParticleTracer) directSampleSensors(
 	currentEdgeCount int, rng *rand.Rand, scene *Scene, sensors []Sensor,
-	light Light, pChooseLight float32,
-	tracerBundle SampleBundle, alpha *Spectrum,
+	light Light, tracerBundle SampleBundle, alpha *Spectrum,
 	templateWeightTracker TracerWeightTracker, p Point3,
 	pEpsilon float32, n Normal3, wo Vector3, material Material,
+	specularVertices TracerSpecularVertices,
 	records []TracerRecord) []TracerRecord {
 	directSensor1DSamples := tracerBundle.Samples1D[1:]
 	directSensor2DSamples := tracerBundle.Samples2D[1:]
@@ -471,7 +498,8 @@ func (pt *ParticleTracer) directSampleSensors(
 		w := pt.computeDirectSensorWeight(
 			&sensorWeightTracker, sensorEdgeCount, sensor, x, y,
 			light, alpha, &f, p, pEpsilon, n, wo, wi, material,
-			pSurface, nSurface, pChooseLight, pdf)
+			pSurface, nSurface, scene, pdf,
+			specularVertices)
 		if !isFiniteFloat32(w) {
 			fmt.Printf("Invalid weight %v returned for "+
 				"point %v and sensor %v\n",
@@ -506,7 +534,8 @@ func (pt *ParticleTracer) directSampleSensors(
 func (pt *ParticleTracer) updatePathWeight(
 	weightTracker *TracerWeightTracker, edgeCount int,
 	light Light, wo, wi Vector3, intersection *Intersection,
-	pContinue, pdfBsdf, pChooseLight float32) {
+	isSpecular bool, pContinue, pdfBsdf float32, scene *Scene,
+	specularVertices TracerSpecularVertices) {
 	// One for the direction to the next vertex (assuming
 	// there is one).
 	pVertexIndex := edgeCount + 1
@@ -514,6 +543,11 @@ func (pt *ParticleTracer) updatePathWeight(
 	case TRACER_UNIFORM_WEIGHTS:
 		weightTracker.AddP(pVertexIndex, 1)
 	case TRACER_POWER_WEIGHTS:
+		if isSpecular {
+			// Match ComputePdfForWeight().
+			weightTracker.AddP(pVertexIndex, 1)
+			break
+		}
 		if !pt.shouldIncludeRR() {
 			pContinue = 1
 		}
@@ -523,7 +557,8 @@ func (pt *ParticleTracer) updatePathWeight(
 	qVertexIndex := edgeCount - 1
 	pt.addVertexQs(weightTracker, qVertexIndex, edgeCount+1, nil, light,
 		intersection.P, intersection.PEpsilon,
-		intersection.N, wo, wi, intersection.Material, pChooseLight)
+		intersection.N, wo, wi, intersection.Material, isSpecular,
+		scene, specularVertices)
 }
 
 func (pt *ParticleTracer) SampleLightPath(
@@ -600,11 +635,19 @@ func (pt *ParticleTracer) SampleLightPath(
 		LeSpatialDivPdf.ScaleInv(&LeSpatialDivPdf, pChooseLight)
 		alpha = LeSpatialDivPdf
 
-		records = pt.directSampleSensors(
-			edgeCount, rng, scene, sensors, light, pChooseLight,
-			tracerBundle, &alpha, weightTracker, pSurface,
-			pSurfaceEpsilon, nSurface, Vector3{},
-			&LightMaterial{light, pSurface}, records)
+		// Lights with a specular position have no normal
+		// with which to connect to a sensor, and lights
+		// with a specular direction can't be connected to
+		// anything.
+		if !light.HasSpecularPosition() &&
+			!light.HasSpecularDirection() {
+			records = pt.directSampleSensors(
+				edgeCount, rng, scene, sensors, light,
+				tracerBundle, &alpha,
+				weightTracker, pSurface, pSurfaceEpsilon,
+				nSurface, Vector3{},
+				&LightMaterial{light, pSurface}, 0, records)
+		}
 
 		wo, LeDirectionalDivPdf, pdfDirectional :=
 			light.SampleDirection(lightBundle, pSurface, nSurface)
@@ -627,6 +670,12 @@ func (pt *ParticleTracer) SampleLightPath(
 		albedo = alpha
 	}
 
+	// Whether the interior vertex adjacent to the light is
+	// specular, and whether the last vertex (which is adjacent
+	// to the sensor for emitted importance paths) is.
+	var specularVertices TracerSpecularVertices
+	var isPrevSpecular bool
+
 	wiSamples := tracerBundle.Samples2D[0]
 	var t *Spectrum
 	switch pt.russianRouletteContribution {
@@ -651,6 +700,7 @@ func (pt *ParticleTracer) SampleLightPath(
 		if !scene.Aggregate.Intersect(&ray, &intersection) {
 			break
 		}
+		evaluateIntersectionMaterial(&intersection)
 		// The new edge is between ray.O and intersection.P.
 		edgeCount++
 
@@ -658,10 +708,16 @@ func (pt *ParticleTracer) SampleLightPath(
 		wo.Flip(&ray.D)
 
 		if pt.pathTypes.HasPaths(TRACER_EMITTED_IMPORTANCE_PATH) {
+			emittedSpecularVertices := specularVertices
+			if isPrevSpecular {
+				emittedSpecularVertices |=
+					TRACER_SPECULAR_SENSOR_NEIGHBOR
+			}
 			records = pt.computeEmittedImportance(
 				edgeCount, &alpha, light,
 				weightTracker, ray.O, ray.MinT, n, ray.D,
-				wo, &intersection, pChooseLight, records)
+				wo, &intersection, scene,
+				emittedSpecularVertices, records)
 		}
 
 		if edgeCount >= pt.maxEdgeCount {
@@ -674,19 +730,26 @@ func (pt *ParticleTracer) SampleLightPath(
 		material := intersection.Material
 
 		// Don't direct-sample sensors for the last edge,
-		// since the process adds an extra edge.
-		if pt.pathTypes.HasPaths(TRACER_DIRECT_SENSOR_PATH) {
+		// since the process adds an extra edge, or for
+		// specular vertices, since their BSDFs can't be
+		// evaluated.
+		if pt.pathTypes.HasPaths(TRACER_DIRECT_SENSOR_PATH) &&
+			!material.IsSpecular() {
 			records = pt.directSampleSensors(
 				edgeCount, rng, scene, sensors, light,
-				pChooseLight, tracerBundle, &alpha,
+				tracerBundle, &alpha,
 				weightTracker, p, pEpsilon, n, wo, material,
-				records)
+				specularVertices, records)
 		}
 
 		sampleIndex := edgeCount - 1
 		u := wiSamples.GetSample(sampleIndex, rng)
-		wi, fDivPdf, pdf := material.SampleWi(
-			MATERIAL_IMPORTANCE_TRANSPORT, u.U1, u.U2, wo, n)
+		// A vertex with a specular component (see
+		// PartiallySpecularMaterial) is treated as specular
+		// only if wi is sampled from that component.
+		wi, fDivPdf, pdf, isSpecular := sampleMaterialWi(
+			material, MATERIAL_IMPORTANCE_TRANSPORT,
+			u.U1, u.U2, wo, n)
 		if fDivPdf.IsBlack() || pdf == 0 {
 			break
 		}
@@ -697,11 +760,17 @@ func (pt *ParticleTracer) SampleLightPath(
 			break
 		}
 
+		if isSpecular && edgeCount == 1 {
+			specularVertices |= TRACER_SPECULAR_LIGHT_NEIGHBOR
+		}
+
 		pt.updatePathWeight(
 			&weightTracker, edgeCount, light, wo, wi,
-			&intersection, pContinue, pdf, pChooseLight)
+			&intersection, isSpecular, pContinue, pdf, scene,
+			specularVertices)
 
 		ray = Ray{p, wi, pEpsilon, infFloat32(+1)}
+		isPrevSpecular = isSpecular
 		alpha.Mul(&alpha, &fDivPdf)
 		albedo = fDivPdf
 	}
@@ -155,28 +155,39 @@ func (pt *PathTracer) recordWLeAlphaDebugInfo(
 	}
 }
 
-func (pt *PathTracer) hasBackwardsPath(edgeCount int, sensor Sensor) bool {
+func (pt *PathTracer) hasBackwardsPath(edgeCount int, sensor Sensor,
+	light Light, specularVertices TracerSpecularVertices) bool {
 	return pt.pathTypes.HasAlternatePath(
-		TRACER_EMITTED_IMPORTANCE_PATH, edgeCount, sensor) ||
+		TRACER_EMITTED_IMPORTANCE_PATH, edgeCount, sensor,
+		light, specularVertices) ||
 		pt.pathTypes.HasAlternatePath(
-			TRACER_DIRECT_SENSOR_PATH, edgeCount, sensor)
+			TRACER_DIRECT_SENSOR_PATH, edgeCount, sensor,
+			light, specularVertices)
 }
 
+// Adds the pdfs of the alternate paths that sample the vertex
+// before the next one from it. These go through the next vertex's
+// BSDF with the directions swapped and with importance transport,
+// which matters for BSDFs whose pdfs aren't symmetric (e.g., rough
+// dielectrics).
 func (pt *PathTracer) addVertexQs(
 	weightTracker *TracerWeightTracker, qVertexIndex, edgeCount int,
-	sensor Sensor, x, y int, pNext Point3, pEpsilonNext float32,
-	nNext Normal3, woNext, wiNext Vector3, materialNext Material) {
+	sensor Sensor, x, y int, light Light, pNext Point3, pEpsilonNext float32,
+	nNext Normal3, woNext, wiNext Vector3, materialNext Material,
+	isSpecularNext bool, specularVertices TracerSpecularVertices) {
 	var effectiveRussianRouletteState *RussianRouletteState
 	if pt.shouldIncludeRR() {
 		effectiveRussianRouletteState = pt.russianRouletteState
 	}
 	if qVertexIndex == 0 {
 		if pt.pathTypes.HasAlternatePath(
-			TRACER_EMITTED_IMPORTANCE_PATH, edgeCount, sensor) {
+			TRACER_EMITTED_IMPORTANCE_PATH, edgeCount, sensor,
+			light, specularVertices) {
 			pdf := ComputePdfForWeight(
 				pt.weighingMethod,
 				effectiveRussianRouletteState,
-				materialNext, MATERIAL_IMPORTANCE_TRANSPORT,
+				materialNext, isSpecularNext,
+				MATERIAL_IMPORTANCE_TRANSPORT,
 				wiNext, woNext, nNext)
 			// One for the direction to the sensor from
 			// vertex 1.
@@ -184,7 +195,8 @@ func (pt *PathTracer) addVertexQs(
 		}
 
 		if pt.pathTypes.HasAlternatePath(
-			TRACER_DIRECT_SENSOR_PATH, edgeCount, sensor) {
+			TRACER_DIRECT_SENSOR_PATH, edgeCount, sensor,
+			light, specularVertices) {
 			// One for direct sampling the sensor from
 			// vertex 1.
 			switch pt.weighingMethod {
@@ -197,10 +209,11 @@ func (pt *PathTracer) addVertexQs(
 				weightTracker.AddQ(0, pdfDirect)
 			}
 		}
-	} else if pt.hasBackwardsPath(edgeCount, sensor) {
+	} else if pt.hasBackwardsPath(edgeCount, sensor, light, specularVertices) {
 		pdf := ComputePdfForWeight(
 			pt.weighingMethod, effectiveRussianRouletteState,
-			materialNext, MATERIAL_IMPORTANCE_TRANSPORT,
+			materialNext, isSpecularNext,
+			MATERIAL_IMPORTANCE_TRANSPORT,
 			wiNext, woNext, nNext)
 		// One for the direction to this vertex from the next
 		// vertex.
@@ -211,8 +224,9 @@ func (pt *PathTracer) addVertexQs(
 func (pt *PathTracer) addLightDirectionalQs(
 	weightTracker *TracerWeightTracker, qVertexIndex, edgeCount int,
 	sensor Sensor, light Light, wiPrev Vector3,
-	pSurface Point3, nSurface Normal3) {
-	if pt.hasBackwardsPath(edgeCount, sensor) {
+	pSurface Point3, nSurface Normal3,
+	specularVertices TracerSpecularVertices) {
+	if pt.hasBackwardsPath(edgeCount, sensor, light, specularVertices) {
 		// One for the direction to this vertex from the
 		// light.
 		switch pt.weighingMethod {
@@ -231,20 +245,15 @@ func (pt *PathTracer) addLightDirectionalQs(
 func (pt *PathTracer) addLightSpatialQs(
 	weightTracker *TracerWeightTracker, qVertexIndex, edgeCount int,
 	scene *Scene, sensor Sensor, light Light, pSurface Point3,
-	existingPChooseLight *float32) {
-	if pt.hasBackwardsPath(edgeCount, sensor) {
+	specularVertices TracerSpecularVertices) {
+	if pt.hasBackwardsPath(edgeCount, sensor, light, specularVertices) {
 		// One for the point on the light and picking the
 		// light.
 		switch pt.weighingMethod {
 		case TRACER_UNIFORM_WEIGHTS:
 			weightTracker.AddQ(qVertexIndex, 1)
 		case TRACER_POWER_WEIGHTS:
-			var pChooseLight float32
-			if existingPChooseLight != nil {
-				pChooseLight = *existingPChooseLight
-			} else {
-				pChooseLight = scene.ComputeLightPdf(light)
-			}
+			pChooseLight := scene.ComputeLightPdf(light)
 			pdfSpatial := light.ComputeLeSpatialPdf(pSurface)
 			weightTracker.AddQ(
 				qVertexIndex, pChooseLight*pdfSpatial)
@@ -256,17 +265,20 @@ func (pt *PathTracer) computeEmittedLightWeight(
 	weightTracker *TracerWeightTracker,
 	edgeCount int, scene *Scene, sensor Sensor, x, y int,
 	pPrev Point3, pEpsilonPrev float32, nPrev Normal3, wiPrev, wo Vector3,
-	intersection *Intersection) float32 {
+	intersection *Intersection,
+	specularVertices TracerSpecularVertices) float32 {
 	light := intersection.Light
 
 	if pt.pathTypes.HasAlternatePath(
-		TRACER_DIRECT_LIGHTING_PATH, edgeCount, sensor) {
+		TRACER_DIRECT_LIGHTING_PATH, edgeCount, sensor,
+		light, specularVertices) {
 		pVertexIndex := edgeCount
 		switch pt.weighingMethod {
 		case TRACER_UNIFORM_WEIGHTS:
 			weightTracker.AddP(pVertexIndex, 1)
 		case TRACER_POWER_WEIGHTS:
-			pChooseLight := scene.ComputeLightPdf(light)
+			pChooseLight := scene.ComputeLightPdfFromPoint(
+				light, pPrev, nPrev)
 			directLightingPdf :=
 				light.ComputeLePdfFromPoint(
 					pPrev, pEpsilonPrev, nPrev, wiPrev)
@@ -279,17 +291,19 @@ func (pt *PathTracer) computeEmittedLightWeight(
 
 	qVertexIndex := edgeCount - 1
 	pt.addVertexQs(weightTracker, qVertexIndex, edgeCount, sensor,
-		x, y, p, intersection.PEpsilon, intersection.N, wo,
-		Vector3{}, &LightMaterial{light, p})
+		x, y, light, p, intersection.PEpsilon, intersection.N, wo,
+		Vector3{}, &LightMaterial{light, p}, false,
+		specularVertices)
 	qVertexIndex++
 	pt.addLightSpatialQs(weightTracker, qVertexIndex, edgeCount,
-		scene, sensor, light, p, nil)
+		scene, sensor, light, p, specularVertices)
 
 	vertexCount := edgeCount + 1
 	w := weightTracker.ComputeWeight(vertexCount)
 	if pt.weighingMethod == TRACER_UNIFORM_WEIGHTS {
-		expectedW := 1 / float32(
-			pt.pathTypes.ComputePathCount(edgeCount, sensor))
+		expectedW := 1 / float32(pt.pathTypes.ComputePathCount(
+			edgeCount, sensor, light,
+			specularVertices))
 		if w != expectedW {
 			panic(fmt.Sprintf("(edgeCount=%d) w=%f != expectedW=%f",
 				edgeCount, w, expectedW))
@@ -302,7 +316,7 @@ func (pt *PathTracer) computeEmittedLight(
 	edgeCount int, scene *Scene, sensor Sensor, x, y int, alpha *Spectrum,
 	weightTracker TracerWeightTracker,
 	pPrev Point3, pEpsilonPrev float32, nPrev Normal3, wiPrev, wo Vector3,
-	intersection *Intersection,
+	intersection *Intersection, specularVertices TracerSpecularVertices,
 	debugRecords *[]TracerDebugRecord) (wLeAlpha Spectrum) {
 	light := intersection.Light
 
@@ -310,7 +324,8 @@ func (pt *PathTracer) computeEmittedLight(
 		return Spectrum{}
 	}
 
-	Le := light.ComputeLe(intersection.P, intersection.N, wo)
+	Le := computeLightLeWithUV(light, intersection.P,
+		intersection.U, intersection.V, intersection.N, wo)
 
 	if Le.IsBlack() {
 		return
@@ -318,7 +333,8 @@ func (pt *PathTracer) computeEmittedLight(
 
 	w := pt.computeEmittedLightWeight(
 		&weightTracker, edgeCount, scene, sensor, x, y,
-		pPrev, pEpsilonPrev, nPrev, wiPrev, wo, intersection)
+		pPrev, pEpsilonPrev, nPrev, wiPrev, wo, intersection,
+		specularVertices)
 	if !isFiniteFloat32(w) {
 		fmt.Printf("Invalid weight %v returned for intersection %v "+
 			"and wo %v\n", w, intersection, wo)
@@ -339,7 +355,8 @@ func (pt *PathTracer) computeDirectLightingWeight(
 	edgeCount int, scene *Scene, sensor Sensor, x, y int, light Light,
 	alpha, f *Spectrum, wo, wi Vector3, intersection *Intersection,
 	pSurface Point3, nSurface Normal3,
-	pChooseLight, pdfDirect float32) float32 {
+	pChooseLight, pdfDirect float32,
+	specularVertices TracerSpecularVertices) float32 {
 	pVertexIndex := edgeCount
 	switch pt.weighingMethod {
 	case TRACER_UNIFORM_WEIGHTS:
@@ -351,7 +368,8 @@ func (pt *PathTracer) computeDirectLightingWeight(
 	material := intersection.Material
 
 	if pt.pathTypes.HasAlternatePath(
-		TRACER_EMITTED_LIGHT_PATH, edgeCount, sensor) {
+		TRACER_EMITTED_LIGHT_PATH, edgeCount, sensor,
+		light, specularVertices) {
 		switch pt.weighingMethod {
 		case TRACER_UNIFORM_WEIGHTS:
 			weightTracker.AddP(pVertexIndex, 1)
@@ -375,21 +393,23 @@ func (pt *PathTracer) computeDirectLightingWeight(
 
 	qVertexIndex := edgeCount - 2
 	pt.addVertexQs(weightTracker, qVertexIndex, edgeCount, sensor,
-		x, y, intersection.P, intersection.PEpsilon, intersection.N,
-		wo, wi, material)
+		x, y, light, intersection.P, intersection.PEpsilon,
+		intersection.N, wo, wi, material, material.IsSpecular(),
+		specularVertices)
 	qVertexIndex++
 	pt.addLightDirectionalQs(
 		weightTracker, qVertexIndex, edgeCount, sensor, light,
-		wi, pSurface, nSurface)
+		wi, pSurface, nSurface, specularVertices)
 	qVertexIndex++
 	pt.addLightSpatialQs(weightTracker, qVertexIndex, edgeCount, scene,
-		sensor, light, pSurface, &pChooseLight)
+		sensor, light, pSurface, specularVertices)
 
 	vertexCount := edgeCount + 1
 	w := weightTracker.ComputeWeight(vertexCount)
 	if pt.weighingMethod == TRACER_UNIFORM_WEIGHTS {
-		expectedW := 1 / float32(
-			pt.pathTypes.ComputePathCount(edgeCount, sensor))
+		expectedW := 1 / float32(pt.pathTypes.ComputePathCount(
+			edgeCount, sensor, light,
+			specularVertices))
 		if w != expectedW {
 			panic(fmt.Sprintf("(edgeCount=%d) w=%f != expectedW=%f",
 				edgeCount, w, expectedW))
@@ -402,7 +422,7 @@ func (pt *PathTracer) sampleDirectLighting(
 	edgeCount int, rng *rand.Rand, scene *Scene, sensor Sensor, x, y int,
 	tracerBundle SampleBundle, alpha *Spectrum,
 	weightTracker TracerWeightTracker, wo Vector3,
-	intersection *Intersection,
+	intersection *Intersection, specularVertices TracerSpecularVertices,
 	debugRecords *[]TracerDebugRecord) (wLeAlphaNext Spectrum) {
 	if len(scene.Lights) == 0 {
 		return
@@ -415,10 +435,14 @@ func (pt *PathTracer) sampleDirectLighting(
 	v := directLighting1DSamples[1].GetSample(sampleIndex, rng)
 	w := directLighting2DSamples[0].GetSample(sampleIndex, rng)
 
-	light, pChooseLight := scene.SampleLight(u.U)
-
 	n := intersection.N
 
+	light, pChooseLight := scene.SampleLightFromPoint(
+		u.U, intersection.P, n)
+	if pChooseLight == 0 {
+		return
+	}
+
 	LeDivPdf, pdf, wi, pSurface, nSurface, shadowRay :=
 		light.SampleLeFromPoint(
 			v.U, w.U1, w.U2, intersection.P,
@@ -445,7 +469,8 @@ func (pt *PathTracer) sampleDirectLighting(
 
 	weight := pt.computeDirectLightingWeight(
 		&weightTracker, edgeCount, scene, sensor, x, y, light, alpha,
-		&f, wo, wi, intersection, pSurface, nSurface, pChooseLight, pdf)
+		&f, wo, wi, intersection, pSurface, nSurface, pChooseLight, pdf,
+		specularVertices)
 	if !isFiniteFloat32(weight) {
 		fmt.Printf("Invalid weight %v returned for intersection %v "+
 			"and wo %v\n", weight, intersection, wo)
@@ -468,8 +493,8 @@ func (pt *PathTracer) sampleDirectLighting(
 
 func (pt *PathTracer) updatePathWeight(
 	weightTracker *TracerWeightTracker, edgeCount int, sensor Sensor,
-	x, y int, wo, wi Vector3, intersection *Intersection,
-	pContinue, pdfBsdf float32) {
+	x, y int, wo, wi Vector3, intersection *Intersection, isSpecular bool,
+	pContinue, pdfBsdf float32, specularVertices TracerSpecularVertices) {
 	// One for the direction to the next vertex (assuming there is
 	// one).
 	pVertexIndex := edgeCount + 1
@@ -477,6 +502,11 @@ func (pt *PathTracer) updatePathWeight(
 	case TRACER_UNIFORM_WEIGHTS:
 		weightTracker.AddP(pVertexIndex, 1)
 	case TRACER_POWER_WEIGHTS:
+		if isSpecular {
+			// Match ComputePdfForWeight().
+			weightTracker.AddP(pVertexIndex, 1)
+			break
+		}
 		if !pt.shouldIncludeRR() {
 			pContinue = 1
 		}
@@ -484,9 +514,64 @@ func (pt *PathTracer) updatePathWeight(
 	}
 
 	qVertexIndex := edgeCount - 1
+	// The light isn't known yet, but it's needed only for
+	// alternate paths with a single edge, and this path has at
+	// least two (see HasAlternatePath()).
 	pt.addVertexQs(weightTracker, qVertexIndex, edgeCount+1, sensor,
-		x, y, intersection.P, intersection.PEpsilon,
-		intersection.N, wo, wi, intersection.Material)
+		x, y, nil, intersection.P, intersection.PEpsilon,
+		intersection.N, wo, wi, intersection.Material, isSpecular,
+		specularVertices)
+}
+
+// Adds the weighted contribution of the light emitted from the given
+// intersection, which is where the given ray (the last edge of the
+// path) ends, to record.
+func (pt *PathTracer) addEmittedLight(
+	edgeCount int, scene *Scene, sensor Sensor, x, y int, alpha *Spectrum,
+	weightTracker TracerWeightTracker, ray *Ray, nPrev Normal3,
+	intersection *Intersection, specularVertices TracerSpecularVertices,
+	isPrevSpecular bool, record *TracerRecord) {
+	var wo Vector3
+	wo.Flip(&ray.D)
+	emittedSpecularVertices := specularVertices
+	if isPrevSpecular {
+		emittedSpecularVertices |= TRACER_SPECULAR_LIGHT_NEIGHBOR
+	}
+	wLeAlpha := pt.computeEmittedLight(
+		edgeCount, scene, sensor, x, y, alpha, weightTracker,
+		ray.O, ray.MinT, nPrev, ray.D, wo, intersection,
+		emittedSpecularVertices, &record.DebugRecords)
+	if !wLeAlpha.IsValid() {
+		fmt.Printf("Invalid wLeAlpha %v returned for "+
+			"intersection %v and wo %v\n",
+			wLeAlpha, intersection, wo)
+		wLeAlpha = Spectrum{}
+	}
+
+	record.WeLiDivPdf.Add(&record.WeLiDivPdf, &wLeAlpha)
+}
+
+// Like addEmittedLight(), but for a ray that escaped the scene, and
+// so can only hit infinite area lights.
+func (pt *PathTracer) addEscapedEmittedLight(
+	edgeCount int, scene *Scene, sensor Sensor, x, y int, alpha *Spectrum,
+	weightTracker TracerWeightTracker, ray *Ray, nPrev Normal3,
+	specularVertices TracerSpecularVertices, isPrevSpecular bool,
+	record *TracerRecord) {
+	for _, light := range scene.InfiniteLights {
+		infiniteAreaLight, ok := light.(InfiniteAreaLight)
+		if !ok {
+			continue
+		}
+		var intersection Intersection
+		if !infiniteAreaLight.IntersectEscapedRay(ray, &intersection) {
+			continue
+		}
+		pt.addEmittedLight(
+			edgeCount, scene, sensor, x, y, alpha, weightTracker,
+			ray, nPrev, &intersection, specularVertices,
+			isPrevSpecular, record)
+	}
 }
 
 // Samples a path starting from the given pixel coordinates on the
@@ -537,6 +622,12 @@ func (pt *PathTracer) SampleSensorPath(
 	// only when edgeCount > 1.
 	var n Normal3
 
+	// Whether the interior vertex adjacent to the sensor is
+	// specular, and whether the last vertex (which is adjacent
+	// to the light for emitted light paths) is.
+	var specularVertices TracerSpecularVertices
+	var isPrevSpecular bool
+
 	// alpha = We * T(path) / pdf.
 	alpha := WeDivPdf
 	albedo := WeDivPdf
@@ -562,8 +653,18 @@ func (pt *PathTracer) SampleSensorPath(
 		}
 		var intersection Intersection
 		if !scene.Aggregate.Intersect(&ray, &intersection) {
+			// The ray may still hit infinite area lights,
+			// but the path ends there regardless.
+			if pt.pathTypes.HasPaths(TRACER_EMITTED_LIGHT_PATH) {
+				pt.addEscapedEmittedLight(
+					edgeCount+1, scene, sensor, x, y,
+					&alpha, weightTracker, &ray, n,
+					specularVertices, isPrevSpecular,
+					record)
+			}
 			break
 		}
+		evaluateIntersectionMaterial(&intersection)
 		// The new edge is between ray.O and intersection.P.
 		edgeCount++
 
@@ -575,19 +676,10 @@ func (pt *PathTracer) SampleSensorPath(
 		// light (since direct lighting doesn't handle the
 		// first edge).
 		if pt.pathTypes.HasPaths(TRACER_EMITTED_LIGHT_PATH) {
-			wLeAlpha := pt.computeEmittedLight(
+			pt.addEmittedLight(
 				edgeCount, scene, sensor, x, y, &alpha,
-				weightTracker, ray.O, ray.MinT, n,
-				ray.D, wo, &intersection,
-				&record.DebugRecords)
-			if !wLeAlpha.IsValid() {
-				fmt.Printf("Invalid wLeAlpha %v returned for "+
-					"intersection %v and wo %v\n",
-					wLeAlpha, intersection, wo)
-				wLeAlpha = Spectrum{}
-			}
-
-			record.WeLiDivPdf.Add(&record.WeLiDivPdf, &wLeAlpha)
+				weightTracker, &ray, n, &intersection,
+				specularVertices, isPrevSpecular, record)
 		}
 
 		if edgeCount >= pt.maxEdgeCount {
@@ -595,12 +687,16 @@ func (pt *PathTracer) SampleSensorPath(
 		}
 
 		// Don't sample direct lighting for the last edge,
-		// since the process adds an extra edge.
-		if pt.pathTypes.HasPaths(TRACER_DIRECT_LIGHTING_PATH) {
+		// since the process adds an extra edge, or for
+		// specular vertices, since their BSDFs can't be
+		// evaluated.
+		if pt.pathTypes.HasPaths(TRACER_DIRECT_LIGHTING_PATH) &&
+			!intersection.Material.IsSpecular() {
 			wLeAlphaNext := pt.sampleDirectLighting(
 				edgeCount, rng, scene, sensor, x, y,
 				tracerBundle, &alpha, weightTracker, wo,
-				&intersection, &record.DebugRecords)
+				&intersection, specularVertices,
+				&record.DebugRecords)
 			if !wLeAlphaNext.IsValid() {
 				fmt.Printf("Invalid wLeAlphaNext %v returned "+
 					"for intersection %v and wo %v\n",
@@ -613,8 +709,11 @@ func (pt *PathTracer) SampleSensorPath(
 
 		sampleIndex := edgeCount - 1
 		u := wiSamples.GetSample(sampleIndex, rng)
-		wi, fDivPdf, pdf := intersection.Material.SampleWi(
-			MATERIAL_LIGHT_TRANSPORT,
+		// A vertex with a specular component (see
+		// PartiallySpecularMaterial) is treated as specular
+		// only if wi is sampled from that component.
+		wi, fDivPdf, pdf, isSpecular := sampleMaterialWi(
+			intersection.Material, MATERIAL_LIGHT_TRANSPORT,
 			u.U1, u.U2, wo, intersection.N)
 		if fDivPdf.IsBlack() || pdf == 0 {
 			break
@@ -626,15 +725,21 @@ func (pt *PathTracer) SampleSensorPath(
 			break
 		}
 
+		if isSpecular && edgeCount == 1 {
+			specularVertices |= TRACER_SPECULAR_SENSOR_NEIGHBOR
+		}
+
 		pt.updatePathWeight(
 			&weightTracker, edgeCount, sensor, x, y, wo, wi,
-			&intersection, pContinue, pdf)
+			&intersection, isSpecular, pContinue, pdf,
+			specularVertices)
 
 		ray = Ray{
 			intersection.P, wi,
 			intersection.PEpsilon, infFloat32(+1),
 		}
 		n = intersection.N
+		isPrevSpecular = isSpecular
 		alpha.Mul(&alpha, &fDivPdf)
 		albedo = fDivPdf
 	}
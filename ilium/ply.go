@@ -0,0 +1,398 @@
+package ilium
+
+import "bufio"
+import "encoding/binary"
+import "fmt"
+import "io"
+import "math"
+import "os"
+import "strconv"
+import "strings"
+
+type plyProperty struct {
+	name string
+	// For list properties, the type of the list length;
+	// otherwise, the empty string.
+	countType string
+	valueType string
+}
+
+func (p *plyProperty) isList() bool {
+	return len(p.countType) > 0
+}
+
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+type plyReader struct {
+	path     string
+	reader   *bufio.Reader
+	elements []plyElement
+	// nil for ASCII files.
+	order binary.ByteOrder
+	// Used only for ASCII files.
+	scanner *bufio.Scanner
+	buf     [8]byte
+}
+
+func (r *plyReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", r.path, fmt.Sprintf(format, args...))
+}
+
+func getPlyTypeSize(plyType string) int {
+	switch plyType {
+	case "char", "int8", "uchar", "uint8":
+		return 1
+	case "short", "int16", "ushort", "uint16":
+		return 2
+	case "int", "int32", "uint", "uint32", "float", "float32":
+		return 4
+	case "double", "float64":
+		return 8
+	}
+	return 0
+}
+
+func (r *plyReader) readHeaderLine() ([]string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = r.errorf("unexpected end of header")
+		}
+		return nil, err
+	}
+	return strings.Fields(line), nil
+}
+
+func (r *plyReader) readHeader() error {
+	fields, err := r.readHeaderLine()
+	if err != nil {
+		return err
+	}
+	if len(fields) != 1 || fields[0] != "ply" {
+		return r.errorf("not a PLY file")
+	}
+
+	hasFormat := false
+	for {
+		fields, err := r.readHeaderLine()
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "format":
+			if len(fields) != 3 {
+				return r.errorf("invalid format line")
+			}
+			switch fields[1] {
+			case "ascii":
+				r.order = nil
+			case "binary_little_endian":
+				r.order = binary.LittleEndian
+			case "binary_big_endian":
+				r.order = binary.BigEndian
+			default:
+				return r.errorf("unknown format %s", fields[1])
+			}
+			hasFormat = true
+		case "element":
+			if len(fields) != 3 {
+				return r.errorf("invalid element line")
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return r.errorf(
+					"invalid element count %s", fields[2])
+			}
+			r.elements = append(r.elements,
+				plyElement{name: fields[1], count: count})
+		case "property":
+			if len(r.elements) == 0 {
+				return r.errorf("property before element")
+			}
+			var property plyProperty
+			if len(fields) == 5 && fields[1] == "list" {
+				property = plyProperty{
+					fields[4], fields[2], fields[3],
+				}
+				if getPlyTypeSize(property.countType) == 0 {
+					return r.errorf("unknown type %s",
+						property.countType)
+				}
+			} else if len(fields) == 3 {
+				property = plyProperty{fields[2], "", fields[1]}
+			} else {
+				return r.errorf("invalid property line")
+			}
+			if getPlyTypeSize(property.valueType) == 0 {
+				return r.errorf(
+					"unknown type %s", property.valueType)
+			}
+			element := &r.elements[len(r.elements)-1]
+			element.properties = append(
+				element.properties, property)
+		case "end_header":
+			if !hasFormat {
+				return r.errorf("missing format line")
+			}
+			if r.order == nil {
+				r.scanner = bufio.NewScanner(r.reader)
+				r.scanner.Split(bufio.ScanWords)
+			}
+			return nil
+		default:
+			// Ignore comments, obj_info, and anything
+			// else.
+		}
+	}
+}
+
+func (r *plyReader) readValue(plyType string) (float64, error) {
+	if r.order == nil {
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return 0, err
+			}
+			return 0, r.errorf("unexpected end of file")
+		}
+		value, err := strconv.ParseFloat(r.scanner.Text(), 64)
+		if err != nil {
+			return 0, r.errorf("invalid value %q", r.scanner.Text())
+		}
+		return value, nil
+	}
+
+	size := getPlyTypeSize(plyType)
+	bytes := r.buf[:size]
+	if _, err := io.ReadFull(r.reader, bytes); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = r.errorf("unexpected end of file")
+		}
+		return 0, err
+	}
+	switch plyType {
+	case "char", "int8":
+		return float64(int8(bytes[0])), nil
+	case "uchar", "uint8":
+		return float64(bytes[0]), nil
+	case "short", "int16":
+		return float64(int16(r.order.Uint16(bytes))), nil
+	case "ushort", "uint16":
+		return float64(r.order.Uint16(bytes)), nil
+	case "int", "int32":
+		return float64(int32(r.order.Uint32(bytes))), nil
+	case "uint", "uint32":
+		return float64(r.order.Uint32(bytes)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(r.order.Uint32(bytes))), nil
+	case "double", "float64":
+		return math.Float64frombits(r.order.Uint64(bytes)), nil
+	}
+	panic("unexpectedly reached")
+}
+
+// Reads the values of the given property for one element. Scalar
+// properties yield exactly one value.
+func (r *plyReader) readProperty(
+	property *plyProperty, values []float64) ([]float64, error) {
+	values = values[:0]
+	count := 1
+	if property.isList() {
+		countValue, err := r.readValue(property.countType)
+		if err != nil {
+			return nil, err
+		}
+		count = int(countValue)
+		if count < 0 {
+			return nil, r.errorf("invalid list length %d", count)
+		}
+	}
+	for i := 0; i < count; i++ {
+		value, err := r.readValue(property.valueType)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func findPlyProperty(element *plyElement, names ...string) int {
+	for _, name := range names {
+		for i := 0; i < len(element.properties); i++ {
+			if element.properties[i].name == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (r *plyReader) readVertices(element *plyElement, mesh *triangleMesh) error {
+	xIndex := findPlyProperty(element, "x")
+	yIndex := findPlyProperty(element, "y")
+	zIndex := findPlyProperty(element, "z")
+	if xIndex < 0 || yIndex < 0 || zIndex < 0 {
+		return r.errorf("vertex element is missing x, y, or z")
+	}
+	nxIndex := findPlyProperty(element, "nx")
+	nyIndex := findPlyProperty(element, "ny")
+	nzIndex := findPlyProperty(element, "nz")
+	hasNormals := nxIndex >= 0 && nyIndex >= 0 && nzIndex >= 0
+	uIndex := findPlyProperty(
+		element, "u", "s", "texture_u", "texture_s")
+	vIndex := findPlyProperty(
+		element, "v", "t", "texture_v", "texture_t")
+	hasUVs := uIndex >= 0 && vIndex >= 0
+
+	mesh.vertices = make([]Point3, element.count)
+	if hasNormals {
+		mesh.normals = make([]Normal3, element.count)
+	}
+	if hasUVs {
+		mesh.uvs = make([][2]float32, element.count)
+	}
+	values := make([]float64, len(element.properties))
+	var propertyValues []float64
+	for i := 0; i < element.count; i++ {
+		for j := 0; j < len(element.properties); j++ {
+			var err error
+			propertyValues, err = r.readProperty(
+				&element.properties[j], propertyValues)
+			if err != nil {
+				return err
+			}
+			// Ignore the values of list properties.
+			if len(propertyValues) > 0 {
+				values[j] = propertyValues[0]
+			}
+		}
+		mesh.vertices[i] = Point3{
+			float32(values[xIndex]),
+			float32(values[yIndex]),
+			float32(values[zIndex]),
+		}
+		if hasNormals {
+			n := Normal3{
+				float32(values[nxIndex]),
+				float32(values[nyIndex]),
+				float32(values[nzIndex]),
+			}
+			n.Normalize(&n)
+			mesh.normals[i] = n
+		}
+		if hasUVs {
+			mesh.uvs[i] = [2]float32{
+				float32(values[uIndex]),
+				float32(values[vIndex]),
+			}
+		}
+	}
+	return nil
+}
+
+func (r *plyReader) readFaces(element *plyElement, mesh *triangleMesh) error {
+	indicesIndex := findPlyProperty(
+		element, "vertex_indices", "vertex_index")
+	if indicesIndex < 0 || !element.properties[indicesIndex].isList() {
+		return r.errorf("face element is missing vertex_indices")
+	}
+	mesh.indices = make([][3]int, 0, element.count)
+	var propertyValues []float64
+	for i := 0; i < element.count; i++ {
+		for j := 0; j < len(element.properties); j++ {
+			var err error
+			propertyValues, err = r.readProperty(
+				&element.properties[j], propertyValues)
+			if err != nil {
+				return err
+			}
+			if j != indicesIndex {
+				continue
+			}
+			if len(propertyValues) < 3 {
+				return r.errorf(
+					"face %d has fewer than 3 vertices", i)
+			}
+			// Triangulate the polygon as a fan around its
+			// first vertex, which assumes that it is
+			// convex.
+			for k := 1; k+1 < len(propertyValues); k++ {
+				mesh.indices = append(mesh.indices, [3]int{
+					int(propertyValues[0]),
+					int(propertyValues[k]),
+					int(propertyValues[k+1]),
+				})
+			}
+		}
+	}
+	return nil
+}
+
+func (r *plyReader) skipElement(element *plyElement) error {
+	var propertyValues []float64
+	for i := 0; i < element.count; i++ {
+		for j := 0; j < len(element.properties); j++ {
+			var err error
+			propertyValues, err = r.readProperty(
+				&element.properties[j], propertyValues)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Reads the ASCII or binary PLY file at the given path and returns
+// the triangle mesh in it. Polygons are triangulated, and per-vertex
+// normals and texture coordinates are kept if present.
+func readTriangleMeshFromPlyFile(path string) (*triangleMesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := plyReader{path: path, reader: bufio.NewReader(f)}
+	if err := r.readHeader(); err != nil {
+		return nil, err
+	}
+
+	var mesh triangleMesh
+	hasVertices := false
+	for i := 0; i < len(r.elements); i++ {
+		element := &r.elements[i]
+		switch element.name {
+		case "vertex":
+			err = r.readVertices(element, &mesh)
+			hasVertices = true
+		case "face":
+			err = r.readFaces(element, &mesh)
+		default:
+			err = r.skipElement(element)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !hasVertices {
+		return nil, r.errorf("no vertex element")
+	}
+
+	for _, indices := range mesh.indices {
+		for _, index := range indices {
+			if index < 0 || index >= len(mesh.vertices) {
+				return nil, r.errorf(
+					"vertex index %d out of range", index)
+			}
+		}
+	}
+	return &mesh, nil
+}
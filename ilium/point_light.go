@@ -0,0 +1,151 @@
+package ilium
+
+import "math"
+
+// A PointLight emits light uniformly in all directions from a single
+// point. Since it has no surface, it can't be hit by rays, and its
+// radiance is a delta distribution whose integral over the
+// neighborhood of the point is the intensity.
+//
+// Since there's no surface normal either, the pdfs with respect to
+// projected solid angle at the light are computed as if the normal
+// always pointed along the direction in question.
+type PointLight struct {
+	position  Point3
+	intensity Spectrum
+}
+
+func MakePointLight(
+	config map[string]interface{}, shapes []Shape) *PointLight {
+	if len(shapes) != 1 {
+		panic("Point light must have exactly one PointShape")
+	}
+	pointShape, ok := shapes[0].(*PointShape)
+	if !ok {
+		panic("Point light must have exactly one PointShape")
+	}
+	intensityConfig := config["intensity"].(map[string]interface{})
+	intensity := MakeSpectrumFromConfig(intensityConfig)
+	return &PointLight{pointShape.P, intensity}
+}
+
+func (pl *PointLight) HasSpecularPosition() bool {
+	return true
+}
+
+func (pl *PointLight) HasSpecularDirection() bool {
+	return false
+}
+
+func (pl *PointLight) GetSampleConfig() SampleConfig {
+	return SampleConfig{
+		Sample1DLengths: []int{},
+		Sample2DLengths: []int{1},
+	}
+}
+
+func (pl *PointLight) SampleSurface(sampleBundle SampleBundle) (
+	pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, LeSpatialDivPdf Spectrum, pdf float32) {
+	pSurface = pl.position
+	// The pdf with respect to surface area is just 1 (with an
+	// implicit delta distribution).
+	LeSpatialDivPdf = pl.ComputeLeSpatial(pSurface)
+	pdf = 1
+	return
+}
+
+func (pl *PointLight) SampleDirection(
+	sampleBundle SampleBundle, pSurface Point3, nSurface Normal3) (
+	wo Vector3, LeDirectionalDivPdf Spectrum, pdf float32) {
+	u1 := sampleBundle.Samples2D[0][0].U1
+	u2 := sampleBundle.Samples2D[0][0].U2
+	wo = Vector3(uniformSampleSphere(u1, u2))
+	// LeDirectional = 1 / (4 * pi) and pdf = 1 / (4 * pi).
+	LeDirectionalDivPdf = MakeConstantSpectrum(1)
+	pdf = uniformSpherePdfSolidAngle()
+	return
+}
+
+func (pl *PointLight) SampleRay(sampleBundle SampleBundle) (
+	ray Ray, LeDivPdf Spectrum, pdf float32) {
+	u1 := sampleBundle.Samples2D[0][0].U1
+	u2 := sampleBundle.Samples2D[0][0].U2
+	wo := Vector3(uniformSampleSphere(u1, u2))
+	ray = Ray{pl.position, wo, 0, infFloat32(+1)}
+	// pdf = 1 / (4 * pi).
+	LeDivPdf.Scale(&pl.intensity, 4*math.Pi)
+	pdf = uniformSpherePdfSolidAngle()
+	return
+}
+
+func (pl *PointLight) SampleLeFromPoint(
+	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
+	LeDivPdf Spectrum, pdf float32, wi Vector3,
+	pSurface Point3, nSurface Normal3, shadowRay Ray) {
+	r := wi.GetDirectionAndDistance(&p, &pl.position)
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	if absCosThI < PDF_COS_THETA_EPSILON || r < PDF_R_EPSILON {
+		wi = Vector3{}
+		return
+	}
+
+	// The pdf w.r.t. surface area is just 1 (with an implicit
+	// delta distribution), so pdf = 1 / G(p <-> position) =
+	// r^2 / |cos(thI)|, and Le = intensity.
+	LeDivPdf.Scale(&pl.intensity, absCosThI/(r*r))
+	pdf = (r * r) / absCosThI
+	pSurface = pl.position
+	shadowRay = Ray{p, wi, pEpsilon, r * (1 - 5e-4)}
+	return
+}
+
+func (pl *PointLight) ComputeLePdfFromPoint(
+	p Point3, pEpsilon float32, n Normal3, wi Vector3) float32 {
+	// Since we're assuming wi points towards the light, this is
+	// the same pdf as in SampleLeFromPoint().
+	r := pl.position.Distance(&p)
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	return r * r / absCosThI
+}
+
+func (pl *PointLight) ComputeLeSpatial(pSurface Point3) Spectrum {
+	var LeSpatial Spectrum
+	LeSpatial.Scale(&pl.intensity, 4*math.Pi)
+	return LeSpatial
+}
+
+func (pl *PointLight) ComputeLeSpatialPdf(pSurface Point3) float32 {
+	// Since we're assuming pSurface is the light's position,
+	// return 1 even though we have a delta spatial distribution.
+	return 1
+}
+
+func (pl *PointLight) ComputeLeDirectional(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	return MakeConstantSpectrum(1 / (4 * math.Pi))
+}
+
+func (pl *PointLight) ComputeLeDirectionalPdf(
+	pSurface Point3, nSurface Normal3, wo Vector3) float32 {
+	return uniformSpherePdfSolidAngle()
+}
+
+func (pl *PointLight) ComputeLe(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	return pl.intensity
+}
+
+func (pl *PointLight) Power() Spectrum {
+	return pl.ComputeLeSpatial(pl.position)
+}
+
+func (pl *PointLight) getLightBounds() (lightBounds, bool) {
+	power := pl.Power()
+	return lightBounds{
+		bound:      MakeBBoxFromPoint(pl.position),
+		power:      power.Y(),
+		normalCone: makeEntireSphereDirectionCone(),
+		cosThetaE:  0,
+	}, true
+}
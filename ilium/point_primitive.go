@@ -1,14 +1,19 @@
 package ilium
 
 type PointPrimitive struct {
-	sensors []Sensor
-	lights  []Light
+	position Point3
+	sensors  []Sensor
+	lights   []Light
 }
 
 func (pp *PointPrimitive) Intersect(ray *Ray, intersection *Intersection) bool {
 	return false
 }
 
+func (pp *PointPrimitive) WorldBound() BBox {
+	return MakeBBoxFromPoint(pp.position)
+}
+
 func (pp *PointPrimitive) GetSensors() []Sensor {
 	return pp.sensors
 }
@@ -37,5 +42,5 @@ func MakePointPrimitive(
 			lights = append(lights, light)
 		}
 	}
-	return &PointPrimitive{sensors, lights}
+	return &PointPrimitive{position, sensors, lights}
 }
@@ -8,6 +8,10 @@ func (ps *PointShape) Intersect(ray *Ray, intersection *Intersection) bool {
 	return false
 }
 
+func (ps *PointShape) WorldBound() BBox {
+	return MakeBBoxFromPoint(ps.P)
+}
+
 func (ps *PointShape) SurfaceArea() float32 {
 	return 0
 }
@@ -4,20 +4,41 @@ type Intersection struct {
 	T        float32
 	P        Point3
 	PEpsilon float32
-	N        Normal3
-	Material Material
-	Light    Light
-	Sensors  []Sensor
+	// The geometric normal, which points to the outside of the
+	// surface regardless of which side the ray came from. Thus,
+	// a ray with direction d is entering the surface exactly
+	// when d . N < 0.
+	N Normal3
+	// The normal to shade P with, e.g. interpolated from
+	// per-vertex normals. It's always on the same side of the
+	// surface as N, and is equal to N for shapes without
+	// separate shading normals.
+	ShadingN Normal3
+	// The (u, v) coordinates of P and the partial derivatives of
+	// P with respect to u and v, for shapes that have them (see
+	// UVShape). Along with ShadingN, the derivatives give the
+	// tangent frame used by bump and normal maps.
+	U, V       float32
+	DPDU, DPDV Vector3
+	Material   Material
+	Light      Light
+	Sensors    []Sensor
 }
 
 type Primitive interface {
 	// intersection can be nil.
 	Intersect(ray *Ray, intersection *Intersection) bool
+	// Returns the bounding box of the primitive in world space.
+	WorldBound() BBox
 	GetSensors() []Sensor
 	GetLights() []Light
 }
 
-func MakePrimitives(config map[string]interface{}) []Primitive {
+// namedPrimitives holds the primitives that InstancePrimitives can
+// refer to by name.
+func MakePrimitives(
+	config map[string]interface{},
+	namedPrimitives map[string]Primitive) []Primitive {
 	primitiveType := config["type"].(string)
 	switch primitiveType {
 	case "InlinePrimitiveList":
@@ -25,14 +46,21 @@ func MakePrimitives(config map[string]interface{}) []Primitive {
 		allPrimitives := []Primitive{}
 		for _, primitiveConfig := range primitiveConfigs {
 			primitives := MakePrimitives(
-				primitiveConfig.(map[string]interface{}))
+				primitiveConfig.(map[string]interface{}),
+				namedPrimitives)
 			allPrimitives = append(allPrimitives, primitives...)
 		}
 		return allPrimitives
 	case "PrimitiveList":
-		return []Primitive{MakePrimitiveList(config)}
+		return []Primitive{MakePrimitiveList(config, namedPrimitives)}
+	case "BVHAggregate":
+		return []Primitive{MakeBVHAggregate(config, namedPrimitives)}
 	case "GeometricPrimitive":
 		return MakeGeometricPrimitives(config)
+	case "InstancePrimitive":
+		return []Primitive{
+			MakeInstancePrimitiveFromConfig(config, namedPrimitives),
+		}
 	case "PointPrimitive":
 		return []Primitive{MakePointPrimitive(config)}
 	default:
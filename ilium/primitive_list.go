@@ -25,9 +25,32 @@ func (pl *PrimitiveList) Intersect(ray *Ray, intersection *Intersection) bool {
 	}
 }
 
+func (pl *PrimitiveList) WorldBound() BBox {
+	return computePrimitivesWorldBound(pl.primitives)
+}
+
 func (pl *PrimitiveList) GetSensors() []Sensor {
+	return collectPrimitiveSensors(pl.primitives)
+}
+
+func (pl *PrimitiveList) GetLights() []Light {
+	return collectPrimitiveLights(pl.primitives)
+}
+
+func computePrimitivesWorldBound(primitives []Primitive) BBox {
+	b := MakeEmptyBBox()
+	for _, primitive := range primitives {
+		primitiveBound := primitive.WorldBound()
+		b.Union(&b, &primitiveBound)
+	}
+	return b
+}
+
+// Returns the sensors of the given primitives with duplicates
+// removed.
+func collectPrimitiveSensors(primitives []Primitive) []Sensor {
 	sensorMap := make(map[Sensor]bool)
-	for _, primitive := range pl.primitives {
+	for _, primitive := range primitives {
 		for _, sensor := range primitive.GetSensors() {
 			sensorMap[sensor] = true
 		}
@@ -39,9 +62,11 @@ func (pl *PrimitiveList) GetSensors() []Sensor {
 	return sensors
 }
 
-func (pl *PrimitiveList) GetLights() []Light {
+// Returns the lights of the given primitives with duplicates
+// removed.
+func collectPrimitiveLights(primitives []Primitive) []Light {
 	lightMap := make(map[Light]bool)
-	for _, primitive := range pl.primitives {
+	for _, primitive := range primitives {
 		for _, light := range primitive.GetLights() {
 			lightMap[light] = true
 		}
@@ -53,14 +78,24 @@ func (pl *PrimitiveList) GetLights() []Light {
 	return lights
 }
 
-func MakePrimitiveList(config map[string]interface{}) *PrimitiveList {
+func makeChildPrimitives(
+	config map[string]interface{},
+	namedPrimitives map[string]Primitive) []Primitive {
 	primitiveConfigs := config["primitives"].([]interface{})
 	primitives := []Primitive{}
 	for _, primitiveConfig := range primitiveConfigs {
 		primitives = append(
 			primitives,
 			MakePrimitives(
-				primitiveConfig.(map[string]interface{}))...)
+				primitiveConfig.(map[string]interface{}),
+				namedPrimitives)...)
 	}
+	return primitives
+}
+
+func MakePrimitiveList(
+	config map[string]interface{},
+	namedPrimitives map[string]Primitive) *PrimitiveList {
+	primitives := makeChildPrimitives(config, namedPrimitives)
 	return &PrimitiveList{primitives}
 }
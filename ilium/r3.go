@@ -23,6 +23,20 @@ func MakeSphericalDirection(cosTheta, phi float32) R3 {
 	}
 }
 
+// Returns the component of r along the given axis (0 for X, 1 for Y,
+// and 2 for Z).
+func (r *R3) GetComponent(axis int) float32 {
+	switch axis {
+	case 0:
+		return r.X
+	case 1:
+		return r.Y
+	case 2:
+		return r.Z
+	}
+	panic("invalid axis")
+}
+
 func (out *R3) Add(r, s *R3) {
 	out.X = r.X + s.X
 	out.Y = r.Y + s.Y
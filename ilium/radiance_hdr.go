@@ -0,0 +1,167 @@
+package ilium
+
+import "bufio"
+import "fmt"
+import "io"
+import "math"
+import "os"
+import "strings"
+
+type radianceHdrReader struct {
+	path   string
+	reader *bufio.Reader
+}
+
+func (r *radianceHdrReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", r.path, fmt.Sprintf(format, args...))
+}
+
+func (r *radianceHdrReader) readLine() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return "", r.errorf("unexpected end of header")
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Reads the header and the resolution line, and returns the width
+// and height of the image.
+func (r *radianceHdrReader) readHeader() (width, height int, err error) {
+	line, err := r.readLine()
+	if err != nil {
+		return
+	}
+	if line != "#?RADIANCE" && line != "#?RGBE" {
+		err = r.errorf("not a Radiance HDR file")
+		return
+	}
+	for {
+		line, err = r.readLine()
+		if err != nil {
+			return
+		}
+		if len(line) == 0 {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") &&
+			line != "FORMAT=32-bit_rle_rgbe" {
+			err = r.errorf("unsupported format %q", line)
+			return
+		}
+	}
+	line, err = r.readLine()
+	if err != nil {
+		return
+	}
+	// Only the standard orientation (rows from top to bottom,
+	// with each row going left to right) is supported.
+	if _, scanErr := fmt.Sscanf(
+		line, "-Y %d +X %d", &height, &width); scanErr != nil {
+		err = r.errorf("unsupported resolution line %q", line)
+		return
+	}
+	if width <= 0 || height <= 0 {
+		err = r.errorf("invalid resolution %dx%d", width, height)
+	}
+	return
+}
+
+// Reads a single scanline of RGBE pixels into scanline, which may be
+// either run-length encoded or flat.
+func (r *radianceHdrReader) readScanline(scanline []byte, width int) error {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r.reader, prefix[:]); err != nil {
+		return err
+	}
+	isRle := width >= 8 && width < 0x8000 &&
+		prefix[0] == 2 && prefix[1] == 2 && prefix[2]&0x80 == 0
+	if !isRle {
+		copy(scanline, prefix[:])
+		_, err := io.ReadFull(r.reader, scanline[4:])
+		return err
+	}
+
+	if int(prefix[2])<<8|int(prefix[3]) != width {
+		return r.errorf("scanline width mismatch")
+	}
+	// Each of the four components is stored separately.
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := r.reader.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				runLength := int(count) - 128
+				if x+runLength > width {
+					return r.errorf("run overflows scanline")
+				}
+				value, err := r.reader.ReadByte()
+				if err != nil {
+					return err
+				}
+				for i := 0; i < runLength; i++ {
+					scanline[4*(x+i)+c] = value
+				}
+				x += runLength
+			} else {
+				if count == 0 || x+int(count) > width {
+					return r.errorf("invalid literal run")
+				}
+				for i := 0; i < int(count); i++ {
+					value, err := r.reader.ReadByte()
+					if err != nil {
+						return err
+					}
+					scanline[4*(x+i)+c] = value
+				}
+				x += int(count)
+			}
+		}
+	}
+	return nil
+}
+
+func convertRgbeToSpectrum(rgbe []byte) Spectrum {
+	if rgbe[3] == 0 {
+		return Spectrum{}
+	}
+	f := float32(math.Ldexp(1, int(rgbe[3])-(128+8)))
+	return MakeRGBSpectrum(
+		float32(rgbe[0])*f, float32(rgbe[1])*f, float32(rgbe[2])*f)
+}
+
+// Reads the Radiance HDR (RGBE) file at the given path and returns
+// its pixels in row-major order, starting from the top row.
+func readRadianceHdrFile(path string) (
+	width, height int, pixels []Spectrum, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	r := radianceHdrReader{path: path, reader: bufio.NewReader(f)}
+	width, height, err = r.readHeader()
+	if err != nil {
+		return
+	}
+	pixels = make([]Spectrum, width*height)
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		if err = r.readScanline(scanline, width); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = r.errorf("unexpected end of pixel data")
+			}
+			return
+		}
+		for x := 0; x < width; x++ {
+			pixels[y*width+x] =
+				convertRgbeToSpectrum(scanline[4*x : 4*x+4])
+		}
+	}
+	return
+}
@@ -0,0 +1,291 @@
+package ilium
+
+// A RoughDielectricMaterial is a rough boundary between the outside,
+// assumed to be a vacuum, and a dielectric like frosted glass on the
+// inside (i.e., the side opposite the normal). It uses the microfacet
+// BSDF from Walter et al.'s "Microfacet Models for Refraction through
+// Rough Surfaces", which has both a reflection and a transmission
+// lobe.
+type RoughDielectricMaterial struct {
+	samplingMethod MicrofacetSamplingMethod
+	eta            float32
+	reflectance    Spectrum
+	transmittance  Spectrum
+	distribution   MicrofacetDistribution
+	// dP/du at the intersection the material was evaluated at,
+	// if the distribution is anisotropic.
+	dpdu Vector3
+	// If non-nil, distribution is given by this texture instead.
+	distributionTexture *microfacetDistributionTexture
+}
+
+// The minimum probability of sampling either lobe. The lobe is chosen
+// before the microfacet normal is known, so it can't be chosen in
+// exact proportion to the Fresnel term.
+const _ROUGH_DIELECTRIC_MIN_LOBE_PROBABILITY float32 = 0.1
+
+func MakeRoughDielectricMaterial(
+	config map[string]interface{}) *RoughDielectricMaterial {
+	samplingMethod := MakeMicrofacetSamplingMethod(
+		config["samplingMethod"].(string))
+	eta := float32(config["eta"].(float64))
+	if eta <= 0 {
+		panic("eta must be positive")
+	}
+	var reflectance Spectrum
+	if reflectanceConfig, ok := config["reflectance"]; ok {
+		reflectance = MakeSpectrumFromConfig(
+			reflectanceConfig.(map[string]interface{}))
+	} else {
+		reflectance = MakeConstantSpectrum(1)
+	}
+	var transmittance Spectrum
+	if transmittanceConfig, ok := config["transmittance"]; ok {
+		transmittance = MakeSpectrumFromConfig(
+			transmittanceConfig.(map[string]interface{}))
+	} else {
+		transmittance = MakeConstantSpectrum(1)
+	}
+	distribution, distributionTexture :=
+		makeMicrofacetDistributionFromConfig(config)
+	typeDistribution := distribution
+	if distributionTexture != nil {
+		typeDistribution = distributionTexture.Evaluate(0, 0)
+	}
+	switch samplingMethod {
+	case MICROFACET_DISTRIBUTION_COSINE_SAMPLING:
+	case MICROFACET_VISIBLE_NORMAL_SAMPLING:
+		if !typeDistribution.CanSampleVisibleWh() {
+			panic("visible normal sampling is not supported " +
+				"for this distribution")
+		}
+	default:
+		panic("only distributionCosine and visibleNormals " +
+			"sampling are supported for rough dielectrics")
+	}
+	return &RoughDielectricMaterial{
+		samplingMethod, eta, reflectance, transmittance, distribution,
+		Vector3{}, distributionTexture,
+	}
+}
+
+func (r *RoughDielectricMaterial) EvaluateAt(
+	intersection *Intersection) Material {
+	if r.distributionTexture == nil && !r.distribution.IsAnisotropic() {
+		return r
+	}
+	evaluated := *r
+	if r.distributionTexture != nil {
+		evaluated.distribution = r.distributionTexture.Evaluate(
+			intersection.U, intersection.V)
+		evaluated.distributionTexture = nil
+	}
+	if evaluated.distribution.IsAnisotropic() {
+		evaluated.dpdu = intersection.DPDU
+	}
+	return &evaluated
+}
+
+// Converts wo and wi to the local frame around n, flipped if
+// necessary so that wo is on the +Z side, and returns them along
+// with the indices of refraction on wo's side and the other side.
+//
+// Only the Z coordinates are flipped, so that the distribution is
+// oriented the same way no matter which side it's viewed from.
+func (r *RoughDielectricMaterial) getLocalFrame(wo Vector3, n Normal3) (
+	i, j, k R3, vo R3, etaO, etaOther, zSign float32) {
+	i, j, k = makeMicrofacetFrame(n, &r.dpdu)
+	vo = convertToMicrofacetFrame(&wo, &i, &j, &k)
+	// Since normals point outside, wo is on the outside exactly
+	// when the ray that hit the surface is entering it.
+	if vo.Z > 0 {
+		return i, j, k, vo, 1, r.eta, 1
+	}
+	vo.Z = -vo.Z
+	return i, j, k, vo, r.eta, 1, -1
+}
+
+func (r *RoughDielectricMaterial) computeReflectProbability(
+	cosThO, etaO, etaOther float32) float32 {
+	F := computeFresnelDielectric(cosThO, etaO, etaOther)
+	return minFloat32(maxFloat32(F, _ROUGH_DIELECTRIC_MIN_LOBE_PROBABILITY),
+		1-_ROUGH_DIELECTRIC_MIN_LOBE_PROBABILITY)
+}
+
+// Returns f and its pdf with respect to projected solid angle, given
+// vo and vi in the local frame, with vo on the +Z side.
+func (r *RoughDielectricMaterial) computeFAndPdf(
+	transportType MaterialTransportType, vo, vi *R3,
+	etaO, etaOther float32) (f Spectrum, pdf float32) {
+	cosThO := vo.Z
+	if cosThO < _MICROFACET_COS_THETA_EPSILON {
+		return
+	}
+	cosThI := vi.Z
+	if absFloat32(cosThI) < _MICROFACET_COS_THETA_EPSILON {
+		return
+	}
+	pReflect := r.computeReflectProbability(cosThO, etaO, etaOther)
+
+	if cosThI > 0 {
+		absCosThI := cosThI
+		var vh R3
+		vh.Add(vo, vi)
+		vh.Normalize(&vh)
+		woDotWh := vo.Dot(&vh)
+		if woDotWh < _MICROFACET_COS_THETA_EPSILON {
+			return
+		}
+		F := computeFresnelDielectric(woDotWh, etaO, etaOther)
+		D := r.distribution.ComputeD(&vh)
+		G := r.distribution.ComputeG(vo, vi, &vh)
+		// f = (reflectance * F * D * G) /
+		//   (4 * |cos(th_o) * cos(th_i)|).
+		f.Scale(&r.reflectance, (F*D*G)/(4*cosThO*absCosThI))
+		pdfWh := computeMicrofacetWhPdf(
+			r.samplingMethod, r.distribution, vo, &vh)
+		pdf = pReflect * pdfWh / (4 * absCosThI * woDotWh)
+		return
+	}
+
+	absCosThI := -cosThI
+	// The generalized half vector, which points to wo's side.
+	var vh, s R3
+	vh.Scale(vo, etaO)
+	s.Scale(vi, etaOther)
+	vh.Add(&vh, &s)
+	vh.Normalize(&vh)
+	if vh.Z < 0 {
+		vh.Invert(&vh)
+	}
+	woDotWh := vo.Dot(&vh)
+	wiDotWh := vi.Dot(&vh)
+	// Both directions must be on the correct side of the
+	// microfacet.
+	if woDotWh < _MICROFACET_COS_THETA_EPSILON ||
+		wiDotWh > -_MICROFACET_COS_THETA_EPSILON {
+		return
+	}
+	absWiDotWh := -wiDotWh
+	F := computeFresnelDielectric(woDotWh, etaO, etaOther)
+	D := r.distribution.ComputeD(&vh)
+	G := r.distribution.ComputeG(vo, vi, &vh)
+	sqrtDenom := etaO*woDotWh + etaOther*wiDotWh
+	denom := sqrtDenom * sqrtDenom
+	// Radiance is compressed into a smaller solid angle when it
+	// enters a denser medium, but importance isn't, so the eta
+	// in the numerator is from the side of the direction that
+	// the quantity flows to, i.e. wo for radiance and wi for
+	// importance. (This also makes f for importance transport
+	// the adjoint of f for light transport.)
+	var etaNumerator float32
+	switch transportType {
+	case MATERIAL_LIGHT_TRANSPORT:
+		etaNumerator = etaO
+	case MATERIAL_IMPORTANCE_TRANSPORT:
+		etaNumerator = etaOther
+	}
+	// f = (transmittance * (1 - F) * D * G * |w_o * w_h| *
+	//   |w_i * w_h| * eta_n^2) /
+	//   (|cos(th_o) * cos(th_i)| *
+	//    (eta_o * (w_o * w_h) + eta_i * (w_i * w_h))^2).
+	f.Scale(&r.transmittance,
+		((1-F)*D*G*woDotWh*absWiDotWh*etaNumerator*etaNumerator)/
+			(cosThO*absCosThI*denom))
+	// The Jacobian of the map from w_h to w_i is
+	// (eta_i^2 * |w_i * w_h|) /
+	//   (eta_o * (w_o * w_h) + eta_i * (w_i * w_h))^2.
+	pdfWh := computeMicrofacetWhPdf(
+		r.samplingMethod, r.distribution, vo, &vh)
+	pdf = (1 - pReflect) * pdfWh * etaOther * etaOther * absWiDotWh /
+		(denom * absCosThI)
+	return
+}
+
+func (r *RoughDielectricMaterial) SampleWi(
+	transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32) {
+	i, j, k, vo, etaO, etaOther, zSign := r.getLocalFrame(wo, n)
+	if vo.Z < _MICROFACET_COS_THETA_EPSILON {
+		return
+	}
+
+	// Pick a lobe, and then reuse u1 to sample w_h.
+	pReflect := r.computeReflectProbability(vo.Z, etaO, etaOther)
+	shouldReflect := u1 < pReflect
+	if shouldReflect {
+		u1 /= pReflect
+	} else {
+		u1 = (u1 - pReflect) / (1 - pReflect)
+	}
+
+	var vh R3
+	switch r.samplingMethod {
+	case MICROFACET_DISTRIBUTION_COSINE_SAMPLING:
+		vh = r.distribution.SampleWh(u1, u2)
+	case MICROFACET_VISIBLE_NORMAL_SAMPLING:
+		vh = r.distribution.SampleVisibleWh(&vo, u1, u2)
+	}
+
+	woDotWh := vo.Dot(&vh)
+	if woDotWh < _MICROFACET_COS_THETA_EPSILON {
+		return
+	}
+
+	var vi R3
+	if shouldReflect {
+		vi.Scale(&vh, 2*woDotWh)
+		vi.Sub(&vi, &vo)
+		if vi.Z <= 0 {
+			return
+		}
+	} else {
+		if !((*Vector3)(&vi)).Refract(
+			(*Vector3)(&vo), (*Normal3)(&vh), etaO/etaOther) {
+			// This is total internal reflection, so
+			// there's nothing to transmit.
+			return
+		}
+		if vi.Z >= 0 {
+			return
+		}
+	}
+
+	f, pdfWi := r.computeFAndPdf(transportType, &vo, &vi, etaO, etaOther)
+	if pdfWi == 0 || f.IsBlack() {
+		return
+	}
+
+	vi.Z *= zSign
+	var viW R3
+	viW.ConvertToCoordinateSystemNoAlias(&vi, &i, &j, &k)
+	wi = Vector3(viW)
+	fDivPdf.ScaleInv(&f, pdfWi)
+	pdf = pdfWi
+	return
+}
+
+func (r *RoughDielectricMaterial) ComputeF(
+	transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) Spectrum {
+	i, j, k, vo, etaO, etaOther, zSign := r.getLocalFrame(wo, n)
+	vi := convertToMicrofacetFrame(&wi, &i, &j, &k)
+	vi.Z *= zSign
+	f, _ := r.computeFAndPdf(transportType, &vo, &vi, etaO, etaOther)
+	return f
+}
+
+func (r *RoughDielectricMaterial) ComputePdf(
+	transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) float32 {
+	i, j, k, vo, etaO, etaOther, zSign := r.getLocalFrame(wo, n)
+	vi := convertToMicrofacetFrame(&wi, &i, &j, &k)
+	vi.Z *= zSign
+	_, pdf := r.computeFAndPdf(transportType, &vo, &vi, etaO, etaOther)
+	return pdf
+}
+
+func (r *RoughDielectricMaterial) IsSpecular() bool {
+	return false
+}
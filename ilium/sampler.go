@@ -44,7 +44,7 @@ func combineSampleArrayLengths(lengths1, lengths2 []int) []int {
 	for i := 0; i < maxLen; i++ {
 		var length1, length2 int
 		if i < len(lengths1) {
-			length1 = lengths2[i]
+			length1 = lengths1[i]
 		}
 		if i < len(lengths2) {
 			length2 = lengths2[i]
@@ -0,0 +1,23 @@
+package ilium
+
+import "reflect"
+import "testing"
+
+func TestCombineSampleArrayLengths(t *testing.T) {
+	testCases := []struct {
+		lengths1, lengths2, expectedLengths []int
+	}{
+		{nil, nil, []int{}},
+		{[]int{1, 5, 2}, []int{3}, []int{3, 5, 2}},
+		{[]int{3}, []int{1, 5, 2}, []int{3, 5, 2}},
+		{[]int{4, 0}, []int{2, 6}, []int{4, 6}},
+	}
+	for _, tc := range testCases {
+		lengths := combineSampleArrayLengths(tc.lengths1, tc.lengths2)
+		if !reflect.DeepEqual(lengths, tc.expectedLengths) {
+			t.Errorf("combineSampleArrayLengths(%v, %v)=%v, "+
+				"expected %v", tc.lengths1, tc.lengths2,
+				lengths, tc.expectedLengths)
+		}
+	}
+}
@@ -1,40 +1,199 @@
 package ilium
 
+type SceneLightSelection int
+
+const (
+	// Pick each light with equal probability.
+	SCENE_UNIFORM_LIGHT_SELECTION SceneLightSelection = iota
+	// Pick each light in proportion to its power.
+	SCENE_POWER_LIGHT_SELECTION SceneLightSelection = iota
+	// Like SCENE_POWER_LIGHT_SELECTION, except that lights to
+	// sample from a point are picked in proportion to an
+	// estimate of their contribution near that point.
+	SCENE_SPATIAL_LIGHT_SELECTION SceneLightSelection = iota
+	// Like SCENE_POWER_LIGHT_SELECTION, except that lights to
+	// sample from a point are picked with a light BVH.
+	SCENE_LIGHT_BVH_LIGHT_SELECTION SceneLightSelection = iota
+)
+
+// A pointLightSampler picks lights (by index) to sample from a
+// point with normal n, which may be zero.
+type pointLightSampler interface {
+	// Returns the index of the picked light and the probability
+	// of picking it, which is 0 if no light could be picked.
+	sample(u float32, p Point3, n Normal3) (i int, pmf float32)
+	computePmf(i int, p Point3, n Normal3) float32
+}
+
 type Scene struct {
-	Aggregate         Primitive
-	Lights            []Light
+	Aggregate Primitive
+	// Lights that aren't attached to any primitive, like
+	// DirectionalLight or EnvironmentLight. These are also in
+	// Lights.
+	InfiniteLights []Light
+	Lights         []Light
+	// The distribution used to pick lights to emit from.
 	LightDistribution Distribution1D
+	LightSelection    SceneLightSelection
+
+	lightIndices map[Light]int
+	// If nil, LightDistribution is used to pick lights to sample
+	// from a point also.
+	pointLightSampler pointLightSampler
 }
 
 func MakeScene(config map[string]interface{}) Scene {
+	namedPrimitives := makeNamedPrimitives(config)
 	aggregateConfig := config["aggregate"].(map[string]interface{})
-	primitives := MakePrimitives(aggregateConfig)
+	primitives := MakePrimitives(aggregateConfig, namedPrimitives)
 	if len(primitives) != 1 {
 		panic("aggregate must be a single primitive")
 	}
 	aggregate := primitives[0]
-	lights := aggregate.GetLights()
+	scene := Scene{Aggregate: aggregate}
+	sceneBound := scene.WorldBound()
+	infiniteLights := []Light{}
+	if infiniteLightsConfig, ok :=
+		config["infiniteLights"].([]interface{}); ok {
+		for _, o := range infiniteLightsConfig {
+			light := MakeInfiniteLight(
+				o.(map[string]interface{}), sceneBound)
+			infiniteLights = append(infiniteLights, light)
+		}
+	}
+	lights := []Light{}
+	lights = append(lights, aggregate.GetLights()...)
+	lights = append(lights, infiniteLights...)
+
+	lightSelection := SCENE_UNIFORM_LIGHT_SELECTION
+	if lightSelectionConfig, ok := config["lightSelection"]; ok {
+		switch lightSelectionConfig.(string) {
+		case "uniform":
+			lightSelection = SCENE_UNIFORM_LIGHT_SELECTION
+		case "power":
+			lightSelection = SCENE_POWER_LIGHT_SELECTION
+		case "spatial":
+			lightSelection = SCENE_SPATIAL_LIGHT_SELECTION
+		case "lightBVH":
+			lightSelection = SCENE_LIGHT_BVH_LIGHT_SELECTION
+		default:
+			panic("unknown light selection " +
+				lightSelectionConfig.(string))
+		}
+	}
+
 	lightWeights := make([]float32, len(lights))
-	// TODO(akalin): Use better weights, like each light's
-	// estimated power.
+	lightIndices := make(map[Light]int)
+	var totalWeight float32
 	for i := 0; i < len(lights); i++ {
 		lightWeights[i] = 1
+		if lightSelection != SCENE_UNIFORM_LIGHT_SELECTION {
+			power := lights[i].Power()
+			lightWeights[i] = power.Y()
+		}
+		totalWeight += lightWeights[i]
+		lightIndices[lights[i]] = i
+	}
+	if totalWeight <= 0 {
+		// Fall back to uniform weights if nothing emits
+		// any light.
+		for i := 0; i < len(lights); i++ {
+			lightWeights[i] = 1
+		}
 	}
-	lightsDistribution := MakeDistribution1D(lightWeights)
-	return Scene{aggregate, lights, lightsDistribution}
+
+	scene.InfiniteLights = infiniteLights
+	scene.Lights = lights
+	scene.LightDistribution = MakeDistribution1D(lightWeights)
+	scene.LightSelection = lightSelection
+	scene.lightIndices = lightIndices
+	switch lightSelection {
+	case SCENE_SPATIAL_LIGHT_SELECTION:
+		scene.pointLightSampler =
+			makeSpatialLightDistribution(lights, sceneBound)
+	case SCENE_LIGHT_BVH_LIGHT_SELECTION:
+		scene.pointLightSampler = makeLightBVHSampler(lights)
+	}
+	return scene
+}
+
+// Returns the bounding box of everything in the scene except for
+// the infinite lights.
+func (scene *Scene) WorldBound() BBox {
+	return scene.Aggregate.WorldBound()
 }
 
+// Builds the primitives listed under "namedPrimitives", in order, so
+// that each one can refer to the ones before it. A named config that
+// makes more than one primitive is wrapped in a BVHAggregate.
+func makeNamedPrimitives(
+	config map[string]interface{}) map[string]Primitive {
+	namedPrimitives := make(map[string]Primitive)
+	namedPrimitivesConfig, ok := config["namedPrimitives"].([]interface{})
+	if !ok {
+		return namedPrimitives
+	}
+	for _, o := range namedPrimitivesConfig {
+		namedPrimitiveConfig := o.(map[string]interface{})
+		name := namedPrimitiveConfig["name"].(string)
+		if _, ok := namedPrimitives[name]; ok {
+			panic("duplicate named primitive " + name)
+		}
+		primitiveConfig :=
+			namedPrimitiveConfig["primitive"].(map[string]interface{})
+		primitives := MakePrimitives(primitiveConfig, namedPrimitives)
+		if len(primitives) == 1 {
+			namedPrimitives[name] = primitives[0]
+		} else {
+			namedPrimitives[name] =
+				MakeBVHAggregateFromPrimitives(primitives, 4)
+		}
+	}
+	return namedPrimitives
+}
+
+// Picks a light to emit from.
 func (scene *Scene) SampleLight(u float32) (light Light, pChooseLight float32) {
 	i, pChooseLight := scene.LightDistribution.SampleDiscrete(u)
 	light = scene.Lights[i]
 	return
 }
 
+// Returns the probability that SampleLight() picks the given light.
 func (scene *Scene) ComputeLightPdf(light Light) float32 {
-	for i := 0; i < len(scene.Lights); i++ {
-		if scene.Lights[i] == light {
-			return scene.LightDistribution.ComputeDiscretePdf(i)
-		}
+	i, ok := scene.lightIndices[light]
+	if !ok {
+		return 0
+	}
+	return scene.LightDistribution.ComputeDiscretePdf(i)
+}
+
+// Picks a light to sample from the given point with normal n (which
+// may be zero). Returns a nil light and 0 if no light could be
+// picked.
+func (scene *Scene) SampleLightFromPoint(u float32, p Point3, n Normal3) (
+	light Light, pChooseLight float32) {
+	if scene.pointLightSampler == nil {
+		return scene.SampleLight(u)
+	}
+	i, pChooseLight := scene.pointLightSampler.sample(u, p, n)
+	if pChooseLight == 0 {
+		return nil, 0
+	}
+	light = scene.Lights[i]
+	return
+}
+
+// Returns the probability that SampleLightFromPoint() picks the
+// given light from the given point with normal n.
+func (scene *Scene) ComputeLightPdfFromPoint(
+	light Light, p Point3, n Normal3) float32 {
+	if scene.pointLightSampler == nil {
+		return scene.ComputeLightPdf(light)
+	}
+	i, ok := scene.lightIndices[light]
+	if !ok {
+		return 0
 	}
-	return 0
+	return scene.pointLightSampler.computePmf(i, p, n)
 }
@@ -266,3 +266,7 @@ func (sm *SensorMaterial) ComputePdf(transportType MaterialTransportType,
 	wo, wi Vector3, n Normal3) float32 {
 	return sm.sensor.ComputeWeDirectionalPdf(sm.x, sm.y, sm.pSurface, n, wi)
 }
+
+func (sm *SensorMaterial) IsSpecular() bool {
+	return false
+}
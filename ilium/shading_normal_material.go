@@ -0,0 +1,98 @@
+package ilium
+
+// A shadingNormalMaterial evaluates a material with respect to a
+// shading normal instead of the geometric normal passed to its
+// methods, while still returning BSDF values and pdfs with respect
+// to projected solid angle around the geometric normal, which is
+// what the tracers assume.
+//
+// Integrating with respect to projected solid angle around the
+// geometric normal n_g instead of the shading normal n_s scales the
+// BSDF by |w_i . n_s| / |w_i . n_g| for light transport. Since using
+// a shading normal makes the BSDF non-symmetric, the adjoint BSDF
+// used for importance transport is scaled by |w_o . n_s| / |w_o .
+// n_g| instead, as described in section 5.3 of Veach's thesis.
+//
+// To avoid light leaks, directions that are on different sides of
+// the surface with respect to the two normals are treated as having
+// zero BSDF.
+type shadingNormalMaterial struct {
+	material Material
+	nShading Normal3
+}
+
+// Returns whether w is on the same side of the surface with respect
+// to both n and nShading.
+func (s *shadingNormalMaterial) isConsistent(w *Vector3, n *Normal3) bool {
+	return w.DotNormal(n)*w.DotNormal(&s.nShading) > 0
+}
+
+func (s *shadingNormalMaterial) SampleWi(
+	transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32) {
+	wi, fDivPdf, pdf, _ = s.SampleWiWithSpecular(
+		transportType, u1, u2, wo, n)
+	return
+}
+
+func (s *shadingNormalMaterial) SampleWiWithSpecular(
+	transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32, isSpecular bool) {
+	if !s.isConsistent(&wo, &n) {
+		return
+	}
+	wi, fDivPdf, pdf, isSpecular = sampleMaterialWi(
+		s.material, transportType, u1, u2, wo, s.nShading)
+	if pdf == 0 || !s.isConsistent(&wi, &n) {
+		return Vector3{}, Spectrum{}, 0, false
+	}
+	absCosThIG := absFloat32(wi.DotNormal(&n))
+	absCosThIS := absFloat32(wi.DotNormal(&s.nShading))
+	// For light transport, f and the pdf are scaled by the same
+	// factor, so fDivPdf doesn't change.
+	if transportType == MATERIAL_IMPORTANCE_TRANSPORT {
+		absCosThOG := absFloat32(wo.DotNormal(&n))
+		absCosThOS := absFloat32(wo.DotNormal(&s.nShading))
+		fDivPdf.Scale(&fDivPdf,
+			(absCosThOS*absCosThIG)/(absCosThOG*absCosThIS))
+	}
+	// Specular samples have a discrete probability, which
+	// doesn't depend on the measure.
+	if !isSpecular {
+		pdf *= absCosThIS / absCosThIG
+	}
+	return
+}
+
+func (s *shadingNormalMaterial) ComputeF(
+	transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) Spectrum {
+	if !s.isConsistent(&wo, &n) || !s.isConsistent(&wi, &n) {
+		return Spectrum{}
+	}
+	f := s.material.ComputeF(transportType, wo, wi, s.nShading)
+	w := &wi
+	if transportType == MATERIAL_IMPORTANCE_TRANSPORT {
+		w = &wo
+	}
+	f.Scale(&f, absFloat32(w.DotNormal(&s.nShading))/
+		absFloat32(w.DotNormal(&n)))
+	return f
+}
+
+func (s *shadingNormalMaterial) ComputePdf(
+	transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) float32 {
+	if !s.isConsistent(&wo, &n) || !s.isConsistent(&wi, &n) {
+		return 0
+	}
+	pdf := s.material.ComputePdf(transportType, wo, wi, s.nShading)
+	return pdf * absFloat32(wi.DotNormal(&s.nShading)) /
+		absFloat32(wi.DotNormal(&n))
+}
+
+func (s *shadingNormalMaterial) IsSpecular() bool {
+	return s.material.IsSpecular()
+}
@@ -0,0 +1,136 @@
+package ilium
+
+// The offset in u and v used to estimate the derivatives of bump
+// maps with finite differences.
+const _BUMP_MAP_DELTA float32 = 5e-4
+
+// A shadingNormalPerturbation perturbs the shading normal of a
+// surface with either a bump map, i.e. a texture of heights along
+// the shading normal (scaled by bumpScale), or a tangent-space normal
+// map, i.e. a texture of normals (with their coordinates mapped from
+// [-1, 1] to [0, 1]) in the frame of dP/du, dP/dv, and the shading
+// normal.
+//
+// Normal maps usually need to have a linear encoding (see
+// ImageTexture).
+type shadingNormalPerturbation struct {
+	bumpTexture   SpectrumTexture
+	bumpScale     float32
+	normalTexture SpectrumTexture
+}
+
+// Reads the optional "bumpMap" (along with "bumpScale") or
+// "normalMap" texture from the given material config, and returns
+// nil if there are neither.
+func makeShadingNormalPerturbationFromConfig(
+	config map[string]interface{}) *shadingNormalPerturbation {
+	bumpConfig, hasBump := config["bumpMap"]
+	normalConfig, hasNormal := config["normalMap"]
+	switch {
+	case hasBump && hasNormal:
+		panic("only one of bumpMap and normalMap can be given")
+	case hasBump:
+		bumpTexture := MakeSpectrumTexture(
+			bumpConfig.(map[string]interface{}))
+		var bumpScale float32 = 1
+		if bumpScaleConfig, ok := config["bumpScale"]; ok {
+			bumpScale = float32(bumpScaleConfig.(float64))
+		}
+		return &shadingNormalPerturbation{
+			bumpTexture: bumpTexture,
+			bumpScale:   bumpScale,
+		}
+	case hasNormal:
+		normalTexture := MakeSpectrumTexture(
+			normalConfig.(map[string]interface{}))
+		return &shadingNormalPerturbation{normalTexture: normalTexture}
+	}
+	return nil
+}
+
+// Returns the perturbed shading normal at the given intersection. If
+// the perturbed normal is degenerate or isn't on the same side as
+// the geometric normal, returns the unperturbed one instead.
+func (snp *shadingNormalPerturbation) computeShadingNormal(
+	intersection *Intersection) Normal3 {
+	var nPerturbed Normal3
+	if snp.bumpTexture != nil {
+		nPerturbed = snp.computeBumpedNormal(intersection)
+	} else {
+		nPerturbed = snp.computeMappedNormal(intersection)
+	}
+	nShading := &intersection.ShadingN
+	if ((*R3)(&nPerturbed)).NormSq() == 0 {
+		return *nShading
+	}
+	nPerturbed.Normalize(&nPerturbed)
+	if ((*R3)(&nPerturbed)).Dot((*R3)(&intersection.N)) <= 0 {
+		return *nShading
+	}
+	return nPerturbed
+}
+
+func (snp *shadingNormalPerturbation) evaluateHeight(u, v float32) float32 {
+	return snp.bumpScale * evaluateFloatTexture(snp.bumpTexture, u, v)
+}
+
+// Returns the unnormalized normal of the surface displaced along the
+// shading normal by the bump map, ignoring the change in the shading
+// normal itself, on the same side as the shading normal.
+func (snp *shadingNormalPerturbation) computeBumpedNormal(
+	intersection *Intersection) Normal3 {
+	u := intersection.U
+	v := intersection.V
+	h := snp.evaluateHeight(u, v)
+	dhdu := (snp.evaluateHeight(u+_BUMP_MAP_DELTA, v) - h) /
+		_BUMP_MAP_DELTA
+	dhdv := (snp.evaluateHeight(u, v+_BUMP_MAP_DELTA) - h) /
+		_BUMP_MAP_DELTA
+
+	// dP'/du = dP/du + dh/du * n_s, and similarly for v.
+	nShading := (*Vector3)(&intersection.ShadingN)
+	var dpdu, dpdv, offset Vector3
+	offset.Scale(nShading, dhdu)
+	dpdu.Add(&intersection.DPDU, &offset)
+	offset.Scale(nShading, dhdv)
+	dpdv.Add(&intersection.DPDV, &offset)
+
+	var nBumped Normal3
+	nBumped.CrossVectorNoAlias(&dpdu, &dpdv)
+	if ((*Vector3)(&nBumped)).Dot(nShading) < 0 {
+		nBumped.Flip(&nBumped)
+	}
+	return nBumped
+}
+
+// Returns the unnormalized normal from the normal map, converted
+// from the tangent frame.
+func (snp *shadingNormalPerturbation) computeMappedNormal(
+	intersection *Intersection) Normal3 {
+	s := snp.normalTexture.Evaluate(intersection.U, intersection.V)
+	r, g, b := s.ToRGB()
+	vTangent := R3{2*r - 1, 2*g - 1, 2*b - 1}
+
+	// Make an orthonormal frame with dP/du projected onto the
+	// plane of the shading normal, and the bitangent on the same
+	// side as dP/dv.
+	k := R3(intersection.ShadingN)
+	var i, j, parallel R3
+	dpdu := R3(intersection.DPDU)
+	parallel.Scale(&k, dpdu.Dot(&k))
+	i.Sub(&dpdu, &parallel)
+	if i.NormSq() == 0 {
+		MakeCoordinateSystemNoAlias(&k, &i, &j)
+	} else {
+		i.Normalize(&i)
+		j.CrossNoAlias(&k, &i)
+		dpdv := R3(intersection.DPDV)
+		if j.Dot(&dpdv) < 0 {
+			j.Invert(&j)
+		}
+	}
+
+	var w R3
+	w.ConvertToCoordinateSystemNoAlias(&vTangent, &i, &j, &k)
+	return Normal3(w)
+}
@@ -6,6 +6,9 @@ type Shape interface {
 	// intersection is not nil, also fills in that intersection.
 	Intersect(ray *Ray, intersection *Intersection) bool
 
+	// Returns the bounding box of the shape in world space.
+	WorldBound() BBox
+
 	// Returns the surface area of the shape.
 	SurfaceArea() float32
 
@@ -45,6 +48,62 @@ type Shape interface {
 		p Point3, pEpsilon float32, n Normal3, wi Vector3) float32
 }
 
+// Shapes that may be tagged with the name of a material group (e.g.,
+// from a usemtl statement in an OBJ file) implement
+// MaterialGroupShape.
+type MaterialGroupShape interface {
+	Shape
+
+	// Returns the name of the material group of the shape, or
+	// the empty string if it has none.
+	GetMaterialGroup() string
+}
+
+// Shapes whose surface normals all lie within a cone implement
+// NormalBoundedShape, which lets lights pick them based on their
+// orientation. Shapes that don't are assumed to have normals in every
+// direction.
+type NormalBoundedShape interface {
+	Shape
+
+	// Returns the axis of and the cosine of the half-angle of a
+	// cone that contains every surface normal of the shape.
+	GetNormalBound() (axis Vector3, cosTheta float32)
+}
+
+// Shapes with a 2D parametrization (u, v) of their surface, e.g. for
+// looking up textures, implement UVShape.
+type UVShape interface {
+	Shape
+
+	// Returns the (u, v) coordinates of the given point, which
+	// is assumed to be on the surface, and whether the point
+	// actually is on the surface, up to a tolerance.
+	ComputeSurfaceUV(pSurface Point3) (u, v float32, ok bool)
+}
+
+// UVShapes whose parametrization covers all of [0, 1)^2 implement
+// UVSampleableShape, which lets points on them be sampled via (u, v).
+type UVSampleableShape interface {
+	UVShape
+
+	// Returns the point with the given (u, v) coordinates, an
+	// epsilon to use for rays starting or ending at that point,
+	// the normal at that point, and the surface area per unit
+	// (u, v) area at that point, i.e. |dP/du x dP/dv|.
+	ComputeSurfaceFromUV(u, v float32) (
+		pSurface Point3, pSurfaceEpsilon float32,
+		nSurface Normal3, areaPerUV float32)
+}
+
+func getShapeNormalCone(s Shape) directionCone {
+	if nbs, ok := s.(NormalBoundedShape); ok {
+		axis, cosTheta := nbs.GetNormalBound()
+		return directionCone{axis, cosTheta}
+	}
+	return makeEntireSphereDirectionCone()
+}
+
 func SampleEntireSurfaceFromPoint(
 	s Shape, u1, u2 float32, p Point3, pEpsilon float32, n Normal3) (
 	pSurface Point3, pSurfaceEpsilon float32,
@@ -5,6 +5,9 @@ type shapeSet struct {
 	shapeAreas            []float32
 	totalArea             float32
 	shapeAreaDistribution Distribution1D
+	// If non-nil, used instead of shapeAreaDistribution to pick
+	// shapes to sample from a point.
+	lightBVH *lightBVH
 }
 
 func MakeShapeSet(shapes []Shape) shapeSet {
@@ -16,7 +19,55 @@ func MakeShapeSet(shapes []Shape) shapeSet {
 		totalArea += area
 	}
 	shapeAreaDistribution := MakeDistribution1D(shapeAreas)
-	return shapeSet{shapes, shapeAreas, totalArea, shapeAreaDistribution}
+	return shapeSet{
+		shapes:                shapes,
+		shapeAreas:            shapeAreas,
+		totalArea:             totalArea,
+		shapeAreaDistribution: shapeAreaDistribution,
+	}
+}
+
+// Makes SampleSurfaceFromPoint() pick shapes with a light BVH
+// instead of by area, assuming that every point on the shape set
+// emits the same radiance, within acos(cosThetaE) of its normal (and
+// on both sides, if twoSided is set).
+func (ss *shapeSet) buildLightBVH(cosThetaE float32, twoSided bool) {
+	shapeBounds := make([]lightBounds, len(ss.shapes))
+	for i := 0; i < len(ss.shapes); i++ {
+		shapeBounds[i] = ss.getShapeLightBounds(
+			i, ss.shapeAreas[i], cosThetaE, twoSided)
+	}
+	ss.lightBVH = makeLightBVH(shapeBounds)
+}
+
+func (ss *shapeSet) getShapeLightBounds(
+	i int, power, cosThetaE float32, twoSided bool) lightBounds {
+	shape := ss.shapes[i]
+	return lightBounds{
+		bound:      shape.WorldBound(),
+		power:      power,
+		normalCone: getShapeNormalCone(shape),
+		cosThetaE:  cosThetaE,
+		twoSided:   twoSided,
+	}
+}
+
+// Returns the bounds of the emission of the entire shape set, with
+// the given total power. See buildLightBVH() for the other
+// parameters.
+func (ss *shapeSet) getLightBounds(
+	power, cosThetaE float32, twoSided bool) lightBounds {
+	var bounds lightBounds
+	if ss.totalArea == 0 {
+		return bounds
+	}
+	for i := 0; i < len(ss.shapes); i++ {
+		shapeBounds := ss.getShapeLightBounds(
+			i, power*ss.shapeAreas[i]/ss.totalArea,
+			cosThetaE, twoSided)
+		bounds = unionLightBounds(&bounds, &shapeBounds)
+	}
+	return bounds
 }
 
 // Samples the surface of the shape set uniformly and returns the
@@ -26,6 +77,16 @@ func MakeShapeSet(shapes []Shape) shapeSet {
 func (ss *shapeSet) SampleSurface(u, v1, v2 float32) (
 	pSurface Point3, pSurfaceEpsilon float32,
 	nSurface Normal3, pdfSurfaceArea float32) {
+	_, pSurface, pSurfaceEpsilon, nSurface, pdfSurfaceArea =
+		ss.sampleShapeSurface(u, v1, v2)
+	return
+}
+
+// Like SampleSurface(), but also returns the index of the shape
+// containing the sampled point.
+func (ss *shapeSet) sampleShapeSurface(u, v1, v2 float32) (
+	i int, pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, pdfSurfaceArea float32) {
 	i, pShape := ss.shapeAreaDistribution.SampleDiscrete(u)
 	shape := ss.shapes[i]
 	pSurface, pSurfaceEpsilon, nSurface, pdfShape :=
@@ -53,7 +114,26 @@ func (ss *shapeSet) SampleSurfaceFromPoint(
 	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
 	pSurface Point3, pSurfaceEpsilon float32,
 	nSurface Normal3, pdfProjectedSolidAngle float32) {
-	i, pShape := ss.shapeAreaDistribution.SampleDiscrete(u)
+	_, pSurface, pSurfaceEpsilon, nSurface, pdfProjectedSolidAngle =
+		ss.sampleShapeSurfaceFromPoint(u, v1, v2, p, pEpsilon, n)
+	return
+}
+
+// Like SampleSurfaceFromPoint(), but also returns the index of the
+// shape containing the sampled point.
+func (ss *shapeSet) sampleShapeSurfaceFromPoint(
+	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
+	i int, pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, pdfProjectedSolidAngle float32) {
+	var pShape float32
+	if ss.lightBVH != nil {
+		i, pShape = ss.lightBVH.sample(u, p, n)
+		if pShape == 0 {
+			return
+		}
+	} else {
+		i, pShape = ss.shapeAreaDistribution.SampleDiscrete(u)
+	}
 	shape := ss.shapes[i]
 	pSurface, pSurfaceEpsilon, nSurface, pdfShape :=
 		shape.SampleSurfaceFromPoint(v1, v2, p, pEpsilon, n)
@@ -82,7 +162,20 @@ func (ss *shapeSet) ComputePdfFromPoint(
 	}
 	// The given direction may hit multiple shapes if some shapes
 	// occlude others, so compute the pdf from all shapes and
-	// weigh each by the shape's area.
+	// weigh each by the probability of picking it.
+	if ss.lightBVH != nil {
+		// Only shapes whose bounds the ray hits can
+		// contribute.
+		ray := Ray{p, wi, pEpsilon, infFloat32(+1)}
+		var weightedPdf float32 = 0
+		ss.lightBVH.visitEmittersAlongRay(&ray, n,
+			func(i int, pShape float32) {
+				shapePdf := ss.shapes[i].ComputePdfFromPoint(
+					p, pEpsilon, n, wi)
+				weightedPdf += pShape * shapePdf
+			})
+		return weightedPdf
+	}
 	var weightedPdf float32 = 0
 	for i := 0; i < len(ss.shapes); i++ {
 		shape := ss.shapes[i]
@@ -92,3 +185,44 @@ func (ss *shapeSet) ComputePdfFromPoint(
 	}
 	return weightedPdf / ss.totalArea
 }
+
+// Returns the (u, v) coordinates of the given point, which is assumed
+// to be on the surface of the shape with the given index, which must
+// be a UVShape.
+func (ss *shapeSet) computeShapeSurfaceUV(
+	i int, pSurface Point3) (u, v float32) {
+	u, v, _ = ss.shapes[i].(UVShape).ComputeSurfaceUV(pSurface)
+	return
+}
+
+// Like computeShapeSurfaceUV(), but for when the shape containing
+// the point isn't known. All shapes must be UVShapes.
+//
+// With more than one shape, this looks for the shape containing the
+// point, which takes time linear in the number of shapes, so this
+// should be avoided when the shape or the (u, v) coordinates are
+// already known.
+func (ss *shapeSet) computeSurfaceUV(pSurface Point3) (u, v float32) {
+	if len(ss.shapes) == 1 {
+		return ss.computeShapeSurfaceUV(0, pSurface)
+	}
+	found := false
+	for i := 0; i < len(ss.shapes); i++ {
+		bound := ss.shapes[i].WorldBound()
+		if !bound.ContainsPointWithTolerance(&pSurface, 1e-3) {
+			continue
+		}
+		uShape, vShape, ok :=
+			ss.shapes[i].(UVShape).ComputeSurfaceUV(pSurface)
+		if ok {
+			return uShape, vShape
+		}
+		// Fall back to the first nearby shape in case of
+		// floating point inaccuracies.
+		if !found {
+			u, v = uShape, vShape
+			found = true
+		}
+	}
+	return
+}
@@ -0,0 +1,211 @@
+package ilium
+
+import "math"
+
+// The coefficients A through E of the Perez sky luminance
+// distribution.
+type perezCoefficients [5]float32
+
+func makePerezCoefficients(turbidity float32, m [5][2]float32) (
+	c perezCoefficients) {
+	for i := 0; i < 5; i++ {
+		c[i] = m[i][0]*turbidity + m[i][1]
+	}
+	return
+}
+
+// Returns the Perez function at the given angle theta from the
+// zenith and angle gamma from the sun.
+func (c *perezCoefficients) evaluate(cosTheta, gamma float32) float32 {
+	_, cosGamma := sincosFloat32(gamma)
+	return (1 + c[0]*expFloat32(c[1]/cosTheta)) *
+		(1 + c[2]*expFloat32(c[3]*gamma) + c[4]*cosGamma*cosGamma)
+}
+
+// A preethamSky is an environmentRadiance given by the analytic
+// daylight model from "A Practical Analytic Model for Daylight" by
+// Preetham, Shirley, and Smits. Z is up, the radiance below the
+// horizon is black, and the sun itself isn't included (a
+// DirectionalLight can be used for that).
+//
+// Since the model is hard to sample directly, directions are sampled
+// from a tabulated copy of it.
+type preethamSky struct {
+	sunDirection Vector3
+	thetaSun     float32
+	scale        Spectrum
+
+	// The Perez coefficients and zenith values for the luminance
+	// Y and the chromaticities x and y.
+	perezLuminance  perezCoefficients
+	perezX          perezCoefficients
+	perezY          perezCoefficients
+	zenithLuminance float32
+	zenithX         float32
+	zenithY         float32
+
+	table *environmentMap
+}
+
+const (
+	_PREETHAM_SKY_TABLE_WIDTH  = 128
+	_PREETHAM_SKY_TABLE_HEIGHT = 64
+)
+
+// Makes an environment light from the Preetham sky model, given the
+// direction towards the sun and the turbidity of the atmosphere.
+// The radiance is in units of kcd/m^2 times the optional scale.
+func MakeSkyLight(
+	config map[string]interface{}, sceneBound BBox) *EnvironmentLight {
+	sunDirection := MakeVector3FromConfig(config["sunDirection"])
+	sunDirection.Normalize(&sunDirection)
+	if sunDirection.Z <= 0 {
+		panic("sunDirection must be above the horizon")
+	}
+	// The model was only fitted for turbidities in [2, 10].
+	turbidity := float32(config["turbidity"].(float64))
+	if turbidity < 2 || turbidity > 10 {
+		panic("turbidity must be in [2, 10]")
+	}
+	scale := MakeConstantSpectrum(1)
+	if scaleConfig, ok := config["scale"]; ok {
+		scale = MakeSpectrumFromConfig(
+			scaleConfig.(map[string]interface{}))
+	}
+	sky := makePreethamSky(sunDirection, turbidity, scale)
+	return makeEnvironmentLight(sky, sceneBound)
+}
+
+func makePreethamSky(
+	sunDirection Vector3, turbidity float32,
+	scale Spectrum) *preethamSky {
+	thetaSun := float32(math.Acos(float64(sunDirection.Z)))
+	T := turbidity
+
+	perezLuminance := makePerezCoefficients(T, [5][2]float32{
+		{0.1787, -1.4630},
+		{-0.3554, 0.4275},
+		{-0.0227, 5.3251},
+		{0.1206, -2.5771},
+		{-0.0670, 0.3703},
+	})
+	perezX := makePerezCoefficients(T, [5][2]float32{
+		{-0.0193, -0.2592},
+		{-0.0665, 0.0008},
+		{-0.0004, 0.2125},
+		{-0.0641, -0.8989},
+		{-0.0033, 0.0452},
+	})
+	perezY := makePerezCoefficients(T, [5][2]float32{
+		{-0.0167, -0.2608},
+		{-0.0950, 0.0092},
+		{-0.0079, 0.2102},
+		{-0.0441, -1.6537},
+		{-0.0109, 0.0529},
+	})
+
+	chi := (4.0/9.0 - T/120) * (math.Pi - 2*thetaSun)
+	zenithLuminance := (4.0453*T-4.9710)*tanFloat32(chi) -
+		0.2155*T + 2.4192
+
+	// The zenith chromaticities are given by
+	// [T^2 T 1] * M * [thetaSun^3 thetaSun^2 thetaSun 1]^T.
+	computeZenithChromaticity := func(m [3][4]float32) float32 {
+		ts := [4]float32{
+			thetaSun * thetaSun * thetaSun,
+			thetaSun * thetaSun,
+			thetaSun,
+			1,
+		}
+		tt := [3]float32{T * T, T, 1}
+		var c float32
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 4; j++ {
+				c += tt[i] * m[i][j] * ts[j]
+			}
+		}
+		return c
+	}
+	zenithX := computeZenithChromaticity([3][4]float32{
+		{0.00166, -0.00375, 0.00209, 0},
+		{-0.02903, 0.06377, -0.03202, 0.00394},
+		{0.11693, -0.21196, 0.06052, 0.25886},
+	})
+	zenithY := computeZenithChromaticity([3][4]float32{
+		{0.00275, -0.00610, 0.00317, 0},
+		{-0.04214, 0.08970, -0.04153, 0.00516},
+		{0.15346, -0.26756, 0.06670, 0.26688},
+	})
+
+	sky := &preethamSky{
+		sunDirection:    sunDirection,
+		thetaSun:        thetaSun,
+		scale:           scale,
+		perezLuminance:  perezLuminance,
+		perezX:          perezX,
+		perezY:          perezY,
+		zenithLuminance: zenithLuminance,
+		zenithX:         zenithX,
+		zenithY:         zenithY,
+	}
+
+	width := _PREETHAM_SKY_TABLE_WIDTH
+	height := _PREETHAM_SKY_TABLE_HEIGHT
+	radiances := make([]Spectrum, width*height)
+	for y := 0; y < height; y++ {
+		v := (float32(y) + 0.5) / float32(height)
+		for x := 0; x < width; x++ {
+			u := (float32(x) + 0.5) / float32(width)
+			w, _ := equirectangularUVToDirection(u, v)
+			radiances[y*width+x] = sky.computeRadiance(&w)
+		}
+	}
+	sky.table = makeEnvironmentMap(width, height, radiances)
+	return sky
+}
+
+// Returns the value at the given direction of the quantity with
+// the given Perez coefficients and zenith value.
+func (ps *preethamSky) computePerez(c *perezCoefficients, zenith float32,
+	cosTheta, gamma float32) float32 {
+	return zenith * c.evaluate(cosTheta, gamma) /
+		c.evaluate(1, ps.thetaSun)
+}
+
+func (ps *preethamSky) computeRadiance(w *Vector3) Spectrum {
+	cosTheta := w.Z
+	if cosTheta <= 0 {
+		return Spectrum{}
+	}
+	cosGamma := minFloat32(maxFloat32(w.Dot(&ps.sunDirection), -1), 1)
+	gamma := float32(math.Acos(float64(cosGamma)))
+
+	Y := ps.computePerez(
+		&ps.perezLuminance, ps.zenithLuminance, cosTheta, gamma)
+	x := ps.computePerez(&ps.perezX, ps.zenithX, cosTheta, gamma)
+	y := ps.computePerez(&ps.perezY, ps.zenithY, cosTheta, gamma)
+	if Y <= 0 || y <= 0 {
+		return Spectrum{}
+	}
+
+	radiance := MakeXYZSpectrum(x/y*Y, Y, (1-x-y)/y*Y)
+	// Clamp any components that are out of gamut.
+	r, g, b := radiance.ToRGB()
+	radiance = MakeRGBSpectrum(
+		maxFloat32(r, 0), maxFloat32(g, 0), maxFloat32(b, 0))
+	radiance.Mul(&radiance, &ps.scale)
+	return radiance
+}
+
+func (ps *preethamSky) sampleDirection(u1, u2 float32) (
+	w Vector3, pdf float32) {
+	return ps.table.sampleDirection(u1, u2)
+}
+
+func (ps *preethamSky) computeDirectionPdf(w *Vector3) float32 {
+	return ps.table.computeDirectionPdf(w)
+}
+
+func (ps *preethamSky) computeIntegral() Spectrum {
+	return ps.table.computeIntegral()
+}
@@ -0,0 +1,161 @@
+package ilium
+
+import "math/rand"
+import "sync"
+
+// A spatialLightDistribution picks lights to sample from a point in
+// proportion to an estimate of how much each one contributes to the
+// points near it, which helps scenes with many lights that each only
+// light a small part of the scene.
+//
+// The scene's bounding box is divided into a grid of voxels, and the
+// distribution for each voxel is computed the first time it's
+// needed, by sampling each light from random points (with random
+// normals) in the voxel and ignoring visibility.
+type spatialLightDistribution struct {
+	lights     []Light
+	bound      BBox
+	resolution [3]int
+
+	// Guards distributions, which maps voxel indices to
+	// distributions.
+	mutex         sync.RWMutex
+	distributions map[int]*Distribution1D
+}
+
+const (
+	_SPATIAL_LIGHT_DISTRIBUTION_MAX_RESOLUTION = 16
+	_SPATIAL_LIGHT_DISTRIBUTION_SAMPLE_COUNT   = 64
+)
+
+func makeSpatialLightDistribution(
+	lights []Light, bound BBox) *spatialLightDistribution {
+	// Make the voxels roughly cubical, with the widest axis
+	// having the maximum resolution.
+	diagonal := bound.GetDiagonal()
+	maxExtent := maxFloat32(
+		maxFloat32(diagonal.X, diagonal.Y), diagonal.Z)
+	var resolution [3]int
+	for i, extent := range [3]float32{diagonal.X, diagonal.Y, diagonal.Z} {
+		n := 1
+		if maxExtent > 0 {
+			n = int(extent / maxExtent *
+				_SPATIAL_LIGHT_DISTRIBUTION_MAX_RESOLUTION)
+		}
+		resolution[i] = maxInt(n, 1)
+	}
+	return &spatialLightDistribution{
+		lights:        lights,
+		bound:         bound,
+		resolution:    resolution,
+		distributions: make(map[int]*Distribution1D),
+	}
+}
+
+// Returns the grid coordinates of the voxel containing p, clamped
+// to the grid.
+func (sld *spatialLightDistribution) getVoxel(p Point3) (voxel [3]int) {
+	pMin := [3]float32{sld.bound.PMin.X, sld.bound.PMin.Y, sld.bound.PMin.Z}
+	pMax := [3]float32{sld.bound.PMax.X, sld.bound.PMax.Y, sld.bound.PMax.Z}
+	pArr := [3]float32{p.X, p.Y, p.Z}
+	for i := 0; i < 3; i++ {
+		n := sld.resolution[i]
+		var t float32
+		if pMax[i] > pMin[i] {
+			t = (pArr[i] - pMin[i]) / (pMax[i] - pMin[i])
+		}
+		voxel[i] = minInt(maxInt(int(t*float32(n)), 0), n-1)
+	}
+	return
+}
+
+func (sld *spatialLightDistribution) computeVoxelDistribution(
+	voxel [3]int, voxelIndex int) *Distribution1D {
+	// Use a fixed seed per voxel so that the distributions don't
+	// depend on the order in which voxels are visited.
+	rng := rand.New(rand.NewSource(int64(voxelIndex)))
+	diagonal := sld.bound.GetDiagonal()
+	voxelSize := Vector3{
+		diagonal.X / float32(sld.resolution[0]),
+		diagonal.Y / float32(sld.resolution[1]),
+		diagonal.Z / float32(sld.resolution[2]),
+	}
+	contributions := make([]float32, len(sld.lights))
+	for j := 0; j < _SPATIAL_LIGHT_DISTRIBUTION_SAMPLE_COUNT; j++ {
+		p := Point3{
+			sld.bound.PMin.X + voxelSize.X*
+				(float32(voxel[0])+randFloat32(rng)),
+			sld.bound.PMin.Y + voxelSize.Y*
+				(float32(voxel[1])+randFloat32(rng)),
+			sld.bound.PMin.Z + voxelSize.Z*
+				(float32(voxel[2])+randFloat32(rng)),
+		}
+		n := Normal3(uniformSampleSphere(
+			randFloat32(rng), randFloat32(rng)))
+		for i, light := range sld.lights {
+			LeDivPdf, pdf, _, _, _, _ := light.SampleLeFromPoint(
+				randFloat32(rng), randFloat32(rng),
+				randFloat32(rng), p, 0, n)
+			if LeDivPdf.IsBlack() || pdf == 0 ||
+				!LeDivPdf.IsValid() {
+				continue
+			}
+			contributions[i] += LeDivPdf.Y()
+		}
+	}
+
+	// Make sure that every light has a non-zero probability, since
+	// the contributions are only estimates and ignore visibility.
+	var sum float32
+	for _, contribution := range contributions {
+		sum += contribution
+	}
+	if sum > 0 {
+		minContribution :=
+			1e-3 * sum / float32(len(contributions))
+		for i := range contributions {
+			contributions[i] = maxFloat32(
+				contributions[i], minContribution)
+		}
+	} else {
+		for i := range contributions {
+			contributions[i] = 1
+		}
+	}
+	distribution := MakeDistribution1D(contributions)
+	return &distribution
+}
+
+func (sld *spatialLightDistribution) getDistribution(
+	p Point3) *Distribution1D {
+	voxel := sld.getVoxel(p)
+	voxelIndex := (voxel[2]*sld.resolution[1]+voxel[1])*
+		sld.resolution[0] + voxel[0]
+
+	sld.mutex.RLock()
+	distribution, ok := sld.distributions[voxelIndex]
+	sld.mutex.RUnlock()
+	if ok {
+		return distribution
+	}
+
+	// Computing the distribution is deterministic, so it doesn't
+	// matter if another goroutine computes it at the same time.
+	distribution = sld.computeVoxelDistribution(voxel, voxelIndex)
+	sld.mutex.Lock()
+	sld.distributions[voxelIndex] = distribution
+	sld.mutex.Unlock()
+	return distribution
+}
+
+func (sld *spatialLightDistribution) sample(
+	u float32, p Point3, n Normal3) (i int, pmf float32) {
+	distribution := sld.getDistribution(p)
+	return distribution.SampleDiscrete(u)
+}
+
+func (sld *spatialLightDistribution) computePmf(
+	i int, p Point3, n Normal3) float32 {
+	distribution := sld.getDistribution(p)
+	return distribution.ComputeDiscretePdf(i)
+}
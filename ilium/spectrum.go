@@ -17,6 +17,15 @@ func MakeRGBSpectrum(r, g, b float32) Spectrum {
 	return Spectrum{r, g, b}
 }
 
+// Converts the given CIE XYZ values to a Spectrum (whose components
+// are linear sRGB).
+func MakeXYZSpectrum(x, y, z float32) Spectrum {
+	r := 3.240479*x - 1.537150*y - 0.498535*z
+	g := -0.969256*x + 1.875991*y + 0.041556*z
+	b := 0.055648*x - 0.204043*y + 1.057311*z
+	return MakeRGBSpectrum(r, g, b)
+}
+
 func MakeSpectrumFromConfig(config map[string]interface{}) Spectrum {
 	spectrumType := config["type"].(string)
 	switch spectrumType {
@@ -64,6 +73,12 @@ func (out *Spectrum) Mul(s1, s2 *Spectrum) {
 	out.b = s1.b * s2.b
 }
 
+func (out *Spectrum) Div(s1, s2 *Spectrum) {
+	out.r = s1.r / s2.r
+	out.g = s1.g / s2.g
+	out.b = s1.b / s2.b
+}
+
 func (out *Spectrum) Scale(s *Spectrum, k float32) {
 	out.r = s.r * k
 	out.g = s.g * k
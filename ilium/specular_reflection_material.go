@@ -0,0 +1,55 @@
+package ilium
+
+// A SpecularReflectionMaterial is a perfect mirror, i.e. its BSDF is
+// a delta distribution that reflects wo about the normal. The
+// reflected light is scaled by rho and by an optional Fresnel term,
+// e.g. to model a smooth metal.
+type SpecularReflectionMaterial struct {
+	rho     Spectrum
+	fresnel Fresnel
+}
+
+func MakeSpecularReflectionMaterial(
+	config map[string]interface{}) *SpecularReflectionMaterial {
+	rhoConfig := config["rho"].(map[string]interface{})
+	rho := MakeSpectrumFromConfig(rhoConfig)
+	var fresnel Fresnel = &NoOpFresnel{}
+	if fresnelConfig, ok := config["fresnel"]; ok {
+		fresnel = MakeFresnel(fresnelConfig.(map[string]interface{}))
+	}
+	return &SpecularReflectionMaterial{rho, fresnel}
+}
+
+func (s *SpecularReflectionMaterial) SampleWi(
+	transportType MaterialTransportType,
+	u1, u2 float32, wo Vector3, n Normal3) (
+	wi Vector3, fDivPdf Spectrum, pdf float32) {
+	cosThO := wo.DotNormal(&n)
+	if cosThO <= 0 {
+		return
+	}
+	wi.Reflect(&wo, &n)
+	// f = rho * F * delta(wi - R(wo)) with respect to projected
+	// solid angle, which is sampled with probability 1, so f / pdf
+	// = rho * F.
+	F := s.fresnel.ComputeReflectance(cosThO)
+	fDivPdf.Mul(&s.rho, &F)
+	pdf = 1
+	return
+}
+
+func (s *SpecularReflectionMaterial) ComputeF(
+	transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) Spectrum {
+	return Spectrum{}
+}
+
+func (s *SpecularReflectionMaterial) ComputePdf(
+	transportType MaterialTransportType,
+	wo, wi Vector3, n Normal3) float32 {
+	return 0
+}
+
+func (s *SpecularReflectionMaterial) IsSpecular() bool {
+	return true
+}
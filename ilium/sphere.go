@@ -86,11 +86,49 @@ func (s *Sphere) Intersect(ray *Ray, intersection *Intersection) bool {
 		if s.flipNormal {
 			intersection.N.Flip(&intersection.N)
 		}
+		intersection.ShadingN = intersection.N
+		intersection.U, intersection.V, _ =
+			s.ComputeSurfaceUV(intersection.P)
+		intersection.DPDU, intersection.DPDV =
+			s.computeDPDUAndDPDV(&intersection.P)
 	}
 
 	return true
 }
 
+// Returns dP/du and dP/dv at the given point on the sphere.
+func (s *Sphere) computeDPDUAndDPDV(pSurface *Point3) (dpdu, dpdv Vector3) {
+	var r Vector3
+	r.GetOffset(&s.center, pSurface)
+	// u = phi / (2 * pi), so dP/du = 2 * pi * dP/d(phi), which
+	// is r rotated 90 degrees around the Z axis, projected onto
+	// the XY plane.
+	dpdu = Vector3{-2 * math.Pi * r.Y, 2 * math.Pi * r.X, 0}
+	// v = (pi - theta) / pi, so dP/dv = -pi * dP/d(theta), where
+	// dP/d(theta) = (r.Z * cos(phi), r.Z * sin(phi), -rho) and
+	// rho = radius * sin(theta), taking phi = 0 at the poles.
+	rho := float32(math.Sqrt(float64(r.X*r.X + r.Y*r.Y)))
+	cosPhi, sinPhi := float32(1), float32(0)
+	if rho > 0 {
+		cosPhi = r.X / rho
+		sinPhi = r.Y / rho
+	}
+	dpdv = Vector3{
+		-math.Pi * r.Z * cosPhi, -math.Pi * r.Z * sinPhi, math.Pi * rho,
+	}
+	return
+}
+
+func (s *Sphere) WorldBound() BBox {
+	r := Vector3{s.radius, s.radius, s.radius}
+	var pMin, pMax Point3
+	var negR Vector3
+	negR.Flip(&r)
+	pMin.Shift(&s.center, &negR)
+	pMax.Shift(&s.center, &r)
+	return BBox{pMin, pMax}
+}
+
 func (s *Sphere) SurfaceArea() float32 {
 	return 4 * math.Pi * s.radius * s.radius
 }
@@ -264,3 +302,42 @@ func (s *Sphere) ComputePdfFromPoint(
 
 	return 0
 }
+
+// The (u, v) coordinates of a sphere are u = phi / (2 * pi), with phi
+// measured around the Z axis from +X towards +Y, and v = (pi - theta)
+// / pi, with theta measured from +Z, so v = 0 at the bottom.
+func (s *Sphere) ComputeSurfaceUV(pSurface Point3) (u, v float32, ok bool) {
+	var r Vector3
+	r.GetOffset(&s.center, &pSurface)
+	d := r.Norm()
+	if d == 0 {
+		return
+	}
+	phi := float32(math.Atan2(float64(r.Y), float64(r.X)))
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+	u = minFloat32(phi/(2*math.Pi), _ONE_MINUS_EPSILON)
+	cosTheta := maxFloat32(-1, minFloat32(r.Z/d, 1))
+	theta := float32(math.Acos(float64(cosTheta)))
+	v = (math.Pi - theta) / math.Pi
+	ok = absFloat32(d-s.radius) <= _SPHERE_EPSILON_SCALE*s.radius
+	return
+}
+
+func (s *Sphere) ComputeSurfaceFromUV(u, v float32) (
+	pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, areaPerUV float32) {
+	sinTheta, cosTheta := sincosFloat32(math.Pi * (1 - v))
+	sinPhi, cosPhi := sincosFloat32(2 * math.Pi * u)
+	w := R3{sinTheta * cosPhi, sinTheta * sinPhi, cosTheta}
+	pSurface, pSurfaceEpsilon = s.solidAngleToPoint(w)
+	nSurface = Normal3(w)
+	if s.flipNormal {
+		nSurface.Flip(&nSurface)
+	}
+	// dA = radius^2 * sin(theta) * d(theta) * d(phi) = radius^2 *
+	// sin(theta) * pi * dv * 2 * pi * du.
+	areaPerUV = 2 * math.Pi * math.Pi * s.radius * s.radius * sinTheta
+	return
+}
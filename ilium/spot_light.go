@@ -0,0 +1,258 @@
+package ilium
+
+import "math"
+
+// A SpotLight is like a PointLight, except that it emits light only
+// within a cone around the direction to a target. The intensity is
+// constant within the inner cone and falls off smoothly to zero at
+// the outer cone.
+//
+// As with PointLight, the pdfs with respect to projected solid angle
+// at the light are computed as if the normal always pointed along
+// the direction in question.
+type SpotLight struct {
+	position          Point3
+	intensity         Spectrum
+	frontHat          Vector3
+	leftHat           Vector3
+	upHat             Vector3
+	cosInnerConeAngle float32
+	cosOuterConeAngle float32
+}
+
+func MakeSpotLight(
+	config map[string]interface{}, shapes []Shape) *SpotLight {
+	if len(shapes) != 1 {
+		panic("Spot light must have exactly one PointShape")
+	}
+	pointShape, ok := shapes[0].(*PointShape)
+	if !ok {
+		panic("Spot light must have exactly one PointShape")
+	}
+	intensityConfig := config["intensity"].(map[string]interface{})
+	intensity := MakeSpectrumFromConfig(intensityConfig)
+
+	position := pointShape.P
+	target := MakePoint3FromConfig(config["target"])
+	var frontHat Vector3
+	frontHat.GetOffset(&position, &target)
+	frontHat.Normalize(&frontHat)
+	var leftHat, upHat Vector3
+	MakeCoordinateSystemNoAlias(
+		(*R3)(&frontHat), (*R3)(&leftHat), (*R3)(&upHat))
+
+	// Both angles are in degrees, and are measured from the
+	// direction to the target.
+	innerConeAngle := float32(config["innerConeAngle"].(float64))
+	outerConeAngle := float32(config["outerConeAngle"].(float64))
+	if outerConeAngle <= 0 || outerConeAngle > 180 {
+		panic("outerConeAngle must be in (0, 180]")
+	}
+	if innerConeAngle < 0 || innerConeAngle > outerConeAngle {
+		panic("innerConeAngle must be in [0, outerConeAngle]")
+	}
+	_, cosInnerConeAngle := sincosFloat32(
+		innerConeAngle * (math.Pi / 180))
+	_, cosOuterConeAngle := sincosFloat32(
+		outerConeAngle * (math.Pi / 180))
+
+	return &SpotLight{
+		position:          position,
+		intensity:         intensity,
+		frontHat:          frontHat,
+		leftHat:           leftHat,
+		upHat:             upHat,
+		cosInnerConeAngle: cosInnerConeAngle,
+		cosOuterConeAngle: cosOuterConeAngle,
+	}
+}
+
+// Returns the fraction of the intensity emitted in a direction at
+// angle theta from the direction to the target, using a smoothstep
+// between the outer and inner cones.
+func (sl *SpotLight) computeFalloff(cosTheta float32) float32 {
+	if cosTheta >= sl.cosInnerConeAngle {
+		return 1
+	}
+	if cosTheta <= sl.cosOuterConeAngle {
+		return 0
+	}
+	t := (cosTheta - sl.cosOuterConeAngle) /
+		(sl.cosInnerConeAngle - sl.cosOuterConeAngle)
+	return t * t * (3 - 2*t)
+}
+
+// Returns the solid angle of the outer cone, which is used to split
+// up the emitted intensity into spatial and directional parts.
+func (sl *SpotLight) computeOuterConeSolidAngle() float32 {
+	return 2 * math.Pi * (1 - sl.cosOuterConeAngle)
+}
+
+func (sl *SpotLight) sampleConeDirection(
+	sampleBundle SampleBundle) Vector3 {
+	u1 := sampleBundle.Samples2D[0][0].U1
+	u2 := sampleBundle.Samples2D[0][0].U2
+	cosTheta, phi := uniformSampleCone(u1, u2, sl.cosOuterConeAngle)
+	r3Canonical := MakeSphericalDirection(cosTheta, phi)
+	var w R3
+	w.ConvertToCoordinateSystemNoAlias(&r3Canonical,
+		(*R3)(&sl.leftHat), (*R3)(&sl.upHat), (*R3)(&sl.frontHat))
+	return Vector3(w)
+}
+
+func (sl *SpotLight) HasSpecularPosition() bool {
+	return true
+}
+
+func (sl *SpotLight) HasSpecularDirection() bool {
+	return false
+}
+
+func (sl *SpotLight) GetSampleConfig() SampleConfig {
+	return SampleConfig{
+		Sample1DLengths: []int{},
+		Sample2DLengths: []int{1},
+	}
+}
+
+func (sl *SpotLight) SampleSurface(sampleBundle SampleBundle) (
+	pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, LeSpatialDivPdf Spectrum, pdf float32) {
+	pSurface = sl.position
+	// The pdf with respect to surface area is just 1 (with an
+	// implicit delta distribution).
+	LeSpatialDivPdf = sl.ComputeLeSpatial(pSurface)
+	pdf = 1
+	return
+}
+
+func (sl *SpotLight) SampleDirection(
+	sampleBundle SampleBundle, pSurface Point3, nSurface Normal3) (
+	wo Vector3, LeDirectionalDivPdf Spectrum, pdf float32) {
+	wo = sl.sampleConeDirection(sampleBundle)
+	falloff := sl.computeFalloff(wo.Dot(&sl.frontHat))
+	if falloff == 0 {
+		wo = Vector3{}
+		return
+	}
+	// LeDirectional = falloff / (outer cone solid angle) and
+	// pdf = 1 / (outer cone solid angle).
+	LeDirectionalDivPdf = MakeConstantSpectrum(falloff)
+	pdf = uniformConePdfSolidAngle(sl.cosOuterConeAngle)
+	return
+}
+
+func (sl *SpotLight) SampleRay(sampleBundle SampleBundle) (
+	ray Ray, LeDivPdf Spectrum, pdf float32) {
+	wo := sl.sampleConeDirection(sampleBundle)
+	falloff := sl.computeFalloff(wo.Dot(&sl.frontHat))
+	if falloff == 0 {
+		return
+	}
+	ray = Ray{sl.position, wo, 0, infFloat32(+1)}
+	// pdf = 1 / (outer cone solid angle).
+	LeDivPdf.Scale(&sl.intensity,
+		falloff*sl.computeOuterConeSolidAngle())
+	pdf = uniformConePdfSolidAngle(sl.cosOuterConeAngle)
+	return
+}
+
+func (sl *SpotLight) SampleLeFromPoint(
+	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
+	LeDivPdf Spectrum, pdf float32, wi Vector3,
+	pSurface Point3, nSurface Normal3, shadowRay Ray) {
+	r := wi.GetDirectionAndDistance(&p, &sl.position)
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	if absCosThI < PDF_COS_THETA_EPSILON || r < PDF_R_EPSILON {
+		wi = Vector3{}
+		return
+	}
+
+	var wo Vector3
+	wo.Flip(&wi)
+	falloff := sl.computeFalloff(wo.Dot(&sl.frontHat))
+	if falloff == 0 {
+		wi = Vector3{}
+		return
+	}
+
+	// The pdf w.r.t. surface area is just 1 (with an implicit
+	// delta distribution), so pdf = 1 / G(p <-> position) =
+	// r^2 / |cos(thI)|, and Le = intensity * falloff.
+	LeDivPdf.Scale(&sl.intensity, falloff*absCosThI/(r*r))
+	pdf = (r * r) / absCosThI
+	pSurface = sl.position
+	shadowRay = Ray{p, wi, pEpsilon, r * (1 - 5e-4)}
+	return
+}
+
+func (sl *SpotLight) ComputeLePdfFromPoint(
+	p Point3, pEpsilon float32, n Normal3, wi Vector3) float32 {
+	// Since we're assuming wi points towards the light, this is
+	// the same pdf as in SampleLeFromPoint().
+	r := sl.position.Distance(&p)
+	absCosThI := absFloat32(wi.DotNormal(&n))
+	return r * r / absCosThI
+}
+
+func (sl *SpotLight) ComputeLeSpatial(pSurface Point3) Spectrum {
+	var LeSpatial Spectrum
+	LeSpatial.Scale(&sl.intensity, sl.computeOuterConeSolidAngle())
+	return LeSpatial
+}
+
+func (sl *SpotLight) ComputeLeSpatialPdf(pSurface Point3) float32 {
+	// Since we're assuming pSurface is the light's position,
+	// return 1 even though we have a delta spatial distribution.
+	return 1
+}
+
+func (sl *SpotLight) ComputeLeDirectional(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	falloff := sl.computeFalloff(wo.Dot(&sl.frontHat))
+	return MakeConstantSpectrum(
+		falloff / sl.computeOuterConeSolidAngle())
+}
+
+func (sl *SpotLight) ComputeLeDirectionalPdf(
+	pSurface Point3, nSurface Normal3, wo Vector3) float32 {
+	if wo.Dot(&sl.frontHat) < sl.cosOuterConeAngle {
+		return 0
+	}
+	return uniformConePdfSolidAngle(sl.cosOuterConeAngle)
+}
+
+func (sl *SpotLight) ComputeLe(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	var Le Spectrum
+	Le.Scale(&sl.intensity,
+		sl.computeFalloff(wo.Dot(&sl.frontHat)))
+	return Le
+}
+
+func (sl *SpotLight) Power() Spectrum {
+	// The integral of the smoothstep falloff between the cones
+	// with respect to cos(theta) is half the difference of their
+	// cosines.
+	var power Spectrum
+	power.Scale(&sl.intensity, 2*math.Pi*
+		(1-0.5*(sl.cosInnerConeAngle+sl.cosOuterConeAngle)))
+	return power
+}
+
+func (sl *SpotLight) getLightBounds() (lightBounds, bool) {
+	power := sl.Power()
+	// Treat the inner cone as the normals, and the falloff
+	// region as the spread of the emission around them, which
+	// may be zero for spot lights with a hard edge.
+	thetaInner := float32(math.Acos(float64(sl.cosInnerConeAngle)))
+	thetaOuter := float32(math.Acos(float64(sl.cosOuterConeAngle)))
+	_, cosThetaE := sincosFloat32(maxFloat32(
+		thetaOuter-thetaInner, _LIGHT_BOUNDS_MIN_THETA_E))
+	return lightBounds{
+		bound:      MakeBBoxFromPoint(sl.position),
+		power:      power.Y(),
+		normalCone: directionCone{sl.frontHat, sl.cosInnerConeAngle},
+		cosThetaE:  cosThetaE,
+	}, true
+}
@@ -0,0 +1,123 @@
+package ilium
+
+import "math"
+import "strings"
+
+// A SpectrumTexture gives a spectrum for every point (u, v) of a
+// surface parametrization. Textures repeat outside of [0, 1)^2.
+type SpectrumTexture interface {
+	Evaluate(u, v float32) Spectrum
+}
+
+// A ConstantTexture has the same spectrum everywhere.
+type ConstantTexture struct {
+	value Spectrum
+}
+
+func MakeConstantTexture(config map[string]interface{}) *ConstantTexture {
+	valueConfig := config["value"].(map[string]interface{})
+	value := MakeSpectrumFromConfig(valueConfig)
+	return &ConstantTexture{value}
+}
+
+func (ct *ConstantTexture) Evaluate(u, v float32) Spectrum {
+	return ct.value
+}
+
+func MakeSpectrumTexture(config map[string]interface{}) SpectrumTexture {
+	textureType := config["type"].(string)
+	switch textureType {
+	case "ConstantTexture":
+		return MakeConstantTexture(config)
+	case "ImageTexture":
+		return MakeImageTexture(config)
+	case "CheckerboardTexture":
+		return MakeCheckerboardTexture(config)
+	default:
+		panic("unknown texture type " + textureType)
+	}
+}
+
+// Reads a material parameter that's either a spectrum or a texture,
+// which is told apart by its type, and returns either the spectrum
+// and a nil texture, or the texture.
+func makeSpectrumOrTextureFromConfig(config map[string]interface{}) (
+	value Spectrum, texture SpectrumTexture) {
+	if strings.HasSuffix(config["type"].(string), "Texture") {
+		texture = MakeSpectrumTexture(config)
+		return
+	}
+	value = MakeSpectrumFromConfig(config)
+	return
+}
+
+// Reads a scalar material parameter that's either a number or a
+// texture, and returns either the number and a nil texture, or the
+// texture, which is used via evaluateFloatTexture().
+func makeFloatOrTextureFromConfig(config interface{}) (
+	value float32, texture SpectrumTexture) {
+	if textureConfig, ok := config.(map[string]interface{}); ok {
+		texture = MakeSpectrumTexture(textureConfig)
+		return
+	}
+	value = float32(config.(float64))
+	return
+}
+
+// Returns the average of the channels of the given texture at (u, v),
+// for textures that give scalar parameters.
+func evaluateFloatTexture(texture SpectrumTexture, u, v float32) float32 {
+	s := texture.Evaluate(u, v)
+	r, g, b := s.ToRGB()
+	return (r + g + b) / 3
+}
+
+// Returns x - floor(x), which is in [0, 1).
+func wrapTextureCoordinate(x float32) float32 {
+	x -= float32(math.Floor(float64(x)))
+	if x >= 1 {
+		return 0
+	}
+	return x
+}
+
+// Tabulates the luminance of the given texture over an nu x nv grid
+// of [0, 1)^2 (with rows going up in v), weighted by the given
+// function, for use with a Distribution2D.
+//
+// Each cell gets the largest value at its center and its corners,
+// which is non-zero wherever a bilinearly-filtered texture is
+// non-zero in the cell. A small fraction of the average is then
+// added to every cell so that no part of the texture with non-zero
+// luminance can be missed, e.g. by procedural textures with details
+// smaller than a cell.
+func tabulateSpectrumTexture(texture SpectrumTexture, nu, nv int,
+	weight func(u, v float32) float32) []float32 {
+	f := make([]float32, nu*nv)
+	var total float32
+	for j := 0; j < nv; j++ {
+		for i := 0; i < nu; i++ {
+			u0 := float32(i) / float32(nu)
+			v0 := float32(j) / float32(nv)
+			u1 := float32(i+1) / float32(nu)
+			v1 := float32(j+1) / float32(nv)
+			uc := (float32(i) + 0.5) / float32(nu)
+			vc := (float32(j) + 0.5) / float32(nv)
+			points := [5][2]float32{
+				{uc, vc}, {u0, v0}, {u1, v0}, {u0, v1}, {u1, v1},
+			}
+			var y float32
+			for _, uv := range points {
+				t := texture.Evaluate(uv[0], uv[1])
+				y = maxFloat32(y, t.Y())
+			}
+			f[j*nu+i] = y * weight(uc, vc)
+			total += f[j*nu+i]
+		}
+	}
+	floor := 1e-3 * total / float32(nu*nv)
+	for i := 0; i < len(f); i++ {
+		f[i] += floor
+	}
+	return f
+}
@@ -48,25 +48,79 @@ func (pathTypes TracerPathType) HasPaths(paths TracerPathType) bool {
 	return (pathTypes & paths) == paths
 }
 
+// Describes which interior vertices of a path have specular
+// materials, which rules out alternate paths that connect to the
+// sensor or a light from those vertices.
+type TracerSpecularVertices int
+
+const (
+	// The interior vertex adjacent to the sensor vertex.
+	TRACER_SPECULAR_SENSOR_NEIGHBOR TracerSpecularVertices = 1 << iota
+	// The interior vertex adjacent to the light vertex.
+	TRACER_SPECULAR_LIGHT_NEIGHBOR TracerSpecularVertices = 1 << iota
+)
+
+func (specularVertices TracerSpecularVertices) HasVertices(
+	vertices TracerSpecularVertices) bool {
+	return (specularVertices & vertices) == vertices
+}
+
+// Returns whether the given alternate path type can generate a path
+// with the given number of edges between the given sensor and
+// light. Either of those may be nil if it isn't known, as long as
+// the answer doesn't depend on it; otherwise, this panics instead of
+// guessing.
 func (pathTypes TracerPathType) HasAlternatePath(
-	alternatePathType TracerPathType, edgeCount int, sensor Sensor) bool {
+	alternatePathType TracerPathType, edgeCount int, sensor Sensor,
+	light Light, specularVertices TracerSpecularVertices) bool {
 	if !pathTypes.HasPaths(alternatePathType) {
 		return false
 	}
 
 	switch alternatePathType {
 	case TRACER_EMITTED_LIGHT_PATH:
-		return true
+		if light == nil {
+			panic("light must be known for emitted light paths")
+		}
+		// Lights with a specular position or direction can't
+		// be hit.
+		return !light.HasSpecularPosition() &&
+			!light.HasSpecularDirection()
 
 	case TRACER_DIRECT_LIGHTING_PATH:
-		// Direct lighting isn't done with the first edge.
-		return edgeCount > 1
+		// Direct lighting isn't done with the first edge, nor
+		// from specular vertices.
+		return edgeCount > 1 && !specularVertices.HasVertices(
+			TRACER_SPECULAR_LIGHT_NEIGHBOR)
 
 	case TRACER_EMITTED_IMPORTANCE_PATH:
+		if sensor == nil {
+			panic("sensor must be known for emitted " +
+				"importance paths")
+		}
 		return !sensor.HasSpecularPosition()
 
 	case TRACER_DIRECT_SENSOR_PATH:
-		return !sensor.HasSpecularDirection()
+		if sensor == nil {
+			panic("sensor must be known for direct sensor paths")
+		}
+		// Direct sensor sampling isn't done from lights with
+		// a specular position, since there's no surface
+		// normal there, nor from lights with a specular
+		// direction.
+		if edgeCount <= 1 {
+			if light == nil {
+				panic("light must be known for direct " +
+					"sensor paths with one edge")
+			}
+			if light.HasSpecularPosition() ||
+				light.HasSpecularDirection() {
+				return false
+			}
+		}
+		return !sensor.HasSpecularDirection() &&
+			!specularVertices.HasVertices(
+				TRACER_SPECULAR_SENSOR_NEIGHBOR)
 
 	default:
 		panic(fmt.Sprintf(
@@ -75,26 +129,31 @@ func (pathTypes TracerPathType) HasAlternatePath(
 }
 
 func (pathTypes TracerPathType) ComputePathCount(
-	edgeCount int, sensor Sensor) int {
+	edgeCount int, sensor Sensor, light Light,
+	specularVertices TracerSpecularVertices) int {
 	var pathCount int = 0
 
 	if pathTypes.HasAlternatePath(
-		TRACER_EMITTED_LIGHT_PATH, edgeCount, sensor) {
+		TRACER_EMITTED_LIGHT_PATH, edgeCount, sensor,
+		light, specularVertices) {
 		pathCount++
 	}
 
 	if pathTypes.HasAlternatePath(
-		TRACER_DIRECT_LIGHTING_PATH, edgeCount, sensor) {
+		TRACER_DIRECT_LIGHTING_PATH, edgeCount, sensor,
+		light, specularVertices) {
 		pathCount++
 	}
 
 	if pathTypes.HasAlternatePath(
-		TRACER_EMITTED_IMPORTANCE_PATH, edgeCount, sensor) {
+		TRACER_EMITTED_IMPORTANCE_PATH, edgeCount, sensor,
+		light, specularVertices) {
 		pathCount++
 	}
 
 	if pathTypes.HasAlternatePath(
-		TRACER_DIRECT_SENSOR_PATH, edgeCount, sensor) {
+		TRACER_DIRECT_SENSOR_PATH, edgeCount, sensor,
+		light, specularVertices) {
 		pathCount++
 	}
 
@@ -143,15 +202,28 @@ func MakeTracerWeighingMethod(weighingMethodString string) (
 	}
 }
 
+// Returns the pdf to use in the weights for the given direction
+// through the given material, where isSpecular is whether the
+// direction goes through a specular component of the material (see
+// sampleMaterialWi()).
 func ComputePdfForWeight(
 	weighingMethod TracerWeighingMethod,
 	russianRouletteState *RussianRouletteState,
-	material Material, transportType MaterialTransportType,
+	material Material, isSpecular bool,
+	transportType MaterialTransportType,
 	wo, wi Vector3, n Normal3) float32 {
 	switch weighingMethod {
 	case TRACER_UNIFORM_WEIGHTS:
 		return 1
 	case TRACER_POWER_WEIGHTS:
+		if isSpecular {
+			// The delta distributions of specular
+			// vertices cancel out between paths that
+			// both sample through them, so leave them
+			// (and the Russian roulette probability)
+			// out of the weights.
+			return 1
+		}
 		pdf := material.ComputePdf(transportType, wo, wi, n)
 		var pContinue float32 = 1
 		if pdf > 0 && russianRouletteState != nil {
@@ -0,0 +1,289 @@
+package ilium
+
+import "fmt"
+import "math"
+
+// An affine transform, stored as a 4x4 matrix along with its
+// inverse.
+type Transform struct {
+	m, mInv [4][4]float32
+}
+
+func makeIdentityMatrix() [4][4]float32 {
+	return [4][4]float32{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+func multiplyMatrices(m1, m2 *[4][4]float32) [4][4]float32 {
+	var m [4][4]float32
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				m[i][j] += m1[i][k] * m2[k][j]
+			}
+		}
+	}
+	return m
+}
+
+func transposeMatrix(m *[4][4]float32) [4][4]float32 {
+	var t [4][4]float32
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			t[i][j] = m[j][i]
+		}
+	}
+	return t
+}
+
+// Inverts the given matrix with Gauss-Jordan elimination with
+// partial pivoting, and returns false if it is singular.
+func invertMatrix(m *[4][4]float32) (mInv [4][4]float32, ok bool) {
+	var a [4][8]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			a[i][j] = float64(m[i][j])
+		}
+		a[i][4+i] = 1
+	}
+	for col := 0; col < 4; col++ {
+		pivot := col
+		for i := col + 1; i < 4; i++ {
+			if math.Abs(a[i][col]) > math.Abs(a[pivot][col]) {
+				pivot = i
+			}
+		}
+		if a[pivot][col] == 0 {
+			return
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		invPivot := 1 / a[col][col]
+		for j := 0; j < 8; j++ {
+			a[col][j] *= invPivot
+		}
+		for i := 0; i < 4; i++ {
+			if i == col {
+				continue
+			}
+			k := a[i][col]
+			for j := 0; j < 8; j++ {
+				a[i][j] -= k * a[col][j]
+			}
+		}
+	}
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			mInv[i][j] = float32(a[i][4+j])
+		}
+	}
+	ok = true
+	return
+}
+
+func MakeIdentityTransform() Transform {
+	return Transform{makeIdentityMatrix(), makeIdentityMatrix()}
+}
+
+// Makes a transform from the given matrix, which must be invertible
+// and have (0, 0, 0, 1) as its last row.
+func MakeTransformFromMatrix(m [4][4]float32) Transform {
+	if m[3][0] != 0 || m[3][1] != 0 || m[3][2] != 0 || m[3][3] != 1 {
+		panic(fmt.Sprintf("matrix %v is not affine", m))
+	}
+	mInv, ok := invertMatrix(&m)
+	if !ok {
+		panic(fmt.Sprintf("matrix %v is not invertible", m))
+	}
+	return Transform{m, mInv}
+}
+
+func MakeTranslation(delta Vector3) Transform {
+	m := makeIdentityMatrix()
+	m[0][3] = delta.X
+	m[1][3] = delta.Y
+	m[2][3] = delta.Z
+	mInv := makeIdentityMatrix()
+	mInv[0][3] = -delta.X
+	mInv[1][3] = -delta.Y
+	mInv[2][3] = -delta.Z
+	return Transform{m, mInv}
+}
+
+func MakeScale(x, y, z float32) Transform {
+	if x == 0 || y == 0 || z == 0 {
+		panic("scale factors must be non-zero")
+	}
+	m := makeIdentityMatrix()
+	m[0][0] = x
+	m[1][1] = y
+	m[2][2] = z
+	mInv := makeIdentityMatrix()
+	mInv[0][0] = 1 / x
+	mInv[1][1] = 1 / y
+	mInv[2][2] = 1 / z
+	return Transform{m, mInv}
+}
+
+// Makes a transform that rotates counter-clockwise by the given angle
+// (in degrees) around the given axis.
+func MakeRotation(angleDegrees float32, axis Vector3) Transform {
+	var a Vector3
+	a.Normalize(&axis)
+	sinTh, cosTh := sincosFloat32(angleDegrees * (math.Pi / 180))
+	m := makeIdentityMatrix()
+	m[0][0] = a.X*a.X + (1-a.X*a.X)*cosTh
+	m[0][1] = a.X*a.Y*(1-cosTh) - a.Z*sinTh
+	m[0][2] = a.X*a.Z*(1-cosTh) + a.Y*sinTh
+	m[1][0] = a.X*a.Y*(1-cosTh) + a.Z*sinTh
+	m[1][1] = a.Y*a.Y + (1-a.Y*a.Y)*cosTh
+	m[1][2] = a.Y*a.Z*(1-cosTh) - a.X*sinTh
+	m[2][0] = a.X*a.Z*(1-cosTh) - a.Y*sinTh
+	m[2][1] = a.Y*a.Z*(1-cosTh) + a.X*sinTh
+	m[2][2] = a.Z*a.Z + (1-a.Z*a.Z)*cosTh
+	// Rotation matrices are orthogonal.
+	return Transform{m, transposeMatrix(&m)}
+}
+
+// Makes a transform from a list of translate, rotate, scale, and
+// matrix operations. The operations are composed in the order given,
+// so the last one is the first to be applied to a point.
+func MakeTransformFromConfig(config interface{}) Transform {
+	arrayConfig := config.([]interface{})
+	t := MakeIdentityTransform()
+	for _, o := range arrayConfig {
+		opConfig := o.(map[string]interface{})
+		var opT Transform
+		opType := opConfig["type"].(string)
+		switch opType {
+		case "translate":
+			opT = MakeTranslation(
+				MakeVector3FromConfig(opConfig["delta"]))
+		case "rotate":
+			angle := float32(opConfig["angle"].(float64))
+			axis := MakeVector3FromConfig(opConfig["axis"])
+			opT = MakeRotation(angle, axis)
+		case "scale":
+			if k, ok := opConfig["factor"].(float64); ok {
+				opT = MakeScale(
+					float32(k), float32(k), float32(k))
+			} else {
+				r := MakeR3FromConfig(opConfig["factors"])
+				opT = MakeScale(r.X, r.Y, r.Z)
+			}
+		case "matrix":
+			elementsConfig := opConfig["elements"].([]interface{})
+			if len(elementsConfig) != 16 {
+				panic("matrix must have 16 elements")
+			}
+			var m [4][4]float32
+			for i := 0; i < 16; i++ {
+				m[i/4][i%4] = float32(
+					elementsConfig[i].(float64))
+			}
+			opT = MakeTransformFromMatrix(m)
+		default:
+			panic("unknown transform type " + opType)
+		}
+		t.Compose(&t, &opT)
+	}
+	return t
+}
+
+// Sets out to the transform that applies t2 and then t1.
+func (out *Transform) Compose(t1, t2 *Transform) {
+	m := multiplyMatrices(&t1.m, &t2.m)
+	mInv := multiplyMatrices(&t2.mInv, &t1.mInv)
+	out.m = m
+	out.mInv = mInv
+}
+
+func (t *Transform) Inverse() Transform {
+	return Transform{t.mInv, t.m}
+}
+
+// Returns whether or not the transform preserves distances (i.e.,
+// it consists of only rotations, reflections, and translations).
+func (t *Transform) IsRigid() bool {
+	const epsilon = 1e-4
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			var dot float32
+			for k := 0; k < 3; k++ {
+				dot += t.m[k][i] * t.m[k][j]
+			}
+			var expected float32
+			if i == j {
+				expected = 1
+			}
+			if absFloat32(dot-expected) > epsilon {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (t *Transform) TransformPoint(p Point3) Point3 {
+	m := &t.m
+	return Point3{
+		m[0][0]*p.X + m[0][1]*p.Y + m[0][2]*p.Z + m[0][3],
+		m[1][0]*p.X + m[1][1]*p.Y + m[1][2]*p.Z + m[1][3],
+		m[2][0]*p.X + m[2][1]*p.Y + m[2][2]*p.Z + m[2][3],
+	}
+}
+
+func (t *Transform) TransformVector(v Vector3) Vector3 {
+	m := &t.m
+	return Vector3{
+		m[0][0]*v.X + m[0][1]*v.Y + m[0][2]*v.Z,
+		m[1][0]*v.X + m[1][1]*v.Y + m[1][2]*v.Z,
+		m[2][0]*v.X + m[2][1]*v.Y + m[2][2]*v.Z,
+	}
+}
+
+// Transforms the given normal by the inverse transpose of the
+// transform, so that it stays perpendicular to transformed
+// surfaces. The returned normal is not normalized.
+func (t *Transform) TransformNormal(n Normal3) Normal3 {
+	mInv := &t.mInv
+	return Normal3{
+		mInv[0][0]*n.X + mInv[1][0]*n.Y + mInv[2][0]*n.Z,
+		mInv[0][1]*n.X + mInv[1][1]*n.Y + mInv[2][1]*n.Z,
+		mInv[0][2]*n.X + mInv[1][2]*n.Y + mInv[2][2]*n.Z,
+	}
+}
+
+// Transforms the origin and direction of the given ray, leaving its
+// parameter range alone. (The direction is not normalized.)
+func (t *Transform) TransformRay(ray Ray) Ray {
+	return Ray{
+		t.TransformPoint(ray.O), t.TransformVector(ray.D),
+		ray.MinT, ray.MaxT,
+	}
+}
+
+func (t *Transform) TransformBBox(b BBox) BBox {
+	if b.IsEmpty() {
+		return b
+	}
+	tb := MakeEmptyBBox()
+	for i := 0; i < 8; i++ {
+		corner := b.PMin
+		if i&1 != 0 {
+			corner.X = b.PMax.X
+		}
+		if i&2 != 0 {
+			corner.Y = b.PMax.Y
+		}
+		if i&4 != 0 {
+			corner.Z = b.PMax.Z
+		}
+		tCorner := t.TransformPoint(corner)
+		tb.UnionPoint(&tb, &tCorner)
+	}
+	return tb
+}
@@ -0,0 +1,158 @@
+package ilium
+
+// A wrapper that places a Light, defined in object space, into world
+// space with a rigid transform. (Non-rigid transforms would change
+// the pdfs with respect to surface area and projected solid angle in
+// ways that can't be computed from the Light interface alone.)
+type transformedLight struct {
+	light         Light
+	objectToWorld Transform
+	worldToObject Transform
+}
+
+func makeTransformedLight(
+	light Light, objectToWorld Transform) *transformedLight {
+	if !objectToWorld.IsRigid() {
+		panic("lights can only be instanced with rigid transforms")
+	}
+	return &transformedLight{
+		light, objectToWorld, objectToWorld.Inverse(),
+	}
+}
+
+// Leaves zero normals (e.g., from lights with a specular position)
+// alone.
+func transformNormalNormalized(t *Transform, n Normal3) Normal3 {
+	tn := t.TransformNormal(n)
+	if tn == (Normal3{}) {
+		return tn
+	}
+	tn.Normalize(&tn)
+	return tn
+}
+
+func (tl *transformedLight) HasSpecularPosition() bool {
+	return tl.light.HasSpecularPosition()
+}
+
+func (tl *transformedLight) HasSpecularDirection() bool {
+	return tl.light.HasSpecularDirection()
+}
+
+func (tl *transformedLight) GetSampleConfig() SampleConfig {
+	return tl.light.GetSampleConfig()
+}
+
+func (tl *transformedLight) SampleSurface(sampleBundle SampleBundle) (
+	pSurface Point3, pSurfaceEpsilon float32,
+	nSurface Normal3, LeSpatialDivPdf Spectrum, pdf float32) {
+	pSurface, pSurfaceEpsilon, nSurface, LeSpatialDivPdf, pdf =
+		tl.light.SampleSurface(sampleBundle)
+	pSurface = tl.objectToWorld.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&tl.objectToWorld, nSurface)
+	return
+}
+
+func (tl *transformedLight) SampleDirection(
+	sampleBundle SampleBundle, pSurface Point3, nSurface Normal3) (
+	wo Vector3, LeDirectionalDivPdf Spectrum, pdf float32) {
+	pSurface = tl.worldToObject.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&tl.worldToObject, nSurface)
+	wo, LeDirectionalDivPdf, pdf =
+		tl.light.SampleDirection(sampleBundle, pSurface, nSurface)
+	wo = tl.objectToWorld.TransformVector(wo)
+	return
+}
+
+func (tl *transformedLight) SampleRay(sampleBundle SampleBundle) (
+	ray Ray, LeDivPdf Spectrum, pdf float32) {
+	ray, LeDivPdf, pdf = tl.light.SampleRay(sampleBundle)
+	ray = tl.objectToWorld.TransformRay(ray)
+	return
+}
+
+func (tl *transformedLight) SampleLeFromPoint(
+	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
+	LeDivPdf Spectrum, pdf float32, wi Vector3,
+	pSurface Point3, nSurface Normal3, shadowRay Ray) {
+	p = tl.worldToObject.TransformPoint(p)
+	n = transformNormalNormalized(&tl.worldToObject, n)
+	LeDivPdf, pdf, wi, pSurface, nSurface, shadowRay =
+		tl.light.SampleLeFromPoint(u, v1, v2, p, pEpsilon, n)
+	wi = tl.objectToWorld.TransformVector(wi)
+	pSurface = tl.objectToWorld.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&tl.objectToWorld, nSurface)
+	shadowRay = tl.objectToWorld.TransformRay(shadowRay)
+	return
+}
+
+func (tl *transformedLight) ComputeLePdfFromPoint(
+	p Point3, pEpsilon float32, n Normal3, wi Vector3) float32 {
+	p = tl.worldToObject.TransformPoint(p)
+	n = transformNormalNormalized(&tl.worldToObject, n)
+	wi = tl.worldToObject.TransformVector(wi)
+	return tl.light.ComputeLePdfFromPoint(p, pEpsilon, n, wi)
+}
+
+func (tl *transformedLight) ComputeLeSpatial(pSurface Point3) Spectrum {
+	pSurface = tl.worldToObject.TransformPoint(pSurface)
+	return tl.light.ComputeLeSpatial(pSurface)
+}
+
+func (tl *transformedLight) ComputeLeSpatialPdf(pSurface Point3) float32 {
+	pSurface = tl.worldToObject.TransformPoint(pSurface)
+	return tl.light.ComputeLeSpatialPdf(pSurface)
+}
+
+func (tl *transformedLight) ComputeLeDirectional(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	pSurface = tl.worldToObject.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&tl.worldToObject, nSurface)
+	wo = tl.worldToObject.TransformVector(wo)
+	return tl.light.ComputeLeDirectional(pSurface, nSurface, wo)
+}
+
+func (tl *transformedLight) ComputeLeDirectionalPdf(
+	pSurface Point3, nSurface Normal3, wo Vector3) float32 {
+	pSurface = tl.worldToObject.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&tl.worldToObject, nSurface)
+	wo = tl.worldToObject.TransformVector(wo)
+	return tl.light.ComputeLeDirectionalPdf(pSurface, nSurface, wo)
+}
+
+func (tl *transformedLight) ComputeLe(
+	pSurface Point3, nSurface Normal3, wo Vector3) Spectrum {
+	pSurface = tl.worldToObject.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&tl.worldToObject, nSurface)
+	wo = tl.worldToObject.TransformVector(wo)
+	return tl.light.ComputeLe(pSurface, nSurface, wo)
+}
+
+func (tl *transformedLight) computeLeWithUV(pSurface Point3, u, v float32,
+	nSurface Normal3, wo Vector3) Spectrum {
+	pSurface = tl.worldToObject.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&tl.worldToObject, nSurface)
+	wo = tl.worldToObject.TransformVector(wo)
+	return computeLightLeWithUV(tl.light, pSurface, u, v, nSurface, wo)
+}
+
+func (tl *transformedLight) Power() Spectrum {
+	// Rigid transforms don't change the emitted power.
+	return tl.light.Power()
+}
+
+func (tl *transformedLight) getLightBounds() (lightBounds, bool) {
+	bl, ok := tl.light.(boundedLight)
+	if !ok {
+		return lightBounds{}, false
+	}
+	bounds, ok := bl.getLightBounds()
+	if !ok {
+		return lightBounds{}, false
+	}
+	bounds.bound = tl.objectToWorld.TransformBBox(bounds.bound)
+	w := tl.objectToWorld.TransformVector(bounds.normalCone.w)
+	w.Normalize(&w)
+	bounds.normalCone.w = w
+	return bounds, true
+}
@@ -0,0 +1,126 @@
+package ilium
+
+// A wrapper that places a Sensor, defined in object space, into world
+// space with a rigid transform. (See transformedLight for why only
+// rigid transforms are supported.)
+//
+// If the same sensor is instanced more than once, all instances
+// record into (and emit) the same signal.
+type transformedSensor struct {
+	sensor        Sensor
+	objectToWorld Transform
+	worldToObject Transform
+}
+
+func makeTransformedSensor(
+	sensor Sensor, objectToWorld Transform) *transformedSensor {
+	if !objectToWorld.IsRigid() {
+		panic("sensors can only be instanced with rigid transforms")
+	}
+	return &transformedSensor{
+		sensor, objectToWorld, objectToWorld.Inverse(),
+	}
+}
+
+func (ts *transformedSensor) HasSpecularPosition() bool {
+	return ts.sensor.HasSpecularPosition()
+}
+
+func (ts *transformedSensor) HasSpecularDirection() bool {
+	return ts.sensor.HasSpecularDirection()
+}
+
+func (ts *transformedSensor) GetExtent() SensorExtent {
+	return ts.sensor.GetExtent()
+}
+
+func (ts *transformedSensor) GetSampleConfig() SampleConfig {
+	return ts.sensor.GetSampleConfig()
+}
+
+func (ts *transformedSensor) SampleRay(
+	x, y int, sampleBundle SampleBundle) (
+	ray Ray, WeDivPdf Spectrum, pdf float32) {
+	ray, WeDivPdf, pdf = ts.sensor.SampleRay(x, y, sampleBundle)
+	ray = ts.objectToWorld.TransformRay(ray)
+	return
+}
+
+func (ts *transformedSensor) SamplePixelPositionAndWeFromPoint(
+	u, v1, v2 float32, p Point3, pEpsilon float32, n Normal3) (
+	x, y int, WeDivPdf Spectrum, pdf float32, wi Vector3,
+	pSurface Point3, nSurface Normal3, shadowRay Ray) {
+	p = ts.worldToObject.TransformPoint(p)
+	n = transformNormalNormalized(&ts.worldToObject, n)
+	x, y, WeDivPdf, pdf, wi, pSurface, nSurface, shadowRay =
+		ts.sensor.SamplePixelPositionAndWeFromPoint(
+			u, v1, v2, p, pEpsilon, n)
+	wi = ts.objectToWorld.TransformVector(wi)
+	pSurface = ts.objectToWorld.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&ts.objectToWorld, nSurface)
+	shadowRay = ts.objectToWorld.TransformRay(shadowRay)
+	return
+}
+
+func (ts *transformedSensor) ComputeWePdfFromPoint(
+	x, y int,
+	p Point3, pEpsilon float32, n Normal3, wi Vector3) float32 {
+	p = ts.worldToObject.TransformPoint(p)
+	n = transformNormalNormalized(&ts.worldToObject, n)
+	wi = ts.worldToObject.TransformVector(wi)
+	return ts.sensor.ComputeWePdfFromPoint(x, y, p, pEpsilon, n, wi)
+}
+
+func (ts *transformedSensor) ComputeWeSpatialPdf(pSurface Point3) float32 {
+	pSurface = ts.worldToObject.TransformPoint(pSurface)
+	return ts.sensor.ComputeWeSpatialPdf(pSurface)
+}
+
+func (ts *transformedSensor) ComputeWeDirectionalPdf(
+	x, y int, pSurface Point3, nSurface Normal3, wo Vector3) float32 {
+	pSurface = ts.worldToObject.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&ts.worldToObject, nSurface)
+	wo = ts.worldToObject.TransformVector(wo)
+	return ts.sensor.ComputeWeDirectionalPdf(x, y, pSurface, nSurface, wo)
+}
+
+func (ts *transformedSensor) ComputePixelPositionAndWe(
+	pSurface Point3, nSurface Normal3, wo Vector3) (
+	x, y int, We Spectrum) {
+	pSurface = ts.worldToObject.TransformPoint(pSurface)
+	nSurface = transformNormalNormalized(&ts.worldToObject, nSurface)
+	wo = ts.worldToObject.TransformVector(wo)
+	return ts.sensor.ComputePixelPositionAndWe(pSurface, nSurface, wo)
+}
+
+func (ts *transformedSensor) AccumulateSensorContribution(
+	x, y int, WeLiDivPdf Spectrum) {
+	ts.sensor.AccumulateSensorContribution(x, y, WeLiDivPdf)
+}
+
+func (ts *transformedSensor) AccumulateSensorDebugInfo(
+	tag string, x, y int, s Spectrum) {
+	ts.sensor.AccumulateSensorDebugInfo(tag, x, y, s)
+}
+
+func (ts *transformedSensor) RecordAccumulatedSensorContributions(x, y int) {
+	ts.sensor.RecordAccumulatedSensorContributions(x, y)
+}
+
+func (ts *transformedSensor) AccumulateLightContribution(
+	x, y int, WeLiDivPdf Spectrum) {
+	ts.sensor.AccumulateLightContribution(x, y, WeLiDivPdf)
+}
+
+func (ts *transformedSensor) AccumulateLightDebugInfo(
+	tag string, x, y int, s Spectrum) {
+	ts.sensor.AccumulateLightDebugInfo(tag, x, y, s)
+}
+
+func (ts *transformedSensor) RecordAccumulatedLightContributions() {
+	ts.sensor.RecordAccumulatedLightContributions()
+}
+
+func (ts *transformedSensor) EmitSignal(outputDir, outputExt string) {
+	ts.sensor.EmitSignal(outputDir, outputExt)
+}
@@ -1,10 +1,21 @@
 package ilium
 
+import "errors"
+import "path/filepath"
+import "strings"
+
 const _TRIANGLE_EPSILON_SCALE float32 = 1e-3
 
 type triangleMesh struct {
 	vertices []Point3
-	indices  [][3]int
+	// Per-vertex normals and texture coordinates, or nil if the
+	// mesh doesn't have them.
+	normals []Normal3
+	uvs     [][2]float32
+	indices [][3]int
+	// The name of the material group of this mesh (e.g., from
+	// a usemtl statement), or the empty string if it has none.
+	materialGroup string
 }
 
 type Triangle struct {
@@ -27,19 +38,64 @@ func makeIndicesFromConfig(config interface{}) [][3]int {
 	return indices
 }
 
+func readTriangleMeshesFromFile(path string) ([]*triangleMesh, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".obj":
+		return readTriangleMeshesFromObjFile(path)
+	case ".ply":
+		mesh, err := readTriangleMeshFromPlyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []*triangleMesh{mesh}, nil
+	default:
+		return nil, errors.New("unknown mesh file extension " + ext)
+	}
+}
+
 func MakeTriangleMesh(config map[string]interface{}) []Shape {
-	verticesConfig := config["vertices"].([]interface{})
-	vertices := MakePoint3sFromConfig(verticesConfig)
-	indicesConfig := config["indices"].([]interface{})
-	indices := makeIndicesFromConfig(indicesConfig)
-	mesh := triangleMesh{vertices, indices}
-	triangles := make([]Shape, len(indices))
-	for i := 0; i < len(triangles); i++ {
-		triangles[i] = &Triangle{&mesh, i}
+	var meshes []*triangleMesh
+	if pathConfig, ok := config["path"]; ok {
+		var err error
+		meshes, err = readTriangleMeshesFromFile(pathConfig.(string))
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		verticesConfig := config["vertices"].([]interface{})
+		vertices := MakePoint3sFromConfig(verticesConfig)
+		indicesConfig := config["indices"].([]interface{})
+		indices := makeIndicesFromConfig(indicesConfig)
+		var normals []Normal3
+		if normalsConfig, ok := config["normals"]; ok {
+			normals = MakeNormal3sFromConfig(normalsConfig)
+			if len(normals) != len(vertices) {
+				panic("there must be exactly one normal " +
+					"per vertex")
+			}
+		}
+		meshes = []*triangleMesh{
+			&triangleMesh{
+				vertices: vertices,
+				normals:  normals,
+				indices:  indices,
+			},
+		}
+	}
+	triangles := []Shape{}
+	for _, mesh := range meshes {
+		for i := 0; i < len(mesh.indices); i++ {
+			triangles = append(triangles, &Triangle{mesh, i})
+		}
 	}
 	return triangles
 }
 
+func (tr *Triangle) GetMaterialGroup() string {
+	return tr.mesh.materialGroup
+}
+
 func (tr *Triangle) getVertices() (p1, p2, p3 *Point3) {
 	p1 = &tr.mesh.vertices[tr.mesh.indices[tr.i][0]]
 	p2 = &tr.mesh.vertices[tr.mesh.indices[tr.i][1]]
@@ -92,11 +148,102 @@ func (tr *Triangle) Intersect(ray *Ray, intersection *Intersection) bool {
 		intersection.N.CrossVectorNoAlias(&e1, &e2)
 		intersection.N.Normalize(&intersection.N)
 		intersection.N.Normalize(&n)
+		uv1, uv2, uv3 := tr.getUVs()
+		b0 := 1 - b1 - b2
+		intersection.U = b0*uv1[0] + b1*uv2[0] + b2*uv3[0]
+		intersection.V = b0*uv1[1] + b1*uv2[1] + b2*uv3[1]
+		intersection.DPDU, intersection.DPDV =
+			tr.computeDPDUAndDPDV()
+		intersection.ShadingN = tr.computeShadingNormal(
+			b0, b1, b2, &intersection.N)
 	}
 
 	return true
 }
 
+// Returns the (u, v) coordinates of the vertices of the triangle,
+// which default to (0, 0), (1, 0), and (1, 1) if the mesh has none.
+func (tr *Triangle) getUVs() (uv1, uv2, uv3 [2]float32) {
+	if tr.mesh.uvs == nil {
+		return [2]float32{0, 0}, [2]float32{1, 0}, [2]float32{1, 1}
+	}
+	uv1 = tr.mesh.uvs[tr.mesh.indices[tr.i][0]]
+	uv2 = tr.mesh.uvs[tr.mesh.indices[tr.i][1]]
+	uv3 = tr.mesh.uvs[tr.mesh.indices[tr.i][2]]
+	return
+}
+
+// Returns dP/du and dP/dv, which are constant over the triangle. If
+// the (u, v) coordinates of the vertices are degenerate, returns
+// arbitrary vectors perpendicular to the normal and each other.
+func (tr *Triangle) computeDPDUAndDPDV() (dpdu, dpdv Vector3) {
+	p1, p2, p3 := tr.getVertices()
+	uv1, uv2, uv3 := tr.getUVs()
+	du13 := uv1[0] - uv3[0]
+	dv13 := uv1[1] - uv3[1]
+	du23 := uv2[0] - uv3[0]
+	dv23 := uv2[1] - uv3[1]
+	var dp13, dp23 Vector3
+	dp13.GetOffset(p3, p1)
+	dp23.GetOffset(p3, p2)
+	det := du13*dv23 - dv13*du23
+	if absFloat32(det) < 1e-8 {
+		_, _, n := tr.getEVectors()
+		k := R3(n)
+		k.Normalize(&k)
+		var i, j R3
+		MakeCoordinateSystemNoAlias(&k, &i, &j)
+		return Vector3(i), Vector3(j)
+	}
+	// Solve dp13 = du13 * dP/du + dv13 * dP/dv and dp23 = du23 *
+	// dP/du + dv23 * dP/dv.
+	var a, b Vector3
+	a.Scale(&dp13, dv23/det)
+	b.Scale(&dp23, dv13/det)
+	dpdu.Sub(&a, &b)
+	a.Scale(&dp23, du13/det)
+	b.Scale(&dp13, du23/det)
+	dpdv.Sub(&a, &b)
+	return
+}
+
+// Returns the per-vertex normals interpolated with the given
+// barycentric coordinates, flipped if necessary to be on the same
+// side as the given geometric normal n, or n itself if the mesh
+// doesn't have per-vertex normals.
+func (tr *Triangle) computeShadingNormal(
+	b0, b1, b2 float32, n *Normal3) Normal3 {
+	if tr.mesh.normals == nil {
+		return *n
+	}
+	indices := &tr.mesh.indices[tr.i]
+	n1 := R3(tr.mesh.normals[indices[0]])
+	n2 := R3(tr.mesh.normals[indices[1]])
+	n3 := R3(tr.mesh.normals[indices[2]])
+	var r, t R3
+	r.Scale(&n1, b0)
+	t.Scale(&n2, b1)
+	r.Add(&r, &t)
+	t.Scale(&n3, b2)
+	r.Add(&r, &t)
+	if r.NormSq() == 0 {
+		return *n
+	}
+	nShading := Normal3(r)
+	nShading.Normalize(&nShading)
+	if ((*R3)(&nShading)).Dot((*R3)(n)) < 0 {
+		nShading.Flip(&nShading)
+	}
+	return nShading
+}
+
+func (tr *Triangle) WorldBound() BBox {
+	p1, p2, p3 := tr.getVertices()
+	b := MakeBBoxFromPoints(*p1, *p2)
+	b.UnionPoint(&b, p3)
+	return b
+}
+
 func (tr *Triangle) SurfaceArea() float32 {
 	_, _, n := tr.getEVectors()
 	return 0.5 * n.Norm()
@@ -134,3 +281,48 @@ func (tr *Triangle) ComputePdfFromPoint(
 	p Point3, pEpsilon float32, n Normal3, wi Vector3) float32 {
 	return ComputeEntireSurfacePdfFromPoint(tr, p, pEpsilon, n, wi)
 }
+
+func (tr *Triangle) GetNormalBound() (axis Vector3, cosTheta float32) {
+	_, _, n := tr.getEVectors()
+	axis = Vector3(n)
+	axis.Normalize(&axis)
+	cosTheta = 1
+	return
+}
+
+// Returns the barycentric coordinates of the projection of p onto
+// the plane of the triangle with respect to its second and third
+// vertices, along with the distance from p to that plane.
+func (tr *Triangle) computeBarycentrics(p *Point3) (b2, b3, dist float32) {
+	p1, _, _ := tr.getVertices()
+	e1, e2, n := tr.getEVectors()
+	var d Vector3
+	d.GetOffset(p1, p)
+	d11 := e1.Dot(&e1)
+	d12 := e1.Dot(&e2)
+	d22 := e2.Dot(&e2)
+	dd1 := d.Dot(&e1)
+	dd2 := d.Dot(&e2)
+	denom := d11*d22 - d12*d12
+	if denom == 0 {
+		return
+	}
+	b2 = (d22*dd1 - d12*dd2) / denom
+	b3 = (d11*dd2 - d12*dd1) / denom
+	dist = absFloat32(d.DotNormal(&n)) / n.Norm()
+	return
+}
+
+func (tr *Triangle) ComputeSurfaceUV(
+	pSurface Point3) (u, v float32, ok bool) {
+	b2, b3, dist := tr.computeBarycentrics(&pSurface)
+	b1 := 1 - b2 - b3
+	uv1, uv2, uv3 := tr.getUVs()
+	u = b1*uv1[0] + b2*uv2[0] + b3*uv3[0]
+	v = b1*uv1[1] + b2*uv2[1] + b3*uv3[1]
+	const bEpsilon = 1e-3
+	epsilon := _TRIANGLE_EPSILON_SCALE * sqrtFloat32(tr.SurfaceArea())
+	ok = b1 >= -bEpsilon && b2 >= -bEpsilon && b3 >= -bEpsilon &&
+		dist <= epsilon
+	return
+}
@@ -45,6 +45,10 @@ func (out *Vector3) CrossNoAlias(v, w *Vector3) {
 	((*R3)(out)).CrossNoAlias((*R3)(v), (*R3)(w))
 }
 
+func (v *Vector3) Norm() float32 {
+	return ((*R3)(v)).Norm()
+}
+
 func (v *Vector3) NormSq() float32 {
 	return ((*R3)(v)).NormSq()
 }
@@ -52,3 +56,29 @@ func (v *Vector3) NormSq() float32 {
 func (out *Vector3) Normalize(v *Vector3) {
 	((*R3)(out)).Normalize((*R3)(v))
 }
+
+// Sets out to the reflection of v about n, which must be normalized.
+func (out *Vector3) Reflect(v *Vector3, n *Normal3) {
+	var s Vector3
+	s.Scale((*Vector3)(n), 2*v.DotNormal(n))
+	out.Sub(&s, v)
+}
+
+// Sets out to the refraction of v through a surface with normal n
+// (which must be normalized and on the same side as v), where eta is
+// the index of refraction on v's side divided by the one on the other
+// side. Returns false if there is total internal reflection, in which
+// case out is left alone.
+func (out *Vector3) Refract(v *Vector3, n *Normal3, eta float32) bool {
+	cosThI := v.DotNormal(n)
+	sinThT := eta * cosToSin(cosThI)
+	if sinThT >= 1 {
+		return false
+	}
+	cosThT := sinToCos(sinThT)
+	var a, b Vector3
+	a.Scale(v, -eta)
+	b.Scale((*Vector3)(n), eta*cosThI-cosThT)
+	out.Add(&a, &b)
+	return true
+}
@@ -0,0 +1,263 @@
+package ilium
+
+import "bufio"
+import "fmt"
+import "os"
+import "strconv"
+import "strings"
+
+// A vertex of a face in an OBJ file, as indices into the position,
+// texture coordinate, and normal lists (with -1 meaning absent).
+type objFaceVertex struct {
+	v, vt, vn int
+}
+
+type objReader struct {
+	path       string
+	lineNumber int
+
+	positions []Point3
+	uvs       [][2]float32
+	normals   []Normal3
+
+	// The vertices of the output meshes, built from the distinct
+	// (v, vt, vn) combinations used by faces.
+	vertexIndices map[objFaceVertex]int
+	vertices      []objFaceVertex
+
+	currentGroup string
+	groupIndices map[string][][3]int
+	groupOrder   []string
+}
+
+func (r *objReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", r.path, r.lineNumber,
+		fmt.Sprintf(format, args...))
+}
+
+func (r *objReader) parseFloats(fields []string, minCount int) (
+	[]float32, error) {
+	if len(fields) < minCount {
+		return nil, r.errorf("expected at least %d values, got %d",
+			minCount, len(fields))
+	}
+	values := make([]float32, len(fields))
+	for i, field := range fields {
+		value, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return nil, r.errorf("invalid number %q", field)
+		}
+		values[i] = float32(value)
+	}
+	return values, nil
+}
+
+// Converts a (1-based, possibly negative) OBJ index into a 0-based
+// index into a list of the given length.
+func (r *objReader) resolveIndex(field string, count int) (int, error) {
+	i, err := strconv.Atoi(field)
+	if err != nil {
+		return 0, r.errorf("invalid index %q", field)
+	}
+	if i < 0 {
+		i += count
+	} else {
+		i--
+	}
+	if i < 0 || i >= count {
+		return 0, r.errorf("index %s out of range", field)
+	}
+	return i, nil
+}
+
+func (r *objReader) parseFaceVertex(field string) (int, error) {
+	parts := strings.Split(field, "/")
+	if len(parts) > 3 {
+		return 0, r.errorf("invalid face vertex %q", field)
+	}
+	fv := objFaceVertex{-1, -1, -1}
+	var err error
+	fv.v, err = r.resolveIndex(parts[0], len(r.positions))
+	if err != nil {
+		return 0, err
+	}
+	if len(parts) > 1 && len(parts[1]) > 0 {
+		fv.vt, err = r.resolveIndex(parts[1], len(r.uvs))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(parts) > 2 && len(parts[2]) > 0 {
+		fv.vn, err = r.resolveIndex(parts[2], len(r.normals))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if i, ok := r.vertexIndices[fv]; ok {
+		return i, nil
+	}
+	i := len(r.vertices)
+	r.vertexIndices[fv] = i
+	r.vertices = append(r.vertices, fv)
+	return i, nil
+}
+
+func (r *objReader) parseFace(fields []string) error {
+	if len(fields) < 3 {
+		return r.errorf("face has fewer than 3 vertices")
+	}
+	indices := make([]int, len(fields))
+	for i, field := range fields {
+		index, err := r.parseFaceVertex(field)
+		if err != nil {
+			return err
+		}
+		indices[i] = index
+	}
+	if _, ok := r.groupIndices[r.currentGroup]; !ok {
+		r.groupOrder = append(r.groupOrder, r.currentGroup)
+	}
+	// Triangulate the polygon as a fan around its first vertex,
+	// which assumes that it is convex.
+	for i := 1; i+1 < len(indices); i++ {
+		r.groupIndices[r.currentGroup] = append(
+			r.groupIndices[r.currentGroup],
+			[3]int{indices[0], indices[i], indices[i+1]})
+	}
+	return nil
+}
+
+func (r *objReader) parseLine(line string) error {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch fields[0] {
+	case "v":
+		values, err := r.parseFloats(fields[1:], 3)
+		if err != nil {
+			return err
+		}
+		r.positions = append(
+			r.positions, Point3{values[0], values[1], values[2]})
+	case "vt":
+		values, err := r.parseFloats(fields[1:], 1)
+		if err != nil {
+			return err
+		}
+		uv := [2]float32{values[0], 0}
+		if len(values) > 1 {
+			uv[1] = values[1]
+		}
+		r.uvs = append(r.uvs, uv)
+	case "vn":
+		values, err := r.parseFloats(fields[1:], 3)
+		if err != nil {
+			return err
+		}
+		n := Normal3{values[0], values[1], values[2]}
+		n.Normalize(&n)
+		r.normals = append(r.normals, n)
+	case "f":
+		return r.parseFace(fields[1:])
+	case "usemtl":
+		if len(fields) > 1 {
+			r.currentGroup = fields[1]
+		} else {
+			r.currentGroup = ""
+		}
+	default:
+		// Ignore everything else (e.g., groups, smoothing
+		// groups, and material libraries).
+	}
+	return nil
+}
+
+// Builds one mesh per material group, with all meshes sharing the
+// same vertex data.
+func (r *objReader) makeTriangleMeshes() []*triangleMesh {
+	vertices := make([]Point3, len(r.vertices))
+	hasUVs := false
+	hasNormals := len(r.vertices) > 0
+	for i, fv := range r.vertices {
+		vertices[i] = r.positions[fv.v]
+		if fv.vt >= 0 {
+			hasUVs = true
+		}
+		if fv.vn < 0 {
+			hasNormals = false
+		}
+	}
+
+	// Missing texture coordinates default to (0, 0), but
+	// normals are used only if every vertex has one.
+	var uvs [][2]float32
+	if hasUVs {
+		uvs = make([][2]float32, len(r.vertices))
+		for i, fv := range r.vertices {
+			if fv.vt >= 0 {
+				uvs[i] = r.uvs[fv.vt]
+			}
+		}
+	}
+
+	var normals []Normal3
+	if hasNormals {
+		normals = make([]Normal3, len(r.vertices))
+		for i, fv := range r.vertices {
+			normals[i] = r.normals[fv.vn]
+		}
+	}
+
+	meshes := make([]*triangleMesh, len(r.groupOrder))
+	for i, group := range r.groupOrder {
+		meshes[i] = &triangleMesh{
+			vertices:      vertices,
+			normals:       normals,
+			uvs:           uvs,
+			indices:       r.groupIndices[group],
+			materialGroup: group,
+		}
+	}
+	return meshes
+}
+
+// Reads the OBJ file at the given path and returns one triangle mesh
+// per material group (as set by usemtl statements). Polygons are
+// triangulated, and per-vertex normals and texture coordinates are
+// kept if present.
+func readTriangleMeshesFromObjFile(path string) ([]*triangleMesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := objReader{
+		path:          path,
+		vertexIndices: make(map[objFaceVertex]int),
+		groupIndices:  make(map[string][][3]int),
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var continuedLine string
+	for scanner.Scan() {
+		r.lineNumber++
+		line := continuedLine + scanner.Text()
+		if strings.HasSuffix(line, "\\") {
+			continuedLine = line[:len(line)-1] + " "
+			continue
+		}
+		continuedLine = ""
+		if err := r.parseLine(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r.makeTriangleMeshes(), nil
+}